@@ -0,0 +1,160 @@
+package babel
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/blorb"
+	"github.com/JaredReisinger/xyzzybot/ink"
+	"github.com/JaredReisinger/xyzzybot/twine"
+	"github.com/JaredReisinger/xyzzybot/zmachine"
+)
+
+// The Treaty of Babel (http://babel.ifarchive.org/) defines the IFID, a
+// stable identifier for a work of interactive fiction that doesn't depend on
+// its file name.  For Z-code, the IFID is (in order of preference):
+//
+//   1. the contents of a Blorb's "IFID" chunk, if the story is Blorbed,
+//   2. a UUID embedded in the story as "UUID://...//" (Inform 6.30+ does this),
+//   3. otherwise, "ZCODE-<release>-<serial>", with "-<checksum>" appended
+//      unless the serial looks like a legacy Infocom one.
+
+// ZCodeFormat is the Treaty of Babel format name for Z-code stories.
+const ZCodeFormat = "zcode"
+
+// The Treaty doesn't cover Ink, which has no IFID of its own.  By convention,
+// an Ink story can declare one in a global tag ("# IFID: ..."); otherwise, we
+// use the Treaty's fallback for formats without IFIDs, the MD5 hash of the
+// file.  Twine 2 records an IFID for every story; Twine 1 stories get the
+// hash.
+
+var uuidPattern = regexp.MustCompile(`UUID://([0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12})//`)
+
+// IFID computes the IFID for a story file, which may be bare Z-code, a Blorb,
+// a compiled Ink story, or a Twine story.
+func IFID(b []byte) (string, error) {
+	if ink.IsStory(b) {
+		return InkIFID(b)
+	}
+
+	if twine.IsStory(b) {
+		return TwineIFID(b)
+	}
+
+	if blorb.IsBlorb(b) {
+		f, err := blorb.Parse(b)
+		if err != nil {
+			return "", err
+		}
+
+		if chunk := f.Form.Chunk("IFID"); chunk != nil {
+			return Normalize(string(chunk.Data)), nil
+		}
+
+		exec := f.Executable()
+		if exec == nil || exec.Type() != "ZCOD" {
+			return "", fmt.Errorf("Blorb does not contain Z-code")
+		}
+
+		b = exec.Data()
+	}
+
+	return ZCodeIFID(b)
+}
+
+// ZCodeIFID computes the IFID for bare Z-code.
+func ZCodeIFID(story []byte) (string, error) {
+	if m := uuidPattern.FindSubmatch(story); m != nil {
+		return Normalize(string(m[1])), nil
+	}
+
+	h, err := zmachine.ParseHeader(story)
+	if err != nil {
+		return "", err
+	}
+
+	return HeaderIFID(h), nil
+}
+
+// InkIFID computes the IFID for a compiled Ink story.
+func InkIFID(b []byte) (string, error) {
+	story, err := ink.Parse(b)
+	if err != nil {
+		return "", err
+	}
+
+	if ifid := story.GlobalTag("IFID"); ifid != "" {
+		return Normalize(ifid), nil
+	}
+	return HashIFID(b), nil
+}
+
+// TwineIFID computes the IFID for a Twine story (Twee source or published
+// HTML).
+func TwineIFID(b []byte) (string, error) {
+	story, err := twine.Parse(b)
+	if err != nil {
+		return "", err
+	}
+
+	if story.IFID != "" {
+		return Normalize(story.IFID), nil
+	}
+	return HashIFID(b), nil
+}
+
+// HashIFID computes the IFID for a story in a format that has no IFIDs of its
+// own: the MD5 hash of the file.
+func HashIFID(b []byte) string {
+	return fmt.Sprintf("%X", md5.Sum(b))
+}
+
+// HeaderIFID computes the header-based IFID for Z-code (which is what's used
+// when there's no embedded UUID).
+func HeaderIFID(h *zmachine.Header) string {
+	serial := make([]byte, 0, len(h.Serial))
+	for _, c := range []byte(h.Serial) {
+		if isAlnum(c) {
+			serial = append(serial, c)
+		}
+	}
+
+	ifid := fmt.Sprintf("ZCODE-%d-%s", h.Release, serial)
+
+	// Infocom-era games (serials starting with "8", or missing entirely)
+	// are identified without the checksum.
+	if len(serial) > 0 && string(serial) != "000000" && isDigit(serial[0]) && serial[0] != '8' {
+		ifid = fmt.Sprintf("%s-%04X", ifid, h.Checksum)
+	}
+
+	return ifid
+}
+
+// Normalize cleans up an IFID for comparison: surrounding whitespace is
+// removed, and it's upper-cased (IFIDs are case-insensitive).
+func Normalize(ifid string) string {
+	return strings.ToUpper(strings.TrimSpace(ifid))
+}
+
+// Equal reports whether two IFIDs are the same.  Some older tools formatted
+// the Z-code checksum as a sign-extended 32-bit value ("-FFFFA377" rather
+// than "-A377"), so we treat those as equivalent.
+func Equal(a string, b string) bool {
+	return legacyChecksum(Normalize(a)) == legacyChecksum(Normalize(b))
+}
+
+var legacyChecksumPattern = regexp.MustCompile(`^(ZCODE-\d+-\w+-)FFFF([0-9A-F]{4})$`)
+
+func legacyChecksum(ifid string) string {
+	return legacyChecksumPattern.ReplaceAllString(ifid, "$1$2")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
@@ -0,0 +1,330 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A backup is a single .tar.gz archive.  The first entry is always a JSON
+// manifest listing every file (with its size and SHA-256), and the rest of the
+// entries are the files themselves, named "<section>/<relative-path>".  A
+// section is one of the directories being backed up: the games, and the
+// working root (sessions, room saves, per-channel config, and so on).
+
+// ManifestVersion is the current manifest format version.
+const ManifestVersion = 1
+
+const manifestName = "manifest.json"
+
+// Source is a directory to include in a backup, as a named section.
+type Source struct {
+	Section   string
+	Directory string
+	Exclude   []string // top-level names within Directory to skip
+}
+
+// Manifest describes the contents of a backup archive.
+type Manifest struct {
+	Version  int
+	Created  time.Time
+	Host     string
+	Sections []*SectionInfo
+	Files    []*FileEntry
+}
+
+// SectionInfo summarizes one section of a backup.
+type SectionInfo struct {
+	Name      string
+	Directory string // where it was backed up from
+	Files     int
+	Bytes     int64
+}
+
+// FileEntry describes a single file in a backup.
+type FileEntry struct {
+	Section string
+	Path    string // relative to the section's directory, slash-separated
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	SHA256  string
+}
+
+func (e *FileEntry) archiveName() string {
+	return path.Join(e.Section, e.Path)
+}
+
+// Section returns the named section, or nil.
+func (m *Manifest) Section(name string) *SectionInfo {
+	for _, s := range m.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Plan describes what to back up, and where backups are written.
+type Plan struct {
+	Sources   []Source
+	Directory string
+	Logger    log.FieldLogger
+}
+
+// Run writes a new, timestamped backup into the plan's directory, returning
+// the backup file name.
+func (p *Plan) Run() (string, *Manifest, error) {
+	err := os.MkdirAll(p.Directory, os.FileMode(0755))
+	if err != nil {
+		return "", nil, err
+	}
+
+	file := path.Join(p.Directory, fmt.Sprintf("xyzzybot-%s.tar.gz", time.Now().Format("20060102-150405")))
+	manifest, err := CreateFile(file, p.Sources, p.Logger)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return file, manifest, nil
+}
+
+// CreateFile writes a backup of the sources to the given file.  The backup is
+// written to a temporary file first, so a failed backup never leaves a partial
+// archive behind.
+func CreateFile(file string, sources []Source, logger log.FieldLogger) (*Manifest, error) {
+	tmp := fmt.Sprintf("%s.tmp", file)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := Create(f, sources, logger)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Create writes a backup of the sources to w.
+func Create(w io.Writer, sources []Source, logger log.FieldLogger) (*Manifest, error) {
+	logger = logger.WithField("component", "backup")
+
+	host, _ := os.Hostname()
+	manifest := &Manifest{
+		Version: ManifestVersion,
+		Created: time.Now(),
+		Host:    host,
+	}
+
+	// We have to hash everything up front, since the manifest comes first.
+	for _, source := range sources {
+		section := &SectionInfo{
+			Name:      source.Section,
+			Directory: source.Directory,
+		}
+		manifest.Sections = append(manifest.Sections, section)
+
+		entries, err := scan(source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			section.Files++
+			section.Bytes += e.Size
+		}
+		manifest.Files = append(manifest.Files, entries...)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(b)),
+		ModTime: manifest.Created,
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = tw.Write(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range manifest.Files {
+		err = addEntry(tw, sourceDir(sources, e.Section), e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(log.Fields{
+		"files": len(manifest.Files),
+	}).Info("backup written")
+
+	return manifest, nil
+}
+
+func sourceDir(sources []Source, section string) string {
+	for _, s := range sources {
+		if s.Section == section {
+			return s.Directory
+		}
+	}
+	return ""
+}
+
+// scan finds (and hashes) all of the regular files in a source.
+func scan(source Source) ([]*FileEntry, error) {
+	entries := make([]*FileEntry, 0)
+
+	if _, err := os.Stat(source.Directory); os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	err := filepath.Walk(source.Directory, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source.Directory, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." && isExcluded(source, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		sum, err := hashFile(file)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, &FileEntry{
+			Section: source.Section,
+			Path:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode().Perm(),
+			ModTime: info.ModTime(),
+			SHA256:  sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+func isExcluded(source Source, rel string) bool {
+	top := strings.SplitN(rel, "/", 2)[0]
+	for _, x := range source.Exclude {
+		if x == top {
+			return true
+		}
+	}
+
+	// Skip our own temporary files.
+	return strings.HasSuffix(rel, ".tmp")
+}
+
+func hashFile(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func addEntry(tw *tar.Writer, dir string, e *FileEntry) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(e.Path)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    e.archiveName(),
+		Mode:    int64(e.Mode),
+		Size:    e.Size,
+		ModTime: e.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	// If the file changed size since we hashed it, the archive will fail to
+	// verify... better to fail now.
+	n, err := io.Copy(tw, f)
+	if err != nil {
+		return err
+	}
+	if n != e.Size {
+		return fmt.Errorf("%s changed while being backed up", e.archiveName())
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Verify reads an entire backup archive, checking that it has a readable
+// manifest, that every file it contains is listed in the manifest with the
+// right size and checksum, and that nothing listed is missing.
+func Verify(file string) (*Manifest, error) {
+	return walk(file, nil, nil)
+}
+
+// Restore verifies a backup archive and then extracts it.  Each section is
+// restored into the directory given for it in targets; sections without a
+// target are skipped.  Existing files are overwritten, but files that aren't
+// in the backup are left alone.
+func Restore(file string, targets map[string]string, logger log.FieldLogger) (*Manifest, error) {
+	logger = logger.WithFields(log.Fields{
+		"component": "backup",
+		"file":      file,
+	})
+
+	// Verify everything before touching anything, so that a damaged archive
+	// can't leave us half-restored.
+	manifest, err := Verify(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for section, dir := range targets {
+		if manifest.Section(section) == nil {
+			logger.WithField("section", section).Warn("backup does not contain section")
+			continue
+		}
+		err = os.MkdirAll(dir, os.FileMode(0755))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = walk(file, targets, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// walk reads the archive, validating it against its manifest.  If targets is
+// non-nil, files are also extracted.
+func walk(file string, targets map[string]string, logger log.FieldLogger) (*Manifest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %s", err.Error())
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %s", err.Error())
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("not a backup archive: the first entry is %q, not the manifest", hdr.Name)
+	}
+
+	manifest := &Manifest{}
+	err = json.NewDecoder(tr).Decode(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unreadable backup manifest: %s", err.Error())
+	}
+	if manifest.Version < 1 || manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("unsupported backup manifest version %d", manifest.Version)
+	}
+
+	expected := make(map[string]*FileEntry, len(manifest.Files))
+	for _, e := range manifest.Files {
+		if !isSafePath(e.Section) || !isSafePath(e.Path) {
+			return nil, fmt.Errorf("backup manifest contains an unsafe path: %q", e.archiveName())
+		}
+		expected[e.archiveName()] = e
+	}
+
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("damaged backup archive: %s", err.Error())
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("backup archive contains a non-file entry %q", hdr.Name)
+		}
+
+		e, ok := expected[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("backup archive contains %q, which isn’t in the manifest", hdr.Name)
+		}
+		delete(expected, hdr.Name)
+
+		dir, extract := targets[e.Section]
+		if extract {
+			err = extractEntry(tr, dir, e)
+		} else {
+			err = checkEntry(tr, e)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if extract {
+			logger.WithField("path", hdr.Name).Debug("restored file")
+		}
+	}
+
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		return nil, fmt.Errorf("backup archive is missing %d files listed in the manifest (including %q)", len(missing), missing[0])
+	}
+
+	return manifest, nil
+}
+
+func isSafePath(p string) bool {
+	if p == "" || path.IsAbs(p) || strings.Contains(p, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func checkEntry(r io.Reader, e *FileEntry) error {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	return checkSum(e, n, h.Sum(nil))
+}
+
+func checkSum(e *FileEntry, n int64, sum []byte) error {
+	if n != e.Size {
+		return fmt.Errorf("%s is %d bytes, but the manifest says %d", e.archiveName(), n, e.Size)
+	}
+	if hex.EncodeToString(sum) != e.SHA256 {
+		return fmt.Errorf("%s does not match its checksum in the manifest", e.archiveName())
+	}
+	return nil
+}
+
+// extractEntry writes the file (via a temporary file, renamed into place once
+// its checksum has been confirmed).
+func extractEntry(r io.Reader, dir string, e *FileEntry) error {
+	dest := filepath.Join(dir, filepath.FromSlash(e.Path))
+	err := os.MkdirAll(filepath.Dir(dest), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".restore-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = checkSum(e, n, h.Sum(nil))
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), e.Mode)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), dest)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dest, e.ModTime, e.ModTime)
+}
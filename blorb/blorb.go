@@ -0,0 +1,188 @@
+package blorb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/JaredReisinger/xyzzybot/iff"
+	"github.com/JaredReisinger/xyzzybot/ifiction"
+)
+
+// Blorb is an IFF FORM of type "IFRS" that bundles a story file with its
+// resources (pictures, sounds) and metadata.  The "RIdx" chunk indexes the
+// resources by usage and number, pointing at the chunks by file offset.  See
+// http://eblong.com/zarf/blorb/blorb.html
+
+// Resource usages...
+const (
+	PictureUsage    = "Pict"
+	SoundUsage      = "Snd "
+	ExecutableUsage = "Exec"
+	DataUsage       = "Data"
+)
+
+// Resource is a single indexed resource.
+type Resource struct {
+	Usage  string
+	Number int
+	Chunk  *iff.Chunk
+}
+
+// Type returns the resource's chunk type, such as "PNG " or "ZCOD".
+func (r *Resource) Type() string {
+	return r.Chunk.ID
+}
+
+// Data returns the resource's data.
+func (r *Resource) Data() []byte {
+	return r.Chunk.Data
+}
+
+// Extension returns a file extension (with the leading ".") suitable for the
+// resource type.
+func (r *Resource) Extension() string {
+	switch r.Chunk.ID {
+	case "PNG ":
+		return ".png"
+	case "JPEG":
+		return ".jpg"
+	case "OGGV":
+		return ".ogg"
+	case "AIFF":
+		return ".aiff"
+	case "MOD ":
+		return ".mod"
+	case "ZCOD":
+		return ".zcode"
+	case "GLUL":
+		return ".ulx"
+	case "TEXT":
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// File is a parsed Blorb file.
+type File struct {
+	Form      *iff.Form
+	Resources []*Resource
+
+	// Frontispiece is the picture number of the cover art, or -1.
+	Frontispiece int
+
+	// Metadata is the embedded iFiction record, if any.
+	Metadata *ifiction.Story
+}
+
+// IsBlorb reports whether the data looks like a Blorb file.
+func IsBlorb(b []byte) bool {
+	return iff.IsForm(b, "IFRS")
+}
+
+// ReadFile reads and parses a Blorb file.
+func ReadFile(file string) (*File, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse parses a Blorb file held in memory.
+func Parse(b []byte) (*File, error) {
+	if !IsBlorb(b) {
+		return nil, fmt.Errorf("not a Blorb file")
+	}
+
+	form, err := iff.ParseForm(b)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		Form:         form,
+		Frontispiece: -1,
+	}
+
+	ridx := form.Chunk("RIdx")
+	if ridx == nil {
+		return nil, fmt.Errorf("Blorb file is missing its resource index")
+	}
+
+	d := ridx.Data
+	if len(d) < 4 {
+		return nil, fmt.Errorf("Blorb resource index is too short")
+	}
+	count := int(binary.BigEndian.Uint32(d))
+	if len(d) < 4+count*12 {
+		return nil, fmt.Errorf("Blorb resource index claims %d entries but is only %d bytes", count, len(d))
+	}
+
+	for i := 0; i < count; i++ {
+		e := d[4+i*12:]
+		usage := string(e[0:4])
+		number := int(binary.BigEndian.Uint32(e[4:8]))
+		offset := int64(binary.BigEndian.Uint32(e[8:12]))
+
+		chunk := form.ChunkAt(offset)
+		if chunk == nil {
+			return nil, fmt.Errorf("Blorb resource %s %d points at a missing chunk (offset %d)", usage, number, offset)
+		}
+
+		f.Resources = append(f.Resources, &Resource{
+			Usage:  usage,
+			Number: number,
+			Chunk:  chunk,
+		})
+	}
+
+	if fspc := form.Chunk("Fspc"); fspc != nil && len(fspc.Data) >= 4 {
+		f.Frontispiece = int(binary.BigEndian.Uint32(fspc.Data))
+	}
+
+	if ifmd := form.Chunk("IFmd"); ifmd != nil {
+		// A broken metadata chunk shouldn't make the game unplayable, so
+		// we just ignore it.
+		index, err := ifiction.Parse(bytes.NewReader(ifmd.Data))
+		if err == nil && len(index.Stories) > 0 {
+			f.Metadata = index.Stories[0]
+		}
+	}
+
+	return f, nil
+}
+
+// Resource returns the resource with the given usage and number, or nil.
+func (f *File) Resource(usage string, number int) *Resource {
+	for _, r := range f.Resources {
+		if r.Usage == usage && r.Number == number {
+			return r
+		}
+	}
+	return nil
+}
+
+// Picture returns picture resource n, or nil.
+func (f *File) Picture(n int) *Resource {
+	return f.Resource(PictureUsage, n)
+}
+
+// Sound returns sound resource n, or nil.
+func (f *File) Sound(n int) *Resource {
+	return f.Resource(SoundUsage, n)
+}
+
+// Executable returns the story file resource, or nil.
+func (f *File) Executable() *Resource {
+	return f.Resource(ExecutableUsage, 0)
+}
+
+// Cover returns the frontispiece picture resource, or nil.
+func (f *File) Cover() *Resource {
+	if f.Frontispiece < 0 {
+		return nil
+	}
+	return f.Picture(f.Frontispiece)
+}
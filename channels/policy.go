@@ -0,0 +1,81 @@
+package channels
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// A channel's policy decides which games may be played there.  Allow and Deny
+// entries are either game file names or, with a leading "#", tags (which
+// include the categories admins give games).  Deny wins over Allow; if there
+// are any Allow entries, only those games may be played.  MaxRating, if set,
+// excludes games rated above it.
+
+// TagPrefix marks a policy entry as a tag rather than a game.
+const TagPrefix = "#"
+
+// HasPolicy reports whether the channel restricts its games at all.
+func (s *Settings) HasPolicy() bool {
+	return len(s.Allow) > 0 || len(s.Deny) > 0 || s.MaxRating != ""
+}
+
+// Permits reports whether the game may be played in the channel, and if not,
+// why not.
+func (s *Settings) Permits(game *games.GameInfo) (bool, string) {
+	for _, entry := range s.Deny {
+		if matchesEntry(game, entry) {
+			if strings.HasPrefix(entry, TagPrefix) {
+				return false, fmt.Sprintf("games tagged _%s_ aren’t allowed here", strings.TrimPrefix(entry, TagPrefix))
+			}
+			return false, "it’s on this channel’s denylist"
+		}
+	}
+
+	if len(s.Allow) > 0 {
+		allowed := false
+		for _, entry := range s.Allow {
+			if matchesEntry(game, entry) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false, "only the games on this channel’s allowlist can be played here"
+		}
+	}
+
+	if s.MaxRating != "" && games.RatingLevel(game.Rating) > games.RatingLevel(s.MaxRating) {
+		return false, fmt.Sprintf("it’s rated _%s_, and this channel only allows games rated up to _%s_", game.Rating, s.MaxRating)
+	}
+
+	return true, ""
+}
+
+func matchesEntry(game *games.GameInfo, entry string) bool {
+	if strings.HasPrefix(entry, TagPrefix) {
+		return game.HasTag(strings.TrimPrefix(entry, TagPrefix))
+	}
+	return game.FileName == entry
+}
+
+// AddEntry adds an entry to a list, if it isn't already there.
+func AddEntry(list []string, entry string) []string {
+	for _, e := range list {
+		if e == entry {
+			return list
+		}
+	}
+	return append(list, entry)
+}
+
+// RemoveEntry removes an entry from a list, reporting whether it was there.
+func RemoveEntry(list []string, entry string) ([]string, bool) {
+	for i, e := range list {
+		if e == entry {
+			return append(list[:i:i], list[i+1:]...), true
+		}
+	}
+	return list, false
+}
@@ -0,0 +1,142 @@
+package channels
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Settings are the per-channel choices that outlive any one game.
+type Settings struct {
+	Room string
+
+	// AnnounceGames posts a note to the channel whenever a new game arrives.
+	AnnounceGames bool `json:",omitempty"`
+
+	// NoHints stops the suggestions that follow a game's "I don't know that
+	// word" responses.
+	NoHints bool `json:",omitempty"`
+
+	// AuthorMode restarts the game being played whenever a new build of it
+	// arrives, and replays the commands sent so far.
+	AuthorMode bool `json:",omitempty"`
+
+	// The channel's game policy (see Permits).
+	Allow     []string `json:",omitempty"`
+	Deny      []string `json:",omitempty"`
+	MaxRating string   `json:",omitempty"`
+}
+
+// Store is an embedded, file-based store of channel settings.  Each channel's
+// settings are kept in their own JSON file in the store's directory.
+type Store struct {
+	Directory string
+	Logger    log.FieldLogger
+
+	mutex sync.Mutex
+}
+
+// Get returns the room's settings.  A room that has never changed anything
+// gets the defaults.
+func (s *Store) Get(room string) (*Settings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(room)
+}
+
+// Update applies fn to the room's settings and persists the result.
+func (s *Store) Update(room string, fn func(settings *Settings)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings, err := s.load(room)
+	if err != nil {
+		return err
+	}
+
+	fn(settings)
+	return s.save(settings)
+}
+
+// All returns the settings for every room that has changed any.
+func (s *Store) All() ([]*Settings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	infos, err := ioutil.ReadDir(s.Directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]*Settings, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || path.Ext(name) != ".json" {
+			continue
+		}
+
+		settings, err := s.load(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			s.Logger.WithField("file", name).WithError(err).Warn("skipping unreadable channel settings")
+			continue
+		}
+		all = append(all, settings)
+	}
+
+	return all, nil
+}
+
+func (s *Store) roomFile(room string) string {
+	return path.Join(s.Directory, fmt.Sprintf("%s.json", room))
+}
+
+func (s *Store) load(room string) (*Settings, error) {
+	settings := &Settings{Room: room}
+
+	b, err := ioutil.ReadFile(s.roomFile(room))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	settings.Room = room
+	return settings, nil
+}
+
+func (s *Store) save(settings *Settings) error {
+	err := os.MkdirAll(s.Directory, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := s.roomFile(settings.Room)
+	tmp := fmt.Sprintf("%s.tmp", file)
+	err = ioutil.WriteFile(tmp, b, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
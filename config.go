@@ -18,6 +18,56 @@ type Config struct {
 	BotToken      string
 	BotTokenFile  string
 	Admins        []string
+
+	// AnnounceShutdown posts a message to every room with a game in progress
+	// when the bot shuts down.
+	AnnounceShutdown bool
+
+	// ShutdownTimeout is how long (in seconds) to wait for games to save
+	// when shutting down.
+	ShutdownTimeout int
+
+	// WatchSeconds is how often (in seconds) to look for games that have
+	// been added to, changed in, or removed from the game directory.  A
+	// negative value turns watching off.
+	WatchSeconds int
+
+	Retention RetentionConfig
+
+	Storage StorageConfig
+}
+
+// StorageConfig chooses where games are kept: "filesystem" (the default)
+// keeps them in GameDirectory, and "s3" keeps them in an S3-compatible
+// bucket, with GameDirectory (or S3.CacheDirectory) as a local cache.
+type StorageConfig struct {
+	Type string
+	S3   S3Config
+}
+
+// S3Config describes an S3-compatible bucket (AWS S3, MinIO, and so on).
+type S3Config struct {
+	Endpoint       string // like "https://s3.amazonaws.com" or "http://localhost:9000"
+	Region         string
+	Bucket         string
+	Prefix         string
+	AccessKey      string
+	SecretKey      string
+	SecretKeyFile  string
+	VirtualHost    bool // bucket in the host name, rather than the path
+	CacheDirectory string
+	RefreshSeconds int
+}
+
+// RetentionConfig defines how long per-room files (saves, transcripts,
+// snapshots) are kept, and how much space they may use.  Zero values mean "no
+// limit".
+type RetentionConfig struct {
+	MaxAgeDays         int
+	RoomQuotaMB        int
+	TotalQuotaMB       int
+	ArchiveDirectory   string
+	SweepIntervalHours int
 }
 
 // ParseConfigFile attempts to load a Config struct, using the data in a JSON
@@ -57,6 +107,9 @@ func ParseConfigFile(configFile string, logger log.FieldLogger) (config *Config,
 	absolutize(configDir, &config.GameDirectory)
 	absolutize(configDir, &config.WorkingRoot)
 	absolutize(configDir, &config.BotTokenFile)
+	absolutize(configDir, &config.Retention.ArchiveDirectory)
+	absolutize(configDir, &config.Storage.S3.SecretKeyFile)
+	absolutize(configDir, &config.Storage.S3.CacheDirectory)
 
 	return
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,6 +14,8 @@ import (
 	"github.com/JaredReisinger/xyzzybot/games"
 )
 
+const autosaveSlot = "autosave"
+
 // Config ...
 type Config struct {
 	Logger             log.FieldLogger
@@ -47,6 +50,35 @@ func (c *Console) Disconnect() {
 	close(c.quit)
 }
 
+// Shutdown saves any game in progress (waiting up to timeout for the
+// interpreter to exit), and then disconnects.
+func (c *Console) Shutdown(timeout time.Duration) {
+	if c.inGame() {
+		c.logger.Info("saving game for shutdown")
+		before := fizmo.StampSave(c.workingDir(), autosaveSlot)
+		finished := make(chan bool)
+		go func() {
+			c.interp.Send("save")
+			c.interp.Send(autosaveSlot)
+			c.killGame()
+			close(finished)
+		}()
+
+		select {
+		case <-finished:
+			if fizmo.StampSave(c.workingDir(), autosaveSlot).WrittenSince(before) {
+				c.logger.WithField("save", autosaveSlot).Info("game saved")
+			} else {
+				c.logger.WithField("save", autosaveSlot).Error("game wasn’t saved (was it waiting for an answer?)")
+			}
+		case <-time.After(timeout):
+			c.logger.Warn("timed out waiting for game to save")
+		}
+	}
+
+	c.Disconnect()
+}
+
 func (c *Console) processInput(r io.Reader) {
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
@@ -81,12 +113,16 @@ func (c *Console) handleInput(input string) {
 	case "list":
 		c.commandList()
 	case "play":
-		c.commandPlay(words[1])
+		c.commandPlay(strings.Join(words[1:], " "))
 	default:
 		c.logger.WithField("command", command).Error("unknown command")
 	}
 }
 
+func (c *Console) workingDir() string {
+	return path.Join(c.config.WorkingRoot, "console")
+}
+
 func (c *Console) inGame() bool {
 	return c.interp != nil
 }
@@ -98,7 +134,7 @@ func (c *Console) commandPlay(game string) {
 	}
 
 	// Create a working directory for the interpreter...
-	workingDir := path.Join(c.config.WorkingRoot, "console")
+	workingDir := c.workingDir()
 	err := os.MkdirAll(workingDir, os.FileMode(0755))
 	if err != nil {
 		c.logger.WithError(err).Error("creating working directory")
@@ -106,7 +142,11 @@ func (c *Console) commandPlay(game string) {
 	}
 
 	c.logger.WithField("game", game).Info("starting game")
-	gameFile, err := c.config.Games.GetGameFile(game)
+	gameFile, _, err := c.config.Games.GetGameVersionFile(game, "")
+	if nf, ok := err.(*games.NotFoundError); ok && len(nf.Suggestions) > 0 {
+		c.logger.WithField("suggestions", nf.Suggestions).WithError(err).Error("getting game file (did you mean one of these?)")
+		return
+	}
 	if err != nil {
 		c.logger.WithError(err).Error("getting game file")
 		return
@@ -136,7 +176,25 @@ func (c *Console) commandList() {
 		return
 	}
 
-	c.logger.WithField("games", games).Info("games")
+	for _, game := range games {
+		if !game.Playable() {
+			c.logger.WithFields(log.Fields{
+				"game":    game.Name,
+				"problem": game.Problem,
+			}).Warn("not a playable game")
+			continue
+		}
+		c.logger.WithFields(log.Fields{
+			"game":    game.Name,
+			"title":   game.Title(),
+			"author":  game.Author(),
+			"story":   game.Story.String(),
+			"ifid":    game.ID,
+			"addedBy": game.AddedBy,
+			"added":   game.AddedAt.Format("2006-01-02"),
+			"extras":  game.Companions,
+		}).Info("game")
+	}
 }
 
 func (c *Console) processOutput(outchan chan *fizmo.Output) {
@@ -156,9 +214,12 @@ func (c *Console) processOutput(outchan chan *fizmo.Output) {
 func (c *Console) killGame() {
 	c.logger.Info("recieved killGame request")
 
-	if c.interp != nil {
-		// TODO: stop listening to random stuff?
-		c.interp.Kill()
+	// As with Slack rooms, forget the interpreter before killing it, since
+	// the output handler will call back in here.
+	i := c.interp
+	if i != nil {
 		c.interp = nil
+		// TODO: stop listening to random stuff?
+		i.Kill()
 	}
 }
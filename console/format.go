@@ -96,6 +96,13 @@ func formatDebugOutput(output *fizmo.Output) string {
 		lines = append(lines, formatSpans(spans))
 	}
 
+	if len(output.Choices) > 0 {
+		lines = append(lines, "")
+		for _, choice := range output.Choices {
+			lines = append(lines, fmt.Sprintf("  [%d] %s", choice.Number, choice.Text))
+		}
+	}
+
 	lines = append(lines, sep2)
 	lines = append(lines, formatStatus(output.Status))
 
@@ -106,5 +113,5 @@ func formatDebugOutput(output *fizmo.Output) string {
 
 	lines = append(lines, sep1)
 
-	return fmt.Sprintf(strings.Join(lines, "\n"))
+	return strings.Join(lines, "\n")
 }
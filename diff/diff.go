@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"strings"
+)
+
+// Line is one line of a diff: a line that's only in the old text ('-'), only
+// in the new text ('+'), or in both (' ').
+type Line struct {
+	Kind byte
+	Text string
+}
+
+func (l *Line) String() string {
+	return string(l.Kind) + " " + l.Text
+}
+
+// Lines compares two texts line by line, returning the lines of both in
+// order, each marked as removed, added, or unchanged.  (It finds the longest
+// common subsequence, which is fine for the short texts we compare.)
+func Lines(old string, new string) []*Line {
+	a := splitLines(old)
+	b := splitLines(new)
+
+	// common[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]*Line, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, &Line{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, &Line{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, &Line{'+', b[j]})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// Changes formats the changed lines of a diff, with up to context unchanged
+// lines around each change.  Unchanged lines that are left out are marked with
+// "...".
+func Changes(lines []*Line, context int) string {
+	show := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Kind == ' ' {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(lines) {
+				show[j] = true
+			}
+		}
+	}
+
+	out := make([]string, 0, len(lines))
+	skipped := false
+	for i, l := range lines {
+		if !show[i] {
+			skipped = true
+			continue
+		}
+		if skipped && len(out) > 0 {
+			out = append(out, "...")
+		}
+		skipped = false
+		out = append(out, l.String())
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// Changed reports whether a diff has any changes.
+func Changed(lines []*Line) bool {
+	for _, l := range lines {
+		if l.Kind != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLines splits text into lines, ignoring trailing spaces and blank lines
+// at the start and end.
+func splitLines(text string) []string {
+	text = strings.Trim(text, "\n")
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return lines
+}
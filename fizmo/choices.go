@@ -0,0 +1,299 @@
+package fizmo // import "github.com/JaredReisinger/xyzzybot/fizmo"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/babel"
+)
+
+// choiceStory is a choice-based story that runs in-process (an Ink or Twine
+// story), as seen by choiceInterpreter.
+type choiceStory interface {
+	Reset() error
+
+	// Continue runs the story until it needs a choice, returning what it
+	// said.
+	Continue() ([]*Spans, error)
+	Choices() []string
+	Choose(index int) error
+	Ended() bool
+	Turn() int
+
+	SaveState() ([]byte, error)
+	LoadState(state []byte) error
+}
+
+// What the next input is for, when it isn't a choice...
+const (
+	noPrompt = iota
+	savePrompt
+	restorePrompt
+)
+
+// choiceInterpreter plays a choice-based story: the players make choices by
+// number or text, and there are a few commands that every story understands:
+// save, restore, restart, and look.
+type choiceInterpreter struct {
+	logger     log.FieldLogger
+	format     string
+	ifid       string
+	checksum   string
+	workingDir string
+
+	mutex  sync.Mutex
+	story  choiceStory
+	prompt int
+	recap  []*Spans // the most recent passage
+	closed bool
+
+	Output chan *Output
+}
+
+func newChoiceInterpreter(logger log.FieldLogger, format string, ifid string, checksum string, workingDir string, story choiceStory) *choiceInterpreter {
+	return &choiceInterpreter{
+		logger:     logger,
+		format:     format,
+		ifid:       ifid,
+		checksum:   checksum,
+		workingDir: workingDir,
+		story:      story,
+		Output:     make(chan *Output, 5),
+	}
+}
+
+// GetOutputChannel ...
+func (i *choiceInterpreter) GetOutputChannel() chan *Output {
+	return i.Output
+}
+
+// Start begins the story.
+func (i *choiceInterpreter) Start() error {
+	i.logger.WithField("format", i.format).Info("running story")
+	go func() {
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		i.continueStory()
+	}()
+	return nil
+}
+
+// Send makes a choice (by number or text), or handles one of the commands.
+func (i *choiceInterpreter) Send(input string) error {
+	i.logger.WithField("input", input).Info("sending")
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.closed {
+		return errors.New("the story isn’t running")
+	}
+
+	input = strings.TrimSpace(input)
+
+	prompt := i.prompt
+	i.prompt = noPrompt
+	switch prompt {
+	case savePrompt:
+		i.save(input)
+		return nil
+	case restorePrompt:
+		i.restore(input)
+		return nil
+	}
+
+	switch strings.ToLower(input) {
+	case "save":
+		i.prompt = savePrompt
+		i.sayText("Please enter a name for the saved game:")
+		return nil
+	case "restore":
+		i.prompt = restorePrompt
+		i.sayText("Please enter the name of the saved game to restore:")
+		return nil
+	case "restart":
+		err := i.story.Reset()
+		if err != nil {
+			i.fail(err)
+			return nil
+		}
+		i.continueStory()
+		return nil
+	case "", "look", "l", "choices":
+		i.say(i.recap, i.story.Choices())
+		return nil
+	}
+
+	choice := i.findChoice(input)
+	if choice < 0 {
+		i.say(textSpans("Please choose one of these (by number):"), i.story.Choices())
+		return nil
+	}
+
+	err := i.story.Choose(choice)
+	if err != nil {
+		i.fail(err)
+		return nil
+	}
+	i.continueStory()
+	return nil
+}
+
+// findChoice works out which choice the input means: its number, its text,
+// or a piece of text that only one of them has.
+func (i *choiceInterpreter) findChoice(input string) int {
+	choices := i.story.Choices()
+
+	input = strings.TrimSuffix(input, ".")
+	if n, err := strconv.Atoi(input); err == nil {
+		if n >= 1 && n <= len(choices) {
+			return n - 1
+		}
+		return -1
+	}
+
+	lower := strings.ToLower(input)
+	match := -1
+	for n, c := range choices {
+		text := strings.ToLower(c)
+		if text == lower {
+			return n
+		}
+		if strings.Contains(text, lower) {
+			if match >= 0 {
+				return -1
+			}
+			match = n
+		}
+	}
+	return match
+}
+
+// continueStory runs the story until it needs a choice, and sends what it
+// said.  The caller must hold the mutex.
+func (i *choiceInterpreter) continueStory() {
+	story, err := i.story.Continue()
+	if err != nil {
+		i.fail(err)
+		return
+	}
+
+	i.recap = story
+	i.say(story, i.story.Choices())
+
+	if i.story.Ended() {
+		i.logger.Info("story ended")
+		i.close()
+	}
+}
+
+// fail reports an error in the story, which can't go any further.  The caller
+// must hold the mutex.
+func (i *choiceInterpreter) fail(err error) {
+	i.logger.WithError(err).Error("running story")
+	i.sayText(fmt.Sprintf("[The story has stopped: %s.]", err.Error()))
+	i.close()
+}
+
+// say sends output.  The caller must hold the mutex.
+func (i *choiceInterpreter) say(story []*Spans, choices []string) {
+	if i.closed {
+		return
+	}
+
+	output := &Output{Status: &Status{}, Story: story}
+	for n, c := range choices {
+		output.Choices = append(output.Choices, &Choice{Number: n + 1, Text: c})
+	}
+
+	i.Output <- output
+}
+
+func (i *choiceInterpreter) sayText(lines ...string) {
+	i.say(textSpans(lines...), nil)
+}
+
+func textSpans(lines ...string) []*Spans {
+	story := make([]*Spans, len(lines))
+	for n, line := range lines {
+		story[n] = &Spans{&Span{Text: line}}
+	}
+	return story
+}
+
+func (i *choiceInterpreter) close() {
+	if !i.closed {
+		i.closed = true
+		close(i.Output)
+	}
+}
+
+func (i *choiceInterpreter) save(slot string) {
+	file, err := saveFile(i.workingDir, slot)
+	if err != nil {
+		i.sayText(fmt.Sprintf("Failed: %s.", err.Error()))
+		return
+	}
+
+	state, err := i.story.SaveState()
+	if err == nil {
+		save := &StateSave{
+			Format:   i.format,
+			IFID:     i.ifid,
+			Checksum: i.checksum,
+			Turn:     i.story.Turn(),
+			SavedAt:  time.Now(),
+			Recap:    StoryLines(i.recap),
+			State:    state,
+		}
+		err = save.WriteFile(file)
+	}
+	if err != nil {
+		i.logger.WithError(err).Error("saving")
+		i.sayText(fmt.Sprintf("Failed: %s.", err.Error()))
+		return
+	}
+
+	i.logger.WithField("file", file).Info("saved")
+	i.sayText("Ok.")
+}
+
+func (i *choiceInterpreter) restore(slot string) {
+	file, err := saveFile(i.workingDir, slot)
+	if err != nil {
+		i.sayText(fmt.Sprintf("Failed: %s.", err.Error()))
+		return
+	}
+
+	save, err := ReadStateSave(file)
+	if err == nil && (save.Format != i.format || !babel.Equal(save.IFID, i.ifid)) {
+		err = fmt.Errorf("“%s” was saved from a different story", slot)
+	}
+	if err == nil {
+		err = i.story.LoadState(save.State)
+	}
+	if err != nil {
+		i.logger.WithError(err).Warn("restoring")
+		i.say(textSpans(fmt.Sprintf("Failed: %s.", err.Error())), i.story.Choices())
+		return
+	}
+
+	i.logger.WithField("file", file).Info("restored")
+	i.recap = textSpans(save.Recap...)
+	i.say(textSpans(append([]string{"Ok."}, save.Recap...)...), i.story.Choices())
+}
+
+// Kill stops the story.
+func (i *choiceInterpreter) Kill() {
+	i.logger.Info("received kill request")
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.close()
+}
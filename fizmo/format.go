@@ -0,0 +1,39 @@
+package fizmo // import "github.com/JaredReisinger/xyzzybot/fizmo"
+
+import (
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Format is a story format with its own interpreter.
+type Format struct {
+	Name    string
+	Detect  func(b []byte) bool // given the game file
+	Factory InterpreterFactory
+}
+
+// FormatFactory creates interpreters for more than one story format, choosing
+// by looking at the game file.  (Not just the start of it: published Twine
+// stories are web pages, and only their contents say they're stories.)
+// Games in none of the formats get the default interpreter.
+type FormatFactory struct {
+	Formats []*Format
+	Default InterpreterFactory
+}
+
+// NewInterpreter ...
+func (f *FormatFactory) NewInterpreter(gameFile string, workingDir string, fields log.Fields) (Interpreter, error) {
+	b, err := ioutil.ReadFile(gameFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, format := range f.Formats {
+		if format.Detect(b) {
+			return format.Factory.NewInterpreter(gameFile, workingDir, fields)
+		}
+	}
+
+	return f.Default.NewInterpreter(gameFile, workingDir, fields)
+}
@@ -0,0 +1,72 @@
+package fizmo // import "github.com/JaredReisinger/xyzzybot/fizmo"
+
+import (
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/babel"
+	"github.com/JaredReisinger/xyzzybot/ink"
+)
+
+// InkFormat is the story format name for compiled Ink stories.
+const InkFormat = "ink"
+
+// InkFactory creates interpreters for compiled Ink stories (the JSON that
+// inklecate and Inky produce), which run in-process.
+type InkFactory struct {
+	Logger log.FieldLogger
+}
+
+// NewInterpreter ...
+func (f *InkFactory) NewInterpreter(gameFile string, workingDir string, fields log.Fields) (Interpreter, error) {
+	logger := f.Logger.WithField("component", "ink").WithFields(fields)
+
+	b, err := ioutil.ReadFile(gameFile)
+	if err != nil {
+		logger.WithError(err).Error("reading story")
+		return nil, err
+	}
+
+	story, err := ink.Parse(b)
+	if err != nil {
+		logger.WithError(err).Error("parsing story")
+		return nil, err
+	}
+
+	ifid, err := babel.InkIFID(b)
+	if err != nil {
+		logger.WithError(err).Error("identifying story")
+		return nil, err
+	}
+
+	runner, err := story.NewRunner()
+	if err != nil {
+		logger.WithError(err).Error("starting story")
+		return nil, err
+	}
+
+	return newChoiceInterpreter(logger, InkFormat, ifid, babel.HashIFID(b), workingDir, &inkStory{runner}), nil
+}
+
+// inkStory adapts an Ink runner for choiceInterpreter.
+type inkStory struct {
+	*ink.Runner
+}
+
+func (s *inkStory) Continue() ([]*Spans, error) {
+	passage, err := s.Runner.Continue()
+	if err != nil {
+		return nil, err
+	}
+	return textSpans(passage.Lines...), nil
+}
+
+func (s *inkStory) Choices() []string {
+	choices := s.Runner.Choices()
+	texts := make([]string, len(choices))
+	for i, c := range choices {
+		texts[i] = c.Text
+	}
+	return texts
+}
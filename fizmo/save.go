@@ -0,0 +1,113 @@
+package fizmo // import "github.com/JaredReisinger/xyzzybot/fizmo"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// StateSaveExtension is the file extension of state saves.
+const StateSaveExtension = ".save"
+
+// StateSave is a saved game from one of the interpreters that run in-process
+// (Z-code saves are Quetzal files, written by the interpreter itself).  It
+// holds the story's state, and enough to tell which story it belongs to.
+type StateSave struct {
+	Format   string // the story format, like "ink"
+	IFID     string
+	Checksum string // MD5 of the story file (as in babel.HashIFID)
+	Turn     int
+	SavedAt  time.Time
+
+	// Recap is the last thing the story said, to remind the players where
+	// they were when the game is restored.
+	Recap []string `json:",omitempty"`
+
+	State json.RawMessage
+}
+
+// ReadStateSave reads a state save from disk.
+func ReadStateSave(file string) (*StateSave, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StateSave{}
+	err = json.Unmarshal(b, s)
+	if err != nil || s.Format == "" || s.State == nil {
+		return nil, fmt.Errorf("%s isn’t a saved game", path.Base(file))
+	}
+
+	return s, nil
+}
+
+// WriteFile writes the save to disk.
+func (s *StateSave) WriteFile(file string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, b, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func (s *StateSave) String() string {
+	return fmt.Sprintf("%s, turn %d", strings.Title(s.Format), s.Turn)
+}
+
+// saveFile returns the file for a save slot in the working directory.  Slot
+// names are what the players type, so they can't be paths.
+func saveFile(workingDir string, slot string) (string, error) {
+	slot = strings.TrimSpace(slot)
+	if slot == "" || strings.ContainsAny(slot, `/\`) || strings.HasPrefix(slot, ".") {
+		return "", fmt.Errorf("“%s” can’t be the name of a saved game", slot)
+	}
+	return path.Join(workingDir, slot+StateSaveExtension), nil
+}
+
+// SaveStamp describes the files in a save slot (their sizes and modification
+// times), so that comparing the stamps from before and after a save tells
+// whether it was actually written.  The Z-code interpreter picks its own file
+// extension, so any file named for the slot counts.
+type SaveStamp map[string]string
+
+// StampSave returns the stamp of a save slot in the working directory.
+func StampSave(workingDir string, slot string) SaveStamp {
+	stamp := make(SaveStamp)
+
+	infos, err := ioutil.ReadDir(workingDir)
+	if err != nil {
+		return stamp
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || !strings.EqualFold(strings.TrimSuffix(name, path.Ext(name)), slot) {
+			continue
+		}
+		stamp[name] = fmt.Sprintf("%d bytes at %s", info.Size(), info.ModTime().Format(time.RFC3339Nano))
+	}
+
+	return stamp
+}
+
+// WrittenSince reports whether anything in the slot was written after the
+// earlier stamp was taken.
+func (s SaveStamp) WrittenSince(earlier SaveStamp) bool {
+	for name, stamp := range s {
+		if earlier[name] != stamp {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package fizmo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSaveStamp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xyzzybot-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data string, modTime time.Time) {
+		file := path.Join(dir, name)
+		err := ioutil.WriteFile(file, []byte(data), os.FileMode(0644))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(file, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	then := time.Now().Add(-time.Hour)
+	write("other.sav", "other", then)
+
+	empty := StampSave(dir, "autosave")
+	if len(empty) != 0 {
+		t.Errorf("expected no files in the slot, got %v", empty)
+	}
+
+	write("other.sav", "changed", time.Now())
+	if StampSave(dir, "autosave").WrittenSince(empty) {
+		t.Error("a different slot’s save counted as written")
+	}
+
+	write("autosave.sav", "first", then)
+	first := StampSave(dir, "autosave")
+	if !first.WrittenSince(empty) {
+		t.Error("a new save didn’t count as written")
+	}
+	if StampSave(dir, "autosave").WrittenSince(first) {
+		t.Error("an unchanged save counted as written")
+	}
+
+	write("AUTOSAVE"+StateSaveExtension, "second", time.Now())
+	if !StampSave(dir, "autosave").WrittenSince(first) {
+		t.Error("a save with another extension didn’t count as written")
+	}
+}
@@ -0,0 +1,82 @@
+package fizmo // import "github.com/JaredReisinger/xyzzybot/fizmo"
+
+import (
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/babel"
+	"github.com/JaredReisinger/xyzzybot/twine"
+)
+
+// TwineFormat is the story format name for Twine stories.
+const TwineFormat = "twine"
+
+// TwineFactory creates interpreters for Twine stories (Twee source or
+// published HTML), which run in-process.
+type TwineFactory struct {
+	Logger log.FieldLogger
+}
+
+// NewInterpreter ...
+func (f *TwineFactory) NewInterpreter(gameFile string, workingDir string, fields log.Fields) (Interpreter, error) {
+	logger := f.Logger.WithField("component", "twine").WithFields(fields)
+
+	b, err := ioutil.ReadFile(gameFile)
+	if err != nil {
+		logger.WithError(err).Error("reading story")
+		return nil, err
+	}
+
+	story, err := twine.Parse(b)
+	if err != nil {
+		logger.WithError(err).Error("parsing story")
+		return nil, err
+	}
+
+	ifid, err := babel.TwineIFID(b)
+	if err != nil {
+		logger.WithError(err).Error("identifying story")
+		return nil, err
+	}
+
+	runner, err := story.NewRunner()
+	if err != nil {
+		logger.WithError(err).Error("starting story")
+		return nil, err
+	}
+
+	logger.WithField("storyFormat", story.Format).WithField("dialect", story.Dialect).Debug("parsed story")
+	return newChoiceInterpreter(logger, TwineFormat, ifid, babel.HashIFID(b), workingDir, &twineStory{runner}), nil
+}
+
+// twineStory adapts a Twine runner for choiceInterpreter.
+type twineStory struct {
+	*twine.Runner
+}
+
+func (s *twineStory) Continue() ([]*Spans, error) {
+	page, err := s.Runner.Continue()
+	if err != nil {
+		return nil, err
+	}
+
+	story := make([]*Spans, len(page.Lines))
+	for i, line := range page.Lines {
+		spans := make(Spans, len(line))
+		for j, span := range line {
+			spans[j] = &Span{Text: span.Text, Bold: span.Bold, Italic: span.Italic}
+		}
+		story[i] = &spans
+	}
+	return story, nil
+}
+
+func (s *twineStory) Choices() []string {
+	choices := s.Runner.Choices()
+	texts := make([]string, len(choices))
+	for i, c := range choices {
+		texts[i] = c.Text
+	}
+	return texts
+}
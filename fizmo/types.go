@@ -76,9 +76,20 @@ type Output struct {
 	// Type    OutputType
 	Status *Status
 	Story  []*Spans
+
+	// Choices are offered by choice-based stories, for the frontend to
+	// present however suits it (as numbered options, or buttons).  Any
+	// choice can be made by sending its number.
+	Choices []*Choice `json:",omitempty"`
 	// Message *string // used for error only
 }
 
+// Choice is one of the choices a choice-based story offers.
+type Choice struct {
+	Number int
+	Text   string
+}
+
 // Status ...
 type Status struct {
 	Columns []*Column
@@ -166,3 +177,25 @@ func (s *Spans) String() string {
 	}
 	return fmt.Sprintf("[%s]", strings.Join(l, ", "))
 }
+
+// StoryLines returns the plain text of each line of the story, without any
+// styling.
+func StoryLines(story []*Spans) []string {
+	lines := make([]string, len(story))
+	for n, spans := range story {
+		if spans == nil {
+			continue
+		}
+		var b strings.Builder
+		for _, span := range *spans {
+			b.WriteString(span.Text)
+		}
+		lines[n] = b.String()
+	}
+	return lines
+}
+
+// StoryText returns the plain text of the story, without any styling.
+func StoryText(story []*Spans) string {
+	return strings.Join(StoryLines(story), "\n")
+}
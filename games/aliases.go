@@ -0,0 +1,193 @@
+package games
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Aliases are extra names for games ("pig" for LostPig, say), set by admins.
+// They're kept in the library directory, mapping each (lower-case) alias to the
+// game's file name, which is what stays the same when the index is rebuilt.
+const aliasesFile = "aliases.json"
+
+func (fs *FileSys) aliasesFile() string {
+	return path.Join(fs.Directory, libraryDir, aliasesFile)
+}
+
+// readAliases returns the aliases, by lower-case alias.  It's read each time
+// it's needed (it's small), so that changes made by another instance (through
+// an object store) are seen right away.
+func (fs *FileSys) readAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	b, err := ioutil.ReadFile(fs.aliasesFile())
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+func (fs *FileSys) writeAliases(aliases map[string]string) error {
+	err := os.MkdirAll(path.Dir(fs.aliasesFile()), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(fs.aliasesFile(), b)
+	return err
+}
+
+// findAlias returns the game an alias refers to, or nil.  The caller must hold
+// the mutex.
+func (fs *FileSys) findAlias(games []*GameInfo, alias string) *GameInfo {
+	aliases, err := fs.readAliases()
+	if err != nil {
+		fs.Logger.WithError(err).Warn("ignoring unreadable game aliases")
+		return nil
+	}
+
+	fileName, ok := aliases[strings.ToLower(alias)]
+	if !ok {
+		return nil
+	}
+
+	for _, g := range games {
+		if g.FileName == fileName {
+			return g
+		}
+	}
+	return nil
+}
+
+// GetAliases returns the aliases for games that are still in the repository,
+// mapped to the games' names.
+func (fs *FileSys) GetAliases() (map[string]string, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	idx, err := fs.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases, err := fs.readAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string, len(aliases))
+	for alias, fileName := range aliases {
+		for _, g := range idx.Games {
+			if g.FileName == fileName {
+				names[alias] = g.Name
+				break
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// SetAlias makes alias another name for a game (given by its exact name or
+// file name).  An alias can't be the name of a game itself, or contain spaces.
+func (fs *FileSys) SetAlias(alias string, name string) (*GameInfo, error) {
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if alias == "" {
+		return nil, &InvalidNameError{alias, "it’s empty"}
+	}
+	if strings.IndexFunc(alias, unicode.IsSpace) >= 0 {
+		return nil, &InvalidNameError{alias, "it contains a space"}
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGameExactly(name)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := fs.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range idx.Games {
+		if strings.EqualFold(g.Name, alias) && g != game {
+			return nil, &ConflictError{fmt.Sprintf("there’s already a game called “%s”", g.Name)}
+		}
+	}
+
+	aliases, err := fs.readAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	aliases[alias] = game.FileName
+	err = fs.writeAliases(aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Logger.WithField("alias", alias).WithField("game", game.FileName).Info("set game alias")
+	return game, nil
+}
+
+// RemoveAlias removes an alias.
+func (fs *FileSys) RemoveAlias(alias string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	aliases, err := fs.readAliases()
+	if err != nil {
+		return err
+	}
+
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	if _, ok := aliases[alias]; !ok {
+		return fmt.Errorf("there’s no alias “%s”", alias)
+	}
+
+	delete(aliases, alias)
+	return fs.writeAliases(aliases)
+}
+
+// removeAliasesFor removes all the aliases for a game file.  The caller must
+// hold the mutex.
+func (fs *FileSys) removeAliasesFor(fileName string) error {
+	aliases, err := fs.readAliases()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for alias, f := range aliases {
+		if f == fileName {
+			delete(aliases, alias)
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return fs.writeAliases(aliases)
+}
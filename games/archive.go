@@ -0,0 +1,216 @@
+package games
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Archives (like most IF Archive downloads) bundle a story file with its
+// "feelies": manuals, maps, readmes, and so on.  When one is uploaded, each
+// story file inside is added as a game, and the rest of the files are kept
+// with the game as companions.
+
+// Limits on what we're willing to unpack, so that a small upload can't turn
+// into an enormous one.
+const (
+	maxArchiveFiles = 500
+	maxArchiveSize  = 64 * 1024 * 1024 // total uncompressed bytes
+)
+
+// ArchiveFile is a single file unpacked from an archive.
+type ArchiveFile struct {
+	Name string // slash-separated path within the archive
+	Data []byte
+}
+
+// UploadResult describes what happened when a file was uploaded.
+type UploadResult struct {
+	Games      []*GameInfo
+	Companions []string // companion files kept with the games
+	Skipped    []string // reasons that story files weren't added
+}
+
+// IsArchive reports whether the data is a zip or gzip-compressed tar archive.
+func IsArchive(b []byte) bool {
+	return isZip(b) || isGzip(b)
+}
+
+func isZip(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("PK\x03\x04"))
+}
+
+func isGzip(b []byte) bool {
+	return bytes.HasPrefix(b, []byte{0x1f, 0x8b})
+}
+
+// Unpack returns the regular files in a zip or tar.gz archive.  Directories,
+// links, hidden files, and operating-system clutter (like "__MACOSX") are
+// skipped.
+func Unpack(b []byte) ([]*ArchiveFile, error) {
+	switch {
+	case isZip(b):
+		return unpackZip(b)
+	case isGzip(b):
+		return unpackTarGz(b)
+	}
+
+	return nil, invalid("it isn’t a zip or tar.gz archive")
+}
+
+func unpackZip(b []byte) ([]*ArchiveFile, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, invalid("it’s a damaged zip archive (%s)", err.Error())
+	}
+
+	u := &unpacker{}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() || u.skip(f.Name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, invalid("it’s a damaged zip archive (%s)", err.Error())
+		}
+		err = u.add(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u.files, nil
+}
+
+func unpackTarGz(b []byte) ([]*ArchiveFile, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, invalid("it’s a damaged gzip file (%s)", err.Error())
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	u := &unpacker{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// A gzipped story file (rather than a tarball) ends up here too.
+			return nil, invalid("it’s gzip-compressed, but not a tar archive (%s)", err.Error())
+		}
+
+		if hdr.Typeflag != tar.TypeReg || u.skip(hdr.Name) {
+			continue
+		}
+
+		err = u.add(hdr.Name, tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return u.files, nil
+}
+
+type unpacker struct {
+	files []*ArchiveFile
+	size  int64
+}
+
+func (u *unpacker) skip(name string) bool {
+	name = strings.Replace(name, "\\", "/", -1)
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *unpacker) add(name string, r io.Reader) error {
+	if len(u.files) >= maxArchiveFiles {
+		return invalid("the archive has more than %d files", maxArchiveFiles)
+	}
+
+	// Read one byte past the limit so that we can tell if it's too big.
+	remaining := maxArchiveSize - u.size
+	b, err := ioutil.ReadAll(io.LimitReader(r, remaining+1))
+	if err != nil {
+		return invalid("it’s a damaged archive (%s)", err.Error())
+	}
+	if int64(len(b)) > remaining {
+		return invalid("it unpacks to more than %d MB", maxArchiveSize/(1024*1024))
+	}
+
+	u.size += int64(len(b))
+	u.files = append(u.files, &ArchiveFile{
+		Name: path.Clean(strings.Replace(name, "\\", "/", -1)),
+		Data: b,
+	})
+	return nil
+}
+
+// companionOwners decides which of the archive's stories each companion file
+// belongs with: the stories in the closest enclosing directory, or all of them
+// if none encloses it (a shared manual at the top of a multi-game archive, for
+// instance).
+func companionOwners(companion string, stories []string) []string {
+	dir := path.Dir(companion)
+	best := -1
+	owners := make([]string, 0, len(stories))
+
+	for _, story := range stories {
+		storyDir := path.Dir(story)
+		if storyDir != "." && dir != storyDir && !strings.HasPrefix(dir, storyDir+"/") {
+			continue
+		}
+
+		depth := 0
+		if storyDir != "." {
+			depth = strings.Count(storyDir, "/") + 1
+		}
+
+		switch {
+		case depth > best:
+			best = depth
+			owners = []string{story}
+		case depth == best:
+			owners = append(owners, story)
+		}
+	}
+
+	if len(owners) == 0 {
+		return stories
+	}
+	return owners
+}
+
+// storyExtensions are the file extensions commonly used for story files.
+var storyExtensions = map[string]bool{
+	".z1": true, ".z2": true, ".z3": true, ".z4": true,
+	".z5": true, ".z6": true, ".z7": true, ".z8": true,
+	".zblorb": true, ".zlb": true, ".blorb": true, ".blb": true,
+	".ulx": true, ".gblorb": true, ".glb": true,
+	".twee": true, ".tw": true,
+}
+
+func looksLikeStory(name string) bool {
+	return storyExtensions[strings.ToLower(path.Ext(name))]
+}
+
+func describeSkipped(name string, err error) string {
+	if e, ok := err.(RejectedError); ok {
+		return fmt.Sprintf("%s, because %s", name, e.Reason())
+	}
+	return fmt.Sprintf("%s (%s)", name, err.Error())
+}
@@ -0,0 +1,288 @@
+package games
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/ifiction"
+)
+
+// An admin can import a catalog of games (an iFiction export from IFDB, say)
+// to fill in what the story files themselves don't say: descriptions, genres,
+// ratings, play times, and awards.  Catalog entries are matched to games by
+// IFID, so the catalog can (and usually will) describe many more games than
+// are in the library; they're all kept, so that games added later are matched
+// too.
+const catalogFile = "catalog.json"
+
+// CatalogEntry is what the catalog says about one story.
+type CatalogEntry struct {
+	IFIDs         []string
+	TUID          string                 `json:",omitempty"` // IFDB's ID for the game
+	Link          string                 `json:",omitempty"`
+	Bibliographic ifiction.Bibliographic // (Title, Author, Genre, Description, ...)
+	AverageRating float64                `json:",omitempty"` // out of 5
+	RatingCount   int                    `json:",omitempty"`
+	PlayTime      string                 `json:",omitempty"` // like "1-2 hours"
+	Awards        []string               `json:",omitempty"`
+}
+
+// CatalogImport describes the result of importing a catalog.
+type CatalogImport struct {
+	Entries int         // entries in the imported catalog
+	Total   int         // entries in the whole catalog, afterward
+	Matched []*GameInfo // library games that the imported entries describe
+}
+
+type catalog struct {
+	Entries []*CatalogEntry
+}
+
+// ParseCatalog reads an iFiction catalog (like an IFDB export).
+func ParseCatalog(r io.Reader) ([]*CatalogEntry, error) {
+	index, err := ifiction.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("it isn’t an iFiction catalog (%s)", err.Error())
+	}
+
+	entries := make([]*CatalogEntry, 0, len(index.Stories))
+	for _, s := range index.Stories {
+		ifids := make([]string, 0, len(s.Identification.IFIDs))
+		for _, id := range s.Identification.IFIDs {
+			if id = strings.TrimSpace(id); id != "" {
+				ifids = append(ifids, id)
+			}
+		}
+		if len(ifids) == 0 {
+			continue
+		}
+
+		entry := &CatalogEntry{
+			IFIDs:         ifids,
+			Bibliographic: s.Bibliographic,
+		}
+		if s.IFDB != nil {
+			entry.TUID = s.IFDB.TUID
+			entry.Link = s.IFDB.Link
+			entry.PlayTime = s.IFDB.PlayTime
+			entry.Awards = s.IFDB.Awards
+			entry.AverageRating, entry.RatingCount = parseRating(s.IFDB.AverageRating, s.IFDB.RatingCount)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("it doesn’t describe any stories with IFIDs")
+	}
+
+	return entries, nil
+}
+
+// parseRating reads an IFDB star rating (from 0 to 5) and how many ratings
+// it's the average of.  Anything that doesn't make sense counts as no
+// ratings at all.
+func parseRating(average string, count string) (float64, int) {
+	a, err := strconv.ParseFloat(strings.TrimSpace(average), 64)
+	if err != nil || math.IsNaN(a) || math.IsInf(a, 0) {
+		return 0, 0
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n <= 0 {
+		return 0, 0
+	}
+	return math.Max(0, math.Min(a, 5)), n
+}
+
+func (fs *FileSys) catalogFile() string {
+	return path.Join(fs.Directory, libraryDir, catalogFile)
+}
+
+// loadCatalog returns the catalog, by upper-case IFID, re-reading it only if
+// the file has changed.  The caller must hold the mutex.
+func (fs *FileSys) loadCatalog() map[string]*CatalogEntry {
+	info, err := os.Stat(fs.catalogFile())
+	if err != nil {
+		fs.catalog = nil
+		return nil
+	}
+
+	if fs.catalog != nil && fs.catalogTime.Equal(info.ModTime()) {
+		return fs.catalog
+	}
+
+	c, err := fs.readCatalog()
+	if err != nil {
+		fs.Logger.WithError(err).Warn("ignoring unreadable game catalog")
+		return nil
+	}
+
+	fs.catalog = make(map[string]*CatalogEntry)
+	for _, entry := range c.Entries {
+		for _, id := range entry.IFIDs {
+			fs.catalog[strings.ToUpper(id)] = entry
+		}
+	}
+	fs.catalogTime = info.ModTime()
+
+	return fs.catalog
+}
+
+func (fs *FileSys) readCatalog() (*catalog, error) {
+	c := &catalog{}
+
+	b, err := ioutil.ReadFile(fs.catalogFile())
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyCatalog finds each game's catalog entry, if it has one.  The caller
+// must hold the mutex.
+func (fs *FileSys) applyCatalog(games []*GameInfo) {
+	entries := fs.loadCatalog()
+
+	for _, g := range games {
+		g.Catalog = nil
+		if entries == nil {
+			continue
+		}
+
+		ifids := []string{g.ID}
+		if g.Story != nil {
+			ifids = append(ifids, g.Story.AltIFIDs...)
+		}
+		for _, id := range ifids {
+			if entry, ok := entries[strings.ToUpper(id)]; ok && id != "" {
+				g.Catalog = entry
+				break
+			}
+		}
+	}
+}
+
+// ImportCatalog adds the entries in an iFiction catalog to the library's
+// catalog, replacing any earlier entries for the same stories.
+func (fs *FileSys) ImportCatalog(r io.Reader) (*CatalogImport, error) {
+	entries, err := ParseCatalog(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	c, err := fs.readCatalog()
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the old entries that share an IFID with a new one.
+	replaced := make(map[string]bool)
+	for _, entry := range entries {
+		for _, id := range entry.IFIDs {
+			replaced[strings.ToUpper(id)] = true
+		}
+	}
+
+	merged := make([]*CatalogEntry, 0, len(c.Entries)+len(entries))
+	for _, old := range c.Entries {
+		keep := true
+		for _, id := range old.IFIDs {
+			if replaced[strings.ToUpper(id)] {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			merged = append(merged, old)
+		}
+	}
+	merged = append(merged, entries...)
+
+	err = os.MkdirAll(path.Dir(fs.catalogFile()), os.FileMode(0755))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(&catalog{Entries: merged})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = writeFileAtomic(fs.catalogFile(), b)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.invalidateIndex()
+	idx, err := fs.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CatalogImport{
+		Entries: len(entries),
+		Total:   len(merged),
+		Matched: make([]*GameInfo, 0),
+	}
+	for _, g := range idx.Games {
+		if g.Catalog != nil && replaced[strings.ToUpper(g.Catalog.IFIDs[0])] {
+			result.Matched = append(result.Matched, g)
+		}
+	}
+
+	fs.Logger.WithFields(log.Fields{
+		"entries": result.Entries,
+		"total":   result.Total,
+		"matched": len(result.Matched),
+	}).Info("imported game catalog")
+
+	return result, nil
+}
+
+// Bibliographic returns what's known about the game: its own metadata, with
+// any gaps filled in from the catalog.  It's never nil.
+func (g *GameInfo) Bibliographic() *ifiction.Bibliographic {
+	b := &ifiction.Bibliographic{}
+	if g.Metadata != nil {
+		*b = *g.Metadata
+	}
+
+	if g.Catalog != nil {
+		c := &g.Catalog.Bibliographic
+		fill := func(field *string, value string) {
+			if *field == "" {
+				*field = value
+			}
+		}
+		fill(&b.Title, c.Title)
+		fill(&b.Author, c.Author)
+		fill(&b.Headline, c.Headline)
+		fill(&b.Genre, c.Genre)
+		fill(&b.FirstPublished, c.FirstPublished)
+		fill(&b.Description, c.Description)
+		fill(&b.Language, c.Language)
+		fill(&b.Group, c.Group)
+	}
+
+	return b
+}
@@ -0,0 +1,164 @@
+package games
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Companion files (manuals, maps, readmes, and other "feelies") are kept in
+// the library directory, in a subdirectory per game file.
+const companionsDir = "companions"
+
+func (fs *FileSys) companionDir(fileName string) string {
+	return path.Join(fs.Directory, libraryDir, companionsDir, fileName)
+}
+
+// companions lists a game's companion files.
+func (fs *FileSys) companions(fileName string) []string {
+	infos, err := ioutil.ReadDir(fs.companionDir(fileName))
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addCompanion stores a companion file with a game.  The caller must hold the
+// mutex.
+func (fs *FileSys) addCompanion(game *GameInfo, name string, b []byte) error {
+	dir := fs.companionDir(game.FileName)
+	err := os.MkdirAll(dir, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	name, err = CleanFileName(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(path.Join(dir, name), b)
+	if err != nil {
+		return err
+	}
+
+	game.Companions = fs.companions(game.FileName)
+	fs.invalidateIndex()
+	return nil
+}
+
+func (fs *FileSys) removeCompanions(fileName string) error {
+	return os.RemoveAll(fs.companionDir(fileName))
+}
+
+// GetCompanionFile returns the path to one of a game's companion files.
+func (fs *FileSys) GetCompanionFile(game string, name string) (string, error) {
+	g, err := fs.GetGame(game)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range g.Companions {
+		if strings.EqualFold(c, name) {
+			return path.Join(fs.companionDir(g.FileName), c), nil
+		}
+	}
+
+	return "", fmt.Errorf("“%s” doesn’t have a file called “%s”", g.Name, name)
+}
+
+// AddCompanions attaches a file to a game as a companion, replacing any
+// companion with the same name.  A zip or tar.gz archive is unpacked, and
+// every file in it attached.  It returns the game (with its new list of
+// companions) and the names of the files that were attached.
+func (fs *FileSys) AddCompanions(name string, fileName string, r io.Reader) (*GameInfo, []string, error) {
+	fileName, err := CleanFileName(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err := readLimited(r, MaxUploadSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files := []*ArchiveFile{{Name: fileName, Data: b}}
+	if IsArchive(b) {
+		files, err = Unpack(b)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGame(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	added := make([]string, 0, len(files))
+	for _, f := range files {
+		err = checkSize(f.Data, MaxGameSize)
+		if err == nil {
+			err = fs.addCompanion(game, path.Base(f.Name), f.Data)
+		}
+		if err != nil {
+			fs.Logger.WithField("game", game.FileName).WithField("file", f.Name).WithError(err).Warn("skipping companion file")
+			continue
+		}
+		added = append(added, path.Base(f.Name))
+	}
+
+	if len(added) == 0 {
+		return nil, nil, &InvalidNameError{fileName, "none of its files could be kept"}
+	}
+
+	fs.Logger.WithField("game", game.FileName).WithField("files", added).Info("added companion files")
+
+	// Rebuild the index now, so that the saved copy lists the new files.
+	_, err = fs.getIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	return game, added, nil
+}
+
+// RemoveCompanion removes one of a game's companion files.
+func (fs *FileSys) RemoveCompanion(game string, name string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	g, err := fs.findGameExactly(game)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range g.Companions {
+		if strings.EqualFold(c, name) {
+			err = os.Remove(path.Join(fs.companionDir(g.FileName), c))
+			if err != nil {
+				return err
+			}
+			g.Companions = fs.companions(g.FileName)
+			fs.invalidateIndex()
+			_, err = fs.getIndex()
+			return err
+		}
+	}
+
+	return fmt.Errorf("“%s” doesn’t have a file called “%s”", g.Name, name)
+}
@@ -3,119 +3,404 @@ package games
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Library metadata is kept in a hidden directory alongside the games, so that
+// it isn't mistaken for a game itself.
+const libraryDir = ".library"
+
 // FileSys ...
 type FileSys struct {
 	Directory string
 	Logger    log.FieldLogger
 	// InterpreterFactory interpreter.InterpreterFactory
+
+	mutex       sync.Mutex
+	index       *index
+	catalog     map[string]*CatalogEntry // by upper-case IFID
+	catalogTime time.Time
 }
 
-// GetGames returns the list of available games.  This may (in the future)
-// include metadata about the games, a game image/icon, etc.
-func (fs *FileSys) GetGames() ([]string, error) {
-	games, err := fs.getGames()
+// GetGames returns the available games, sorted by name.
+func (fs *FileSys) GetGames() ([]*GameInfo, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	idx, err := fs.getIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	names := make([]string, 0)
+	games := make([]*GameInfo, len(idx.Games))
+	copy(games, idx.Games)
+	return games, nil
+}
 
-	for name := range games {
-		names = append(names, name)
-	}
+// GetGame looks up a game by name, by full file name, or by IFID.
+func (fs *FileSys) GetGame(name string) (*GameInfo, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
 
-	return names, nil
+	return fs.findGame(name)
 }
 
-func (fs *FileSys) getGames() (map[string]os.FileInfo, error) {
-	dir, err := os.Open(fs.Directory)
+// findGame looks up a game; the caller must hold the mutex.  Exact names and
+// file names take precedence over IFIDs and aliases, which take precedence
+// over loose matches of names and titles.  If nothing matches, the
+// *NotFoundError suggests the closest names.
+func (fs *FileSys) findGame(name string) (*GameInfo, error) {
+	idx, err := fs.getIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	infos, err := dir.Readdir(-1)
+	for _, g := range idx.Games {
+		if g.Name == name || g.FileName == name {
+			return g, nil
+		}
+	}
+
+	for _, g := range idx.Games {
+		if g.Matches(name) {
+			return g, nil
+		}
+	}
+
+	if g := fs.findAlias(idx.Games, name); g != nil {
+		return g, nil
+	}
+
+	if g := looseMatch(idx.Games, name); g != nil {
+		return g, nil
+	}
+
+	return nil, &NotFoundError{Name: name, Suggestions: suggest(idx.Games, name)}
+}
+
+// findGameExactly looks up a game by its exact name or file name, for changes
+// that are hard to undo (like deleting it), where a loose match could pick
+// the wrong game.  The caller must hold the mutex.
+func (fs *FileSys) findGameExactly(name string) (*GameInfo, error) {
+	idx, err := fs.getIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	games := make(map[string]os.FileInfo)
-
-	for _, info := range infos {
-		if info.Mode().IsRegular() {
-			// Remove extension for game list
-			name := info.Name()
-			ext := path.Ext(name)
-			games[strings.TrimSuffix(name, ext)] = info
+	for _, g := range idx.Games {
+		if g.Name == name || g.FileName == name {
+			return g, nil
 		}
 	}
 
-	return games, nil
+	return nil, &NotFoundError{Name: name, Suggestions: suggest(idx.Games, name)}
 }
 
 // GetGameFile returns the path to the game (in a form that can be passed to
-// things like game interpreters).
+// things like game interpreters).  The game can be given by name or by IFID.
 func (fs *FileSys) GetGameFile(name string) (string, error) {
-	games, err := fs.getGames()
+	game, err := fs.GetGame(name)
 	if err != nil {
 		return "", err
 	}
 
-	info, ok := games[name]
-	if !ok {
-		return "", fmt.Errorf("Game “%s” not found", name)
+	if !game.Playable() {
+		return "", fmt.Errorf("“%s” can’t be played: %s", game.Name, game.Problem)
+	}
+
+	return path.Join(fs.Directory, game.FileName), nil
+}
+
+// FindGamesByIFID returns all the games with the given IFID.  Normally there
+// is at most one, but the same story may have been uploaded under different
+// file names.
+func (fs *FileSys) FindGamesByIFID(ifid string) ([]*GameInfo, error) {
+	games, err := fs.GetGames()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]*GameInfo, 0)
+	for _, g := range games {
+		if g.HasIFID(ifid) {
+			found = append(found, g)
+		}
+	}
+
+	return found, nil
+}
+
+// AddGameFile adds a new game to the repository.  The data is inspected first,
+// and an *InvalidStoryError is returned if it isn't a playable story file.
+func (fs *FileSys) AddGameFile(fileName string, r io.Reader, addedBy string) (*GameInfo, error) {
+	logger2 := fs.Logger.WithField("game", fileName)
+
+	fileName, err := CleanFileName(fileName)
+	if err != nil {
+		logger2.WithError(err).Warn("rejecting game file")
+		return nil, err
+	}
+
+	b, err := readLimited(r, MaxGameSize)
+	if err != nil {
+		logger2.WithError(err).Warn("reading game data")
+		return nil, err
+	}
+
+	story, err := Inspect(b)
+	if err != nil {
+		logger2.WithError(err).Warn("rejecting game file")
+		return nil, err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.addGame(fileName, b, story, addedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fs.getIndex()
+	if err != nil {
+		logger2.WithError(err).Warn("updating game index")
+	}
+
+	return game, nil
+}
+
+// AddArchive unpacks a zip or tar.gz archive, adding each story file inside
+// as a game, and keeping the other files with the game(s) as companions.
+// Story files that can't be added are listed in the result's Skipped; an
+// error is returned only if the archive is unusable or has no stories in it.
+func (fs *FileSys) AddArchive(fileName string, r io.Reader, addedBy string) (*UploadResult, error) {
+	logger := fs.Logger.WithField("archive", fileName)
+
+	b, err := readLimited(r, MaxUploadSize)
+	if err != nil {
+		logger.WithError(err).Warn("reading archive data")
+		return nil, err
+	}
+
+	files, err := Unpack(b)
+	if err != nil {
+		logger.WithError(err).Warn("rejecting archive")
+		return nil, err
+	}
+
+	result := &UploadResult{}
+	stories := make(map[string]*StoryInfo)
+	storyNames := make([]string, 0)
+	companions := make([]*ArchiveFile, 0, len(files))
+	for _, f := range files {
+		story, err := Inspect(f.Data)
+		if err != nil {
+			// Something that looks like a story but isn't playable (Glulx,
+			// say) is worth mentioning, rather than quietly keeping.
+			if looksLikeStory(f.Name) {
+				result.Skipped = append(result.Skipped, describeSkipped(f.Name, err))
+			} else {
+				companions = append(companions, f)
+			}
+			continue
+		}
+		stories[f.Name] = story
+		storyNames = append(storyNames, f.Name)
+	}
+
+	if len(storyNames) == 0 {
+		logger.Warn("rejecting archive without story files")
+		return nil, invalid("there are no playable story files in the archive")
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	added := make(map[string]*GameInfo)
+	for _, f := range files {
+		story, ok := stories[f.Name]
+		if !ok {
+			continue
+		}
+
+		name, err := CleanFileName(path.Base(f.Name))
+		if err == nil {
+			err = checkSize(f.Data, MaxGameSize)
+		}
+		if err != nil {
+			result.Skipped = append(result.Skipped, describeSkipped(f.Name, err))
+			continue
+		}
+
+		game, err := fs.addGame(name, f.Data, story, addedBy)
+		if err != nil {
+			result.Skipped = append(result.Skipped, describeSkipped(f.Name, err))
+			continue
+		}
+		added[f.Name] = game
+		result.Games = append(result.Games, game)
+	}
+
+	for _, f := range companions {
+		kept := false
+		for _, owner := range companionOwners(f.Name, storyNames) {
+			game, ok := added[owner]
+			if !ok {
+				continue
+			}
+			err = fs.addCompanion(game, path.Base(f.Name), f.Data)
+			if err != nil {
+				logger.WithField("companion", f.Name).WithError(err).Error("saving companion file")
+				continue
+			}
+			kept = true
+		}
+		if kept {
+			result.Companions = append(result.Companions, path.Base(f.Name))
+		}
+	}
+
+	_, err = fs.getIndex()
+	if err != nil {
+		logger.WithError(err).Warn("updating game index")
 	}
 
-	return path.Join(fs.Directory, info.Name()), nil
+	logger.WithFields(log.Fields{
+		"games":      len(result.Games),
+		"companions": len(result.Companions),
+		"skipped":    len(result.Skipped),
+	}).Info("unpacked archive")
+
+	return result, nil
 }
 
-// AddGameFile adds a new game to the repository
-func (fs *FileSys) AddGameFile(fileName string, r io.Reader) error {
-	// FUTURE: Ensure there are no relative file parts ("..", "/") in the
-	// name...
+// addGame writes an already-inspected story file into the directory.  The
+// caller must hold the mutex.
+func (fs *FileSys) addGame(fileName string, b []byte, story *StoryInfo, addedBy string) (*GameInfo, error) {
 	gameFile := path.Join(fs.Directory, fileName)
 	logger2 := fs.Logger.WithFields(log.Fields{
 		"game": fileName,
 		"file": gameFile,
 	})
 
-	logger2.Info("adding game")
-	f, err := os.Create(gameFile)
+	err := fs.checkCollision(fileName)
 	if err != nil {
-		logger2.WithError(err).Error("creating game file")
-		return err
+		logger2.WithError(err).Warn("rejecting game file")
+		return nil, err
+	}
+
+	// Keep the version being replaced, if any, for games in progress (and
+	// saves) that use it.
+	if old, err := fs.findGame(fileName); err == nil && old.FileName == fileName && old.Playable() {
+		_, err = fs.recordCurrentVersion(old)
+		if err != nil {
+			logger2.WithError(err).Error("keeping previous version")
+			return nil, err
+		}
 	}
-	defer f.Close()
 
-	written, err := io.Copy(f, r)
+	logger2.WithField("info", story).Info("adding game")
+	info, err := writeFileAtomic(gameFile, b)
 	if err != nil {
 		logger2.WithError(err).Error("writing game file")
+		return nil, err
+	}
+
+	logger2.WithField("written", info.Size()).Info("game file written")
+
+	// Seed the index with what we already know, so that rebuilding it keeps
+	// who added the game (and doesn't re-inspect it).
+	game := &GameInfo{
+		ID:       story.IFID,
+		FileName: fileName,
+		Format:   story.Format,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		AddedBy:  addedBy,
+		AddedAt:  time.Now(),
+		Checksum: checksum(b),
+		Metadata: story.Metadata,
+		Story:    story,
+	}
+	fs.replaceIndexEntry(game)
+
+	_, err = fs.recordVersion(game, b)
+	if err != nil {
+		logger2.WithError(err).Warn("recording game version")
+	}
+
+	return game, nil
+}
+
+func (fs *FileSys) replaceIndexEntry(game *GameInfo) {
+	if fs.index == nil {
+		fs.index = &index{Version: indexVersion, InspectorVersion: inspectorVersion}
+	}
+
+	games := make([]*GameInfo, 0, len(fs.index.Games)+1)
+	for _, g := range fs.index.Games {
+		if g.FileName != game.FileName {
+			games = append(games, g)
+		}
+	}
+	fs.index.Games = append(games, game)
+	fs.invalidateIndex()
+}
+
+// checkCollision ensures that a new file won't collide with an existing game
+// that has the same base name.  (Replacing a file with the same name is fine.)
+// The caller must hold the mutex.
+func (fs *FileSys) checkCollision(fileName string) error {
+	idx, err := fs.getIndex()
+	if err != nil {
 		return err
 	}
 
-	logger2.WithField("written", written).Info("game file written")
+	base := baseName(fileName)
+	for _, g := range idx.Games {
+		if g.FileName == fileName {
+			continue
+		}
+		if baseName(g.FileName) == base {
+			return &ConflictError{
+				Problem: fmt.Sprintf("there’s already a game called “%s” (from %s); rename the new file or delete the old game first", g.Name, g.FileName),
+			}
+		}
+	}
 
 	return nil
 }
 
-// DeleteGameFile removes a game from the repository
-func (fs *FileSys) DeleteGameFile(fileName string) error {
-	// FUTURE: Ensure there are no relative file parts ("..", "/") in the
-	// name...
-	gameFile := path.Join(fs.Directory, fileName)
+// DeleteGameFile removes a game from the repository.  The game must be given by
+// its exact name or file name.
+func (fs *FileSys) DeleteGameFile(name string) error {
+	if strings.ContainsAny(name, "/\\\x00") {
+		return &InvalidNameError{name, "it contains a directory separator"}
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGameExactly(name)
+	if err != nil {
+		return err
+	}
+
+	gameFile := path.Join(fs.Directory, game.FileName)
 	logger := fs.Logger.WithFields(log.Fields{
-		"game": fileName,
+		"game": game.Name,
 		"file": gameFile,
 	})
 
 	logger.Info("deleting game")
-	err := os.Remove(gameFile)
+	err = os.Remove(gameFile)
 	if err != nil {
 		logger.WithError(err).Error("deleting game file")
 		return err
@@ -123,5 +408,73 @@ func (fs *FileSys) DeleteGameFile(fileName string) error {
 
 	logger.Info("game file deleted")
 
+	err = fs.removeCompanions(game.FileName)
+	if err != nil {
+		logger.WithError(err).Warn("deleting companion files")
+	}
+
+	err = fs.removeVersions(game.FileName)
+	if err != nil {
+		logger.WithError(err).Warn("deleting previous versions")
+	}
+
+	err = fs.removeAliasesFor(game.FileName)
+	if err != nil {
+		logger.WithError(err).Warn("deleting aliases")
+	}
+
+	err = fs.removeLabelsFor(game.FileName)
+	if err != nil {
+		logger.WithError(err).Warn("deleting labels")
+	}
+
+	fs.invalidateIndex()
+
 	return nil
 }
+
+// readLimited reads all of r, failing with a *TooLargeError if there's more
+// than limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	return b, checkSize(b, limit)
+}
+
+func checkSize(b []byte, limit int64) error {
+	if int64(len(b)) > limit {
+		return &TooLargeError{Limit: limit}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file via a hidden temporary file in the same
+// directory, renamed into place once it's complete, so that a failed write
+// never leaves a partial game behind (or replaces a good one).
+func writeFileAtomic(file string, b []byte) (os.FileInfo, error) {
+	tmp, err := ioutil.TempFile(path.Dir(file), ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), os.FileMode(0644))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp.Name(), file)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(file)
+}
@@ -0,0 +1,288 @@
+package games
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The index records everything we know about the games in the directory, so
+// that listing games doesn't mean re-reading (and re-hashing) every file.  It
+// is rebuilt only when the directory itself changes (a file is added, removed,
+// or renamed); even then, entries for files whose size and modification time
+// haven't changed are reused.
+
+const (
+	indexFile    = "index.json"
+	indexVersion = 1
+)
+
+type index struct {
+	Version          int
+	InspectorVersion int
+	DirModTime       time.Time
+	Games            []*GameInfo
+}
+
+func (fs *FileSys) indexFile() string {
+	return path.Join(fs.Directory, libraryDir, indexFile)
+}
+
+// getIndex returns the current index, rebuilding it if the directory has
+// changed since it was built.  The caller must hold the mutex.
+func (fs *FileSys) getIndex() (*index, error) {
+	dirInfo, err := os.Stat(fs.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if fs.index == nil {
+		fs.index = fs.readIndex()
+	}
+
+	if fs.index != nil && fs.index.DirModTime.Equal(dirInfo.ModTime()) {
+		return fs.index, nil
+	}
+
+	return fs.rebuildIndex()
+}
+
+// invalidateIndex forces the next getIndex to rescan the directory, even if
+// its modification time looks unchanged (a file replaced in place, or two
+// changes within the file system's timestamp granularity).
+func (fs *FileSys) invalidateIndex() {
+	if fs.index != nil {
+		fs.index.DirModTime = time.Time{}
+	}
+}
+
+// Rescan checks the games in the index against the files on disk, rebuilding
+// the index if any have changed in place.  (Added and removed files already
+// show up as a change to the directory itself.)
+func (fs *FileSys) Rescan() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	idx, err := fs.getIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, g := range idx.Games {
+		info, err := os.Stat(path.Join(fs.Directory, g.FileName))
+		if err != nil || info.Size() != g.Size || !info.ModTime().Equal(g.ModTime) {
+			fs.invalidateIndex()
+			_, err = fs.getIndex()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fs *FileSys) readIndex() *index {
+	b, err := ioutil.ReadFile(fs.indexFile())
+	if err != nil {
+		return nil
+	}
+
+	idx := &index{}
+	err = json.Unmarshal(b, idx)
+	if err != nil {
+		fs.Logger.WithError(err).Warn("ignoring unreadable game index")
+		return nil
+	}
+
+	if idx.Version != indexVersion || idx.InspectorVersion != inspectorVersion {
+		return nil
+	}
+
+	return idx
+}
+
+func (fs *FileSys) rebuildIndex() (*index, error) {
+	infos, err := ioutil.ReadDir(fs.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string]*GameInfo)
+	if fs.index != nil {
+		for _, g := range fs.index.Games {
+			previous[g.FileName] = g
+		}
+	}
+
+	idx := &index{
+		Version:          indexVersion,
+		InspectorVersion: inspectorVersion,
+		Games:            make([]*GameInfo, 0, len(infos)),
+	}
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		old := previous[info.Name()]
+		if old != nil && old.Size == info.Size() && old.ModTime.Equal(info.ModTime()) {
+			idx.Games = append(idx.Games, old)
+			continue
+		}
+
+		game, err := fs.describe(info)
+		if err != nil {
+			fs.Logger.WithField("file", info.Name()).WithError(err).Warn("skipping unreadable game file")
+			continue
+		}
+		if old != nil {
+			// A changed file is still the same library entry.
+			game.AddedBy = old.AddedBy
+			game.AddedAt = old.AddedAt
+		}
+		idx.Games = append(idx.Games, game)
+	}
+
+	for _, g := range idx.Games {
+		g.Companions = fs.companions(g.FileName)
+	}
+	fs.applyLabels(idx.Games)
+	fs.applyCatalog(idx.Games)
+
+	fs.assignNames(idx.Games)
+	sort.Sort(GameInfos(idx.Games))
+
+	// Creating the library directory changes the game directory, so make sure
+	// it exists before noting the directory's time.
+	err = os.MkdirAll(path.Dir(fs.indexFile()), os.FileMode(0755))
+	if err != nil {
+		return nil, err
+	}
+	dirInfo, err := os.Stat(fs.Directory)
+	if err != nil {
+		return nil, err
+	}
+	idx.DirModTime = dirInfo.ModTime()
+
+	err = fs.writeIndex(idx)
+	if err != nil {
+		fs.Logger.WithError(err).Warn("writing game index")
+	}
+
+	fs.index = idx
+	fs.Logger.WithField("games", len(idx.Games)).Debug("rebuilt game index")
+
+	return idx, nil
+}
+
+func (fs *FileSys) writeIndex(idx *index) error {
+	file := fs.indexFile()
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	err = ioutil.WriteFile(tmp, b, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+// describe inspects and hashes a game file.  Files that aren't playable
+// stories are still described (so they can be listed and deleted), but have
+// a Problem rather than a Story.
+func (fs *FileSys) describe(info os.FileInfo) (*GameInfo, error) {
+	b, err := ioutil.ReadFile(path.Join(fs.Directory, info.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	game := &GameInfo{
+		FileName: info.Name(),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		AddedAt:  info.ModTime(),
+		Checksum: checksum(b),
+	}
+
+	story, err := Inspect(b)
+	if err != nil {
+		game.Problem = err.Error()
+		return game, nil
+	}
+
+	game.ID = story.IFID
+	game.Format = story.Format
+	game.Metadata = story.Metadata
+	game.Story = story
+
+	// If this file was recorded as a version (by us, or by another instance
+	// sharing the library), that says who added it and when.
+	if versions, err := fs.readVersions(info.Name()); err == nil {
+		for _, v := range versions {
+			if v.Checksum == game.Checksum {
+				game.AddedBy = v.AddedBy
+				if !v.AddedAt.IsZero() {
+					game.AddedAt = v.AddedAt
+				}
+			}
+		}
+	}
+
+	return game, nil
+}
+
+// assignNames gives each game its short name (the file name without its
+// extension) and display name.  Two files with the same base name (like
+// "curses.z5" and "curses.z8") would otherwise silently shadow each other, so
+// they are named by their full file names instead.
+func (fs *FileSys) assignNames(games []*GameInfo) {
+	byBase := make(map[string][]*GameInfo)
+	for _, g := range games {
+		base := baseName(g.FileName)
+		byBase[base] = append(byBase[base], g)
+	}
+
+	for base, list := range byBase {
+		if len(list) == 1 {
+			list[0].Name = base
+		} else {
+			names := make([]string, 0, len(list))
+			for _, g := range list {
+				g.Name = g.FileName
+				names = append(names, g.FileName)
+			}
+			fs.Logger.WithFields(log.Fields{
+				"game":  base,
+				"files": names,
+			}).Warn("game base-name collision")
+		}
+
+		for _, g := range list {
+			g.DisplayName = g.Title()
+			if g.DisplayName == "" {
+				g.DisplayName = g.Name
+			}
+		}
+	}
+}
+
+func baseName(fileName string) string {
+	return strings.TrimSuffix(fileName, path.Ext(fileName))
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
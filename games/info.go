@@ -0,0 +1,91 @@
+package games
+
+import (
+	"strings"
+	"time"
+
+	"github.com/JaredReisinger/xyzzybot/babel"
+	"github.com/JaredReisinger/xyzzybot/ifiction"
+)
+
+// GameInfo describes a game in a repository.
+type GameInfo struct {
+	// ID is the game's IFID, which is its stable identity regardless of
+	// file name.
+	ID string
+
+	// Name is the short name used to refer to the game in commands (usually
+	// the file name without its extension).
+	Name string
+
+	// DisplayName is the game's title if known, or its Name.
+	DisplayName string
+
+	FileName string
+	Format   string
+	Size     int64
+	ModTime  time.Time
+	AddedBy  string    `json:",omitempty"`
+	AddedAt  time.Time // when the game was added (or first noticed)
+	Checksum string    // SHA-256 of the file
+
+	// Metadata is the game's bibliographic information, if known.
+	Metadata *ifiction.Bibliographic `json:",omitempty"`
+
+	// Story is the detailed information from inspecting the story file.  It's
+	// nil if the file isn't playable, in which case Problem says why.
+	Story   *StoryInfo `json:",omitempty"`
+	Problem string     `json:",omitempty"`
+
+	// Companions are the names of the files (manuals, maps, and so on) kept
+	// with the game.
+	Companions []string `json:",omitempty"`
+
+	// Rating is the content rating an admin has given the game (one of
+	// Ratings), and Categories are the admin's categories for it.
+	Rating     string   `json:",omitempty"`
+	Categories []string `json:",omitempty"`
+
+	// Catalog is what the imported catalog says about the game, if anything.
+	Catalog *CatalogEntry `json:",omitempty"`
+}
+
+// Playable reports whether the game's file is a playable story.
+func (g *GameInfo) Playable() bool {
+	return g.Story != nil
+}
+
+// Title returns the game's title, if known.
+func (g *GameInfo) Title() string {
+	return g.Bibliographic().Title
+}
+
+// Author returns the game's author, if known.
+func (g *GameInfo) Author() string {
+	return g.Bibliographic().Author
+}
+
+// HasIFID reports whether the game is identified by ifid.
+func (g *GameInfo) HasIFID(ifid string) bool {
+	if g.Story != nil {
+		return g.Story.HasIFID(ifid)
+	}
+	return g.ID != "" && babel.Equal(g.ID, ifid)
+}
+
+// Matches reports whether the game is the one referred to by name, which may
+// be its name, file name, or IFID.  Names are matched case-insensitively.
+func (g *GameInfo) Matches(name string) bool {
+	return strings.EqualFold(g.Name, name) ||
+		strings.EqualFold(g.FileName, name) ||
+		g.HasIFID(name)
+}
+
+// GameInfos is a sortable list of games.
+type GameInfos []*GameInfo
+
+func (l GameInfos) Len() int      { return len(l) }
+func (l GameInfos) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l GameInfos) Less(i, j int) bool {
+	return strings.ToLower(l[i].Name) < strings.ToLower(l[j].Name)
+}
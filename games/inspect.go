@@ -0,0 +1,340 @@
+package games
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/babel"
+	"github.com/JaredReisinger/xyzzybot/blorb"
+	"github.com/JaredReisinger/xyzzybot/iff"
+	"github.com/JaredReisinger/xyzzybot/ifiction"
+	"github.com/JaredReisinger/xyzzybot/ink"
+	"github.com/JaredReisinger/xyzzybot/twine"
+	"github.com/JaredReisinger/xyzzybot/zmachine"
+)
+
+// Story file formats...
+const (
+	ZCodeFormat = "zcode"
+	BlorbFormat = "blorb" // Blorb-wrapped Z-code
+	InkFormat   = "ink"   // compiled Ink (JSON)
+	TwineFormat = "twine" // Twee source or published Twine HTML
+)
+
+// inspectorVersion is bumped whenever Inspect learns something new, so that
+// previously-recorded info gets refreshed.
+const inspectorVersion = 5
+
+// StoryInfo describes a story file, as determined by inspecting its contents.
+type StoryInfo struct {
+	IFID     string
+	Format   string
+	Version  int
+	Release  int
+	Serial   string
+	Checksum uint16
+	Length   int64
+	Warnings []string `json:",omitempty"`
+
+	// StoryFormat is the Twine story format the story was written for, like
+	// "Harlowe 3.3.8".
+	StoryFormat string `json:",omitempty"`
+
+	// Metadata is the bibliographic information embedded in a Blorb.
+	Metadata *ifiction.Bibliographic `json:",omitempty"`
+
+	// HasCover is true when a Blorb includes cover art (a frontispiece).
+	HasCover bool
+
+	// AltIFIDs are other IFIDs the story is known by (for instance, those of
+	// earlier releases, as listed in its metadata).
+	AltIFIDs []string `json:",omitempty"`
+
+	InspectorVersion int
+}
+
+// HasIFID reports whether the story is identified by ifid.
+func (info *StoryInfo) HasIFID(ifid string) bool {
+	if babel.Equal(info.IFID, ifid) {
+		return true
+	}
+	for _, alt := range info.AltIFIDs {
+		if babel.Equal(alt, ifid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Title returns the story's title, if known.
+func (info *StoryInfo) Title() string {
+	if info.Metadata == nil {
+		return ""
+	}
+	return info.Metadata.Title
+}
+
+// Author returns the story's author, if known.
+func (info *StoryInfo) Author() string {
+	if info.Metadata == nil {
+		return ""
+	}
+	return info.Metadata.Author
+}
+
+// String returns a human-friendly description like "Z-code v8, release 2 /
+// 080406".
+func (info *StoryInfo) String() string {
+	switch info.Format {
+	case InkFormat:
+		return fmt.Sprintf("Ink (format %d)", info.Version)
+	case TwineFormat:
+		if info.StoryFormat == "" {
+			return "Twine"
+		}
+		return fmt.Sprintf("Twine (%s)", info.StoryFormat)
+	}
+
+	format := fmt.Sprintf("Z-code v%d", info.Version)
+	if info.Format == BlorbFormat {
+		format = fmt.Sprintf("%s (Blorb)", format)
+	}
+
+	return fmt.Sprintf("%s, release %d / %s", format, info.Release, info.Serial)
+}
+
+// InvalidStoryError is returned when a file is not a playable story file.
+type InvalidStoryError struct {
+	Problem string
+}
+
+func (e *InvalidStoryError) Error() string {
+	return fmt.Sprintf("not a story file: %s", e.Problem)
+}
+
+// Reason says what's wrong with the file.
+func (e *InvalidStoryError) Reason() string {
+	return e.Problem
+}
+
+// ConflictError is returned when a new game would collide with an existing
+// one.
+type ConflictError struct {
+	Problem string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("game conflict: %s", e.Problem)
+}
+
+// Reason says what the new game collides with.
+func (e *ConflictError) Reason() string {
+	return e.Problem
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &InvalidStoryError{Problem: fmt.Sprintf(format, args...)}
+}
+
+// InspectFile inspects a story file on disk.
+func InspectFile(file string) (*StoryInfo, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return Inspect(b)
+}
+
+// Inspect determines whether the data is a playable story file, returning an
+// *InvalidStoryError with a specific reason if it isn't.
+func Inspect(b []byte) (*StoryInfo, error) {
+	info, err := inspect(b)
+	if err != nil {
+		return nil, err
+	}
+
+	info.IFID, err = babel.IFID(b)
+	if err != nil {
+		return nil, invalid("I couldn’t determine its IFID (%s)", err.Error())
+	}
+
+	return info, nil
+}
+
+func inspect(b []byte) (*StoryInfo, error) {
+	switch {
+	case len(b) == 0:
+		return nil, invalid("the file is empty")
+
+	// Published Twine stories are web pages, so they have to be recognized
+	// before we reject other web pages.
+	case twine.IsStory(b):
+		return inspectTwine(b)
+
+	case looksLikeHTML(b):
+		return nil, invalid("it looks like a web page (HTML), not a game; check that the URL points directly at the game file")
+
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")):
+		return nil, invalid("it’s a zip archive; unpack it and upload the story file inside")
+
+	case bytes.HasPrefix(b, []byte("\x1f\x8b")):
+		return nil, invalid("it’s a gzip-compressed file; decompress it and upload the story file inside")
+
+	case bytes.HasPrefix(b, []byte("Glul")):
+		return nil, invalid("it’s a Glulx game, which my interpreter can’t run (only Z-code, Ink, and Twine are supported)")
+
+	case iff.IsForm(b, ""):
+		return inspectBlorb(b)
+
+	case ink.IsStory(b):
+		return inspectInk(b)
+	}
+
+	return inspectZCode(b, ZCodeFormat)
+}
+
+func inspectBlorb(b []byte) (*StoryInfo, error) {
+	if !blorb.IsBlorb(b) {
+		return nil, invalid("it’s an IFF file of type %q, not a Blorb game", string(b[8:12]))
+	}
+
+	f, err := blorb.Parse(b)
+	if err != nil {
+		return nil, invalid("it’s a damaged Blorb file (%s)", err.Error())
+	}
+
+	exec := f.Executable()
+	if exec != nil && exec.Type() == "GLUL" {
+		return nil, invalid("it’s a Blorb containing a Glulx game, which my interpreter can’t run (only Z-code, Ink, and Twine are supported)")
+	}
+
+	if exec == nil || exec.Type() != "ZCOD" {
+		return nil, invalid("it’s a Blorb file without a Z-code game inside (perhaps a resource-only Blorb?)")
+	}
+
+	info, err := inspectZCode(exec.Data(), BlorbFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Metadata != nil {
+		info.Metadata = &f.Metadata.Bibliographic
+		info.AltIFIDs = f.Metadata.Identification.IFIDs
+	}
+	info.HasCover = f.Cover() != nil
+
+	return info, nil
+}
+
+func inspectInk(b []byte) (*StoryInfo, error) {
+	story, err := ink.Parse(b)
+	if err != nil {
+		return nil, invalid("%s", err.Error())
+	}
+
+	info := &StoryInfo{
+		Format:  InkFormat,
+		Version: story.InkVersion,
+		Length:  int64(len(b)),
+
+		InspectorVersion: inspectorVersion,
+	}
+
+	// Ink stories conventionally describe themselves with global tags.
+	title := story.GlobalTag("title")
+	author := story.GlobalTag("author")
+	if title != "" || author != "" {
+		info.Metadata = &ifiction.Bibliographic{
+			Title:  title,
+			Author: author,
+		}
+	}
+
+	return info, nil
+}
+
+func inspectZCode(story []byte, format string) (*StoryInfo, error) {
+	if len(story) < zmachine.HeaderSize {
+		return nil, invalid("at only %d bytes, it’s too short to be a Z-code game", len(story))
+	}
+
+	h, err := zmachine.ParseHeader(story)
+	if err != nil {
+		return nil, invalid("it isn’t a Z-code game (%s)", err.Error())
+	}
+
+	err = h.Validate()
+	if err != nil {
+		return nil, invalid("the Z-code header doesn’t make sense: %s", err.Error())
+	}
+
+	info := &StoryInfo{
+		Format:   format,
+		Version:  h.Version,
+		Release:  h.Release,
+		Serial:   h.Serial,
+		Checksum: h.Checksum,
+		Length:   h.ActualLength,
+
+		InspectorVersion: inspectorVersion,
+	}
+
+	if !isSerial(h.Serial) {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("unusual serial number %q", h.Serial))
+	}
+
+	if !h.ChecksumValid() {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("checksum mismatch (header says 0x%04X, data is 0x%04X)", h.Checksum, h.ActualChecksum))
+	}
+
+	return info, nil
+}
+
+func looksLikeHTML(b []byte) bool {
+	n := len(b)
+	if n > 512 {
+		n = 512
+	}
+	start := strings.ToLower(strings.TrimSpace(string(b[:n])))
+	return strings.HasPrefix(start, "<!doctype") ||
+		strings.HasPrefix(start, "<html") ||
+		strings.HasPrefix(start, "<?xml") ||
+		strings.HasPrefix(start, "<head")
+}
+
+// Serial numbers are almost always a YYMMDD date.
+func isSerial(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) == 6
+}
+
+func inspectTwine(b []byte) (*StoryInfo, error) {
+	story, err := twine.Parse(b)
+	if err != nil {
+		return nil, invalid("it’s a damaged Twine story (%s)", err.Error())
+	}
+
+	info := &StoryInfo{
+		Format:      TwineFormat,
+		StoryFormat: strings.TrimSpace(fmt.Sprintf("%s %s", story.Format, story.FormatVersion)),
+		Length:      int64(len(b)),
+
+		InspectorVersion: inspectorVersion,
+	}
+
+	if story.Title != "" || story.Author != "" {
+		info.Metadata = &ifiction.Bibliographic{
+			Title:  story.Title,
+			Author: story.Author,
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,45 @@
+package games
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestInspectDamagedHeader(t *testing.T) {
+	b, err := ioutil.ReadFile("../sample-games/curses.z5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(b []byte) []byte
+	}{
+		{"short file length", func(b []byte) []byte {
+			b[0x1A], b[0x1B] = 0x00, 0x01
+			return b
+		}},
+		{"long file length", func(b []byte) []byte {
+			b[0x1A], b[0x1B] = 0xFF, 0xFF
+			return b
+		}},
+		{"bad version", func(b []byte) []byte {
+			b[0] = 9
+			return b
+		}},
+		{"truncated header", func(b []byte) []byte {
+			return b[:0x20]
+		}},
+		{"truncated story", func(b []byte) []byte {
+			return b[:len(b)/2]
+		}},
+	}
+
+	for _, test := range tests {
+		damaged := test.mutate(append([]byte(nil), b...))
+		_, err := Inspect(damaged)
+		if _, ok := err.(*InvalidStoryError); !ok {
+			t.Errorf("%s: expected an InvalidStoryError, got %v", test.name, err)
+		}
+	}
+}
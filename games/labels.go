@@ -0,0 +1,193 @@
+package games
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Admins can label games with a content rating and with categories of their
+// own (beyond the genres in the game's metadata), so that channels can decide
+// which games they allow.  Like aliases, labels are kept in the library
+// directory, by game file name.
+const labelsFile = "labels.json"
+
+// Ratings are the content ratings, from least to most restricted.  Games that
+// haven't been rated are treated as suitable for everyone.
+var Ratings = []string{"everyone", "teen", "mature", "adult"}
+
+// RatingLevel returns a rating's place in Ratings (0 for no rating), or -1 if
+// it isn't a rating at all.
+func RatingLevel(rating string) int {
+	if rating == "" {
+		return 0
+	}
+	for i, r := range Ratings {
+		if strings.EqualFold(r, rating) {
+			return i
+		}
+	}
+	return -1
+}
+
+type labels struct {
+	Rating     string   `json:",omitempty"`
+	Categories []string `json:",omitempty"`
+}
+
+func (fs *FileSys) labelsFile() string {
+	return path.Join(fs.Directory, libraryDir, labelsFile)
+}
+
+func (fs *FileSys) readLabels() (map[string]*labels, error) {
+	all := make(map[string]*labels)
+
+	b, err := ioutil.ReadFile(fs.labelsFile())
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (fs *FileSys) writeLabels(all map[string]*labels) error {
+	err := os.MkdirAll(path.Dir(fs.labelsFile()), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(fs.labelsFile(), b)
+	return err
+}
+
+// applyLabels copies the labels onto the games.  The caller must hold the
+// mutex.
+func (fs *FileSys) applyLabels(games []*GameInfo) {
+	all, err := fs.readLabels()
+	if err != nil {
+		fs.Logger.WithError(err).Warn("ignoring unreadable game labels")
+		return
+	}
+
+	for _, g := range games {
+		g.Rating = ""
+		g.Categories = nil
+		if l, ok := all[g.FileName]; ok {
+			g.Rating = l.Rating
+			g.Categories = l.Categories
+		}
+	}
+}
+
+// updateLabels applies fn to a game's labels and saves them.
+func (fs *FileSys) updateLabels(name string, fn func(l *labels)) (*GameInfo, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGame(name)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := fs.readLabels()
+	if err != nil {
+		return nil, err
+	}
+
+	l, ok := all[game.FileName]
+	if !ok {
+		l = &labels{}
+	}
+	fn(l)
+
+	if l.Rating == "" && len(l.Categories) == 0 {
+		delete(all, game.FileName)
+	} else {
+		all[game.FileName] = l
+	}
+
+	err = fs.writeLabels(all)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rebuild the index now, so that the saved copy has the new labels.
+	fs.invalidateIndex()
+	_, err = fs.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.findGame(game.FileName)
+}
+
+// SetRating sets a game's content rating (one of Ratings, or "" for none).
+func (fs *FileSys) SetRating(name string, rating string) (*GameInfo, error) {
+	level := RatingLevel(rating)
+	if level < 0 {
+		return nil, fmt.Errorf("“%s” isn’t a rating; the ratings are %s", rating, strings.Join(Ratings, ", "))
+	}
+	if rating != "" {
+		rating = Ratings[level]
+	}
+
+	return fs.updateLabels(name, func(l *labels) {
+		l.Rating = rating
+	})
+}
+
+// SetCategories sets a game's categories (replacing any it had).  Categories
+// are lower-case, and can't contain spaces.
+func (fs *FileSys) SetCategories(name string, categories []string) (*GameInfo, error) {
+	cleaned := make([]string, 0, len(categories))
+	seen := make(map[string]bool)
+	for _, c := range categories {
+		c = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c), "#"))
+		if c == "" || seen[c] {
+			continue
+		}
+		if strings.ContainsAny(c, " \t") {
+			return nil, fmt.Errorf("“%s” can’t be a category, because it contains a space", c)
+		}
+		seen[c] = true
+		cleaned = append(cleaned, c)
+	}
+	sort.Strings(cleaned)
+
+	return fs.updateLabels(name, func(l *labels) {
+		l.Categories = cleaned
+	})
+}
+
+// removeLabelsFor removes a game file's labels.  The caller must hold the
+// mutex.
+func (fs *FileSys) removeLabelsFor(fileName string) error {
+	all, err := fs.readLabels()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := all[fileName]; !ok {
+		return nil
+	}
+
+	delete(all, fileName)
+	return fs.writeLabels(all)
+}
@@ -0,0 +1,294 @@
+package games
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Players rarely type a game's name exactly as its file is named, so lookups
+// are forgiving: names and titles match regardless of case, spacing, and
+// punctuation ("lost pig" finds LostPig), and when nothing matches, the
+// closest names are offered as suggestions.
+
+// maxSuggestions is the most "did you mean" suggestions we'll make.
+const maxSuggestions = 3
+
+// NotFoundError is returned when no game matches a name.  Suggestions are the
+// names of the games that come closest, if any.
+type NotFoundError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Game “%s” not found", e.Name)
+}
+
+// looseName reduces a name to its lower-case letters and digits.
+func looseName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// looseNames are the names a game can be loosely referred to by.
+func looseNames(g *GameInfo) []string {
+	names := []string{looseName(g.Name)}
+	if title := looseName(g.Title()); title != "" {
+		names = append(names, title)
+	}
+	return names
+}
+
+// looseMatch returns the one game whose name or title loosely matches, or nil
+// if there isn't exactly one.
+func looseMatch(games []*GameInfo, name string) *GameInfo {
+	key := looseName(name)
+	if key == "" {
+		return nil
+	}
+
+	var found *GameInfo
+	for _, g := range games {
+		for _, n := range looseNames(g) {
+			if n == key {
+				if found != nil && found != g {
+					return nil
+				}
+				found = g
+			}
+		}
+	}
+	return found
+}
+
+// suggest returns the names of the games whose names or titles are closest to
+// name, closest first.
+func suggest(games []*GameInfo, name string) []string {
+	key := looseName(name)
+	if key == "" {
+		return nil
+	}
+
+	// Allow roughly one typo for every three letters.
+	limit := len([]rune(key)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+
+	type candidate struct {
+		game     *GameInfo
+		distance int
+	}
+	candidates := make([]*candidate, 0)
+
+	for _, g := range games {
+		best := -1
+		for _, n := range looseNames(g) {
+			d := editDistance(key, n)
+			if len(key) >= 3 && strings.Contains(n, key) {
+				d = 1
+			}
+			if best < 0 || d < best {
+				best = d
+			}
+		}
+		if best >= 0 && best <= limit {
+			candidates = append(candidates, &candidate{g, best})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	names := make([]string, 0, maxSuggestions)
+	for _, c := range candidates {
+		if len(names) == maxSuggestions {
+			break
+		}
+		names = append(names, c.game.Name)
+	}
+	return names
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Search returns the games whose name, title, author, tags, headline, or
+// description contain every word of the query (ignoring case).  Games matching in
+// their name or title come first.
+func Search(games []*GameInfo, query string) []*GameInfo {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil
+	}
+
+	type result struct {
+		game  *GameInfo
+		score int
+	}
+	results := make([]*result, 0)
+
+	for _, g := range games {
+		names := strings.ToLower(g.Name + " " + g.Title())
+		meta := g.Bibliographic()
+		other := strings.ToLower(strings.Join([]string{meta.Author, strings.Join(g.Tags(), " "), meta.Headline, meta.Description}, " "))
+
+		score := 0
+		for _, term := range terms {
+			switch {
+			case strings.Contains(names, term):
+				score += 2
+			case strings.Contains(other, term):
+				score++
+			default:
+				score = -1
+			}
+			if score < 0 {
+				break
+			}
+		}
+
+		if score > 0 {
+			results = append(results, &result{g, score})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score > results[j].score
+	})
+
+	found := make([]*GameInfo, len(results))
+	for i, r := range results {
+		found[i] = r.game
+	}
+	return found
+}
+
+// Tags returns the game's tags (lower-case): its categories, and the genres in
+// its metadata (or catalog entry).
+func (g *GameInfo) Tags() []string {
+	tags := make([]string, 0, len(g.Categories))
+	tags = append(tags, g.Categories...)
+
+	for _, t := range strings.FieldsFunc(g.Bibliographic().Genre, func(r rune) bool {
+		return r == '/' || r == ',' || r == ';'
+	}) {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the game has the given tag (ignoring case).
+func (g *GameInfo) HasTag(tag string) bool {
+	for _, t := range g.Tags() {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasFormat reports whether the game is in the given format, which may be a
+// format name ("zcode", "blorb") or a file extension ("z5", "zblorb").
+func (g *GameInfo) HasFormat(format string) bool {
+	format = strings.TrimPrefix(format, ".")
+	return strings.EqualFold(g.Format, format) ||
+		strings.EqualFold(strings.TrimPrefix(path.Ext(g.FileName), "."), format)
+}
+
+// SortOrders are the ways SortGames can sort games.
+var SortOrders = []string{"name", "title", "author", "newest", "rating"}
+
+// SortGames sorts games by "name", "title", "author", "newest" (most recently
+// added first), or "rating" (best catalog rating first).  Ties are broken by
+// name.
+func SortGames(games []*GameInfo, by string) error {
+	var key func(g *GameInfo) string
+	switch by {
+	case "", "name":
+		sort.Sort(GameInfos(games))
+		return nil
+	case "title":
+		key = func(g *GameInfo) string { return strings.ToLower(g.DisplayName) }
+	case "author":
+		key = func(g *GameInfo) string { return strings.ToLower(g.Author()) }
+	case "newest":
+		sort.Sort(GameInfos(games))
+		sort.SliceStable(games, func(i, j int) bool {
+			return games[i].AddedAt.After(games[j].AddedAt)
+		})
+		return nil
+	case "rating":
+		sort.Sort(GameInfos(games))
+		sort.SliceStable(games, func(i, j int) bool {
+			return catalogRating(games[i]) > catalogRating(games[j])
+		})
+		return nil
+	default:
+		return fmt.Errorf("I don’t know how to sort by “%s”", by)
+	}
+
+	sort.Sort(GameInfos(games))
+	sort.SliceStable(games, func(i, j int) bool {
+		ki, kj := key(games[i]), key(games[j])
+		// Games without the key go last.
+		if (ki == "") != (kj == "") {
+			return kj == ""
+		}
+		return ki < kj
+	})
+	return nil
+}
+
+// catalogRating is the game's average rating in the catalog, or -1 if it
+// doesn't have one.
+func catalogRating(g *GameInfo) float64 {
+	if g.Catalog == nil || g.Catalog.RatingCount == 0 {
+		return -1
+	}
+	return g.Catalog.AverageRating
+}
@@ -0,0 +1,88 @@
+package games
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on what will be stored in the repository.
+const (
+	MaxGameSize     = 32 * 1024 * 1024 // a single story file (Blorbs with pictures can be large)
+	MaxUploadSize   = 64 * 1024 * 1024 // an upload, including archives
+	maxFileNameSize = 100
+)
+
+// InvalidNameError is returned when a file name is unacceptable (it would
+// refer to something outside the game directory, for instance).
+type InvalidNameError struct {
+	Name    string
+	Problem string
+}
+
+func (e *InvalidNameError) Error() string {
+	return fmt.Sprintf("invalid file name %q: %s", e.Name, e.Problem)
+}
+
+// Reason says what's wrong with the name.
+func (e *InvalidNameError) Reason() string {
+	return e.Problem
+}
+
+// TooLargeError is returned when a file is bigger than we're willing to store.
+type TooLargeError struct {
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("file is larger than %d MB", e.Limit/(1024*1024))
+}
+
+// Reason says how large a file can be.
+func (e *TooLargeError) Reason() string {
+	return fmt.Sprintf("it’s larger than %d MB", e.Limit/(1024*1024))
+}
+
+// RejectedError is implemented by the errors that turn away a file for a
+// reason the user can do something about (as opposed to, say, a full disk).
+// Reason explains why, in a way that reads well after “because”.
+type RejectedError interface {
+	error
+	Reason() string
+}
+
+// CleanFileName checks that a name is a plain file name (no directories, no
+// hidden files, no ".."), and replaces any characters other than letters,
+// digits, '.', '-', and '_' with '_'.  The cleaned name is safe to join to
+// the game directory.
+func CleanFileName(name string) (string, error) {
+	switch {
+	case name == "":
+		return "", &InvalidNameError{name, "it’s empty"}
+	case !utf8.ValidString(name):
+		return "", &InvalidNameError{name, "it isn’t valid UTF-8"}
+	case strings.ContainsAny(name, "/\\\x00"):
+		return "", &InvalidNameError{name, "it contains a directory separator"}
+	case strings.HasPrefix(name, "."):
+		return "", &InvalidNameError{name, "it starts with a ‘.’"}
+	}
+
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+
+	if len(cleaned) > maxFileNameSize {
+		return "", &InvalidNameError{name, fmt.Sprintf("it’s longer than %d characters", maxFileNameSize)}
+	}
+	if strings.Trim(baseName(cleaned), "._-") == "" {
+		return "", &InvalidNameError{name, "it doesn’t have a usable name"}
+	}
+
+	return cleaned, nil
+}
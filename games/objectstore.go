@@ -0,0 +1,717 @@
+package games
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/objstore"
+)
+
+// ObjectStore is a Repository kept in an S3-compatible bucket, so that several
+// instances can share the same games without a shared volume.  Interpreters
+// need real files, so the bucket is mirrored into a local cache directory,
+// which is managed by a FileSys.  Lookups use the cache, which is refreshed
+// from the bucket in the background (at most every RefreshInterval), so that
+// they never wait on the network.  Changes are made after refreshing the
+// cache, and are pushed to the bucket as soon as they're made.
+type ObjectStore struct {
+	Client          *objstore.Client
+	Prefix          string // key prefix for everything in the bucket, like "games/"
+	CacheDirectory  string
+	RefreshInterval time.Duration
+	Logger          log.FieldLogger
+
+	mutex  sync.Mutex // held while syncing with the bucket
+	fsOnce sync.Once
+	fs     *FileSys
+	state  *syncState
+
+	refreshMutex sync.Mutex // guards lastSync and refreshing
+	lastSync     time.Time
+	refreshing   bool
+}
+
+// syncStateFile records what was in the bucket as of the last sync, so that
+// we can tell local changes from remote ones.  It (and the index, which is
+// rebuilt from the files) stays local.
+const syncStateFile = "objstore.json"
+
+type syncState struct {
+	Objects map[string]*syncedObject // by key
+}
+
+type syncedObject struct {
+	ETag    string // of the object in the bucket
+	MD5     string // of the local file
+	Size    int64
+	ModTime time.Time
+}
+
+func (s *ObjectStore) logger() log.FieldLogger {
+	return s.Logger.WithField("component", "objstore")
+}
+
+func (s *ObjectStore) files() *FileSys {
+	s.fsOnce.Do(func() {
+		s.fs = &FileSys{
+			Directory: s.CacheDirectory,
+			Logger:    s.Logger,
+		}
+	})
+	return s.fs
+}
+
+// Sync brings the cache and the bucket up to date with each other: changes
+// in the bucket are downloaded, and local changes (including games that were
+// in the cache directory before the bucket was used) are uploaded.
+func (s *ObjectStore) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sync()
+}
+
+func (s *ObjectStore) sync() error {
+	err := s.pull()
+	if err != nil {
+		return err
+	}
+	return s.push()
+}
+
+// stale reports whether it's been a while since we pulled from the bucket.
+func (s *ObjectStore) stale() bool {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	return time.Since(s.lastSync) >= s.RefreshInterval
+}
+
+// refresh pulls from the bucket if it's been a while.  The caller must hold
+// the mutex.
+func (s *ObjectStore) refresh() {
+	if !s.stale() {
+		return
+	}
+
+	err := s.pull()
+	if err != nil {
+		// Carry on with what we have cached.
+		s.logger().WithError(err).Error("refreshing game cache")
+	}
+}
+
+// refreshLater starts refreshing the cache in the background, if it's been a
+// while, so that lookups can carry on with what's cached in the meantime.
+func (s *ObjectStore) refreshLater() {
+	s.refreshMutex.Lock()
+	defer s.refreshMutex.Unlock()
+
+	if s.refreshing || time.Since(s.lastSync) < s.RefreshInterval {
+		return
+	}
+	s.refreshing = true
+
+	go func() {
+		s.mutex.Lock()
+		s.refresh()
+		s.mutex.Unlock()
+
+		s.refreshMutex.Lock()
+		s.refreshing = false
+		s.refreshMutex.Unlock()
+	}()
+}
+
+// pushLater pushes any changes to the bucket in the background.
+func (s *ObjectStore) pushLater() {
+	go func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		err := s.push()
+		if err != nil {
+			s.logger().WithError(err).Error("pushing to bucket")
+		}
+	}()
+}
+
+// modify runs a change against the cache, after making sure it's current,
+// and then pushes the results to the bucket.
+func (s *ObjectStore) modify(fn func(fs *FileSys) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.pull()
+	if err != nil {
+		return err
+	}
+
+	err = fn(s.files())
+	if err != nil {
+		return err
+	}
+
+	return s.push()
+}
+
+// lookup runs something against the cache that might record new files (like
+// a game's first version), without waiting on the bucket.
+func (s *ObjectStore) lookup(fn func(fs *FileSys) error) error {
+	s.refreshLater()
+
+	err := fn(s.files())
+	if err != nil {
+		return err
+	}
+
+	s.pushLater()
+	return nil
+}
+
+// use is like lookup, but waits for the bucket (without insisting that it be
+// reachable).
+func (s *ObjectStore) use(fn func(fs *FileSys) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.refresh()
+
+	err := fn(s.files())
+	if err != nil {
+		return err
+	}
+
+	err = s.push()
+	if err != nil {
+		s.logger().WithError(err).Error("pushing to bucket")
+	}
+	return nil
+}
+
+func (s *ObjectStore) stateFile() string {
+	return path.Join(s.CacheDirectory, libraryDir, syncStateFile)
+}
+
+func (s *ObjectStore) loadState() *syncState {
+	if s.state != nil {
+		return s.state
+	}
+
+	s.state = &syncState{Objects: make(map[string]*syncedObject)}
+	b, err := ioutil.ReadFile(s.stateFile())
+	if err == nil {
+		err = json.Unmarshal(b, s.state)
+		if err != nil {
+			s.logger().WithError(err).Warn("ignoring unreadable sync state")
+			s.state = &syncState{Objects: make(map[string]*syncedObject)}
+		}
+	}
+	return s.state
+}
+
+func (s *ObjectStore) saveState() error {
+	err := os.MkdirAll(path.Dir(s.stateFile()), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(s.stateFile(), b)
+	return err
+}
+
+func (s *ObjectStore) key(rel string) string {
+	return s.Prefix + rel
+}
+
+// isSynced reports whether a file (by its slash-separated path relative to
+// the cache directory) belongs in the bucket.
+func isSynced(rel string) bool {
+	name := path.Base(rel)
+	switch {
+	case rel == path.Join(libraryDir, indexFile), rel == path.Join(libraryDir, syncStateFile):
+		return false
+	case strings.HasPrefix(name, ".upload-"), strings.HasSuffix(name, ".tmp"):
+		return false
+	}
+	return true
+}
+
+// pull downloads anything that has changed in the bucket, and removes cached
+// files that have been deleted from it.  The caller must hold the mutex.
+func (s *ObjectStore) pull() error {
+	objects, err := s.Client.ListObjects(s.Prefix)
+	if err != nil {
+		return err
+	}
+
+	state := s.loadState()
+	remote := make(map[string]bool, len(objects))
+	changed := false
+
+	for _, o := range objects {
+		rel := strings.TrimPrefix(o.Key, s.Prefix)
+		if !isSafeKey(rel) || !isSynced(rel) {
+			continue
+		}
+		remote[o.Key] = true
+
+		file := filepath.Join(s.CacheDirectory, filepath.FromSlash(rel))
+		known := state.Objects[o.Key]
+		if known != nil && known.ETag == o.ETag {
+			if _, err := os.Stat(file); err == nil {
+				continue
+			}
+		}
+
+		err = s.download(o.Key, file)
+		if err != nil {
+			s.logger().WithField("key", o.Key).WithError(err).Error("downloading object")
+			continue
+		}
+		changed = true
+	}
+
+	// Anything we've seen in the bucket before that's gone now was deleted
+	// (by another instance, say).
+	for key := range state.Objects {
+		if remote[key] {
+			continue
+		}
+		rel := strings.TrimPrefix(key, s.Prefix)
+		file := filepath.Join(s.CacheDirectory, filepath.FromSlash(rel))
+		s.logger().WithField("key", key).Info("removing cached file deleted from bucket")
+		err = os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			s.logger().WithField("file", file).WithError(err).Warn("removing cached file")
+		}
+		delete(state.Objects, key)
+		changed = true
+	}
+
+	if changed {
+		s.files().mutex.Lock()
+		s.files().invalidateIndex()
+		s.files().mutex.Unlock()
+
+		err = s.saveState()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.refreshMutex.Lock()
+	s.lastSync = time.Now()
+	s.refreshMutex.Unlock()
+	return nil
+}
+
+func (s *ObjectStore) download(key string, file string) error {
+	b, info, err := s.Client.GetObject(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	stat, err := writeFileAtomic(file, b)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(b)
+	s.state.Objects[key] = &syncedObject{
+		ETag:    info.ETag,
+		MD5:     hex.EncodeToString(sum[:]),
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+
+	s.logger().WithField("key", key).Debug("downloaded object")
+	return nil
+}
+
+// push uploads new and changed files, and deletes objects for files that
+// have been removed.  The caller must hold the mutex.
+func (s *ObjectStore) push() error {
+	err := os.MkdirAll(s.CacheDirectory, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	state := s.loadState()
+	local := make(map[string]bool)
+	changed := false
+
+	err = filepath.Walk(s.CacheDirectory, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.CacheDirectory, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !isSynced(rel) {
+			return nil
+		}
+
+		key := s.key(rel)
+		local[key] = true
+
+		known := state.Objects[key]
+		if known != nil && known.Size == info.Size() && known.ModTime.Equal(info.ModTime()) {
+			return nil
+		}
+
+		sum, err := md5File(file)
+		if err != nil {
+			return err
+		}
+		if known != nil && known.MD5 == sum {
+			known.Size = info.Size()
+			known.ModTime = info.ModTime()
+			changed = true
+			return nil
+		}
+
+		err = s.upload(key, rel, file, info, sum)
+		if err != nil {
+			return err
+		}
+		changed = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for key := range state.Objects {
+		if local[key] {
+			continue
+		}
+		s.logger().WithField("key", key).Info("deleting object")
+		err = s.Client.DeleteObject(key)
+		if err != nil {
+			return err
+		}
+		delete(state.Objects, key)
+		changed = true
+	}
+
+	if changed {
+		return s.saveState()
+	}
+	return nil
+}
+
+func (s *ObjectStore) upload(key string, rel string, file string, info os.FileInfo, sum string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	etag, err := s.Client.PutObject(key, b, contentType(rel), s.metadata(rel, b))
+	if err != nil {
+		return err
+	}
+
+	// For a simple (single-part) upload, the ETag is usually the content's
+	// MD5, but not every server promises that.
+	if etag == "" {
+		etag = sum
+	}
+	s.state.Objects[key] = &syncedObject{
+		ETag:    etag,
+		MD5:     sum,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+
+	s.logger().WithField("key", key).Debug("uploaded object")
+	return nil
+}
+
+// metadata describes game files (the top-level files) for anyone browsing
+// the bucket.
+func (s *ObjectStore) metadata(rel string, b []byte) map[string]string {
+	metadata := map[string]string{"sha256": checksum(b)}
+	if strings.Contains(rel, "/") {
+		return metadata
+	}
+
+	s.files().mutex.Lock()
+	game, err := s.files().findGame(rel)
+	s.files().mutex.Unlock()
+	if err != nil || game.FileName != rel {
+		return metadata
+	}
+
+	if game.ID != "" {
+		metadata["ifid"] = game.ID
+	}
+	if game.Format != "" {
+		metadata["format"] = game.Format
+	}
+	if game.AddedBy != "" {
+		metadata["added-by"] = game.AddedBy
+	}
+	// Header values need to be plain ASCII.
+	if title := asciiOnly(game.Title()); title != "" {
+		metadata["title"] = title
+	}
+	return metadata
+}
+
+func contentType(rel string) string {
+	ext := strings.ToLower(path.Ext(rel))
+	switch {
+	case ext == ".zblorb" || ext == ".zlb" || ext == ".blorb" || ext == ".blb":
+		return "application/x-blorb"
+	case len(ext) == 3 && ext[1] == 'z' && ext[2] >= '1' && ext[2] <= '8':
+		return "application/x-zmachine"
+	case ext == ".json":
+		return "application/json"
+	}
+
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func asciiOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isSafeKey makes sure a key from the bucket can't escape the cache
+// directory.
+func isSafeKey(rel string) bool {
+	if rel == "" || path.IsAbs(rel) || strings.Contains(rel, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func md5File(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// The Repository methods.  Lookups use the cache (refreshing it in the
+// background if it's been a while); changes are made to the cache and then
+// pushed.
+
+// GetGames returns the available games, sorted by name.
+func (s *ObjectStore) GetGames() ([]*GameInfo, error) {
+	s.refreshLater()
+	return s.files().GetGames()
+}
+
+// GetGame looks up a game by name, by full file name, or by IFID.
+func (s *ObjectStore) GetGame(name string) (*GameInfo, error) {
+	s.refreshLater()
+	return s.files().GetGame(name)
+}
+
+// GetGameFile returns the path to the cached copy of the game.
+func (s *ObjectStore) GetGameFile(name string) (string, error) {
+	s.refreshLater()
+	return s.files().GetGameFile(name)
+}
+
+// FindGamesByIFID returns all the games with the given IFID.
+func (s *ObjectStore) FindGamesByIFID(ifid string) ([]*GameInfo, error) {
+	s.refreshLater()
+	return s.files().FindGamesByIFID(ifid)
+}
+
+// AddGameFile adds a new game to the repository.
+func (s *ObjectStore) AddGameFile(fileName string, r io.Reader, addedBy string) (*GameInfo, error) {
+	var game *GameInfo
+	err := s.modify(func(fs *FileSys) (err error) {
+		game, err = fs.AddGameFile(fileName, r, addedBy)
+		return err
+	})
+	return game, err
+}
+
+// AddArchive adds the story files in an archive as games.
+func (s *ObjectStore) AddArchive(fileName string, r io.Reader, addedBy string) (*UploadResult, error) {
+	var result *UploadResult
+	err := s.modify(func(fs *FileSys) (err error) {
+		result, err = fs.AddArchive(fileName, r, addedBy)
+		return err
+	})
+	return result, err
+}
+
+// GetCompanionFile returns the path to the cached copy of a companion file.
+func (s *ObjectStore) GetCompanionFile(game string, name string) (string, error) {
+	s.refreshLater()
+	return s.files().GetCompanionFile(game, name)
+}
+
+// AddCompanions attaches a file (or every file in an archive) to a game.
+func (s *ObjectStore) AddCompanions(name string, fileName string, r io.Reader) (*GameInfo, []string, error) {
+	var game *GameInfo
+	var added []string
+	err := s.modify(func(fs *FileSys) (err error) {
+		game, added, err = fs.AddCompanions(name, fileName, r)
+		return err
+	})
+	return game, added, err
+}
+
+// RemoveCompanion removes one of a game's companion files.
+func (s *ObjectStore) RemoveCompanion(name string, file string) error {
+	return s.modify(func(fs *FileSys) error {
+		return fs.RemoveCompanion(name, file)
+	})
+}
+
+// GetVersions returns all the versions of a game.
+func (s *ObjectStore) GetVersions(name string) ([]*GameVersion, error) {
+	var versions []*GameVersion
+	err := s.lookup(func(fs *FileSys) (err error) {
+		// (This may record the current version for the first time.)
+		versions, err = fs.GetVersions(name)
+		return err
+	})
+	return versions, err
+}
+
+// GetGameVersionFile returns the path to the cached copy of a version of a
+// game.
+func (s *ObjectStore) GetGameVersionFile(name string, version string) (string, *GameVersion, error) {
+	var file string
+	var v *GameVersion
+	err := s.lookup(func(fs *FileSys) (err error) {
+		file, v, err = fs.GetGameVersionFile(name, version)
+		return err
+	})
+	return file, v, err
+}
+
+// RollbackGame makes an earlier version of a game the current one.
+func (s *ObjectStore) RollbackGame(name string, version string) (*GameVersion, error) {
+	var v *GameVersion
+	err := s.modify(func(fs *FileSys) (err error) {
+		v, err = fs.RollbackGame(name, version)
+		return err
+	})
+	return v, err
+}
+
+// GetAliases returns the games' aliases.
+func (s *ObjectStore) GetAliases() (map[string]string, error) {
+	s.refreshLater()
+	return s.files().GetAliases()
+}
+
+// SetAlias makes alias another name for a game.
+func (s *ObjectStore) SetAlias(alias string, name string) (*GameInfo, error) {
+	var game *GameInfo
+	err := s.modify(func(fs *FileSys) (err error) {
+		game, err = fs.SetAlias(alias, name)
+		return err
+	})
+	return game, err
+}
+
+// RemoveAlias removes an alias.
+func (s *ObjectStore) RemoveAlias(alias string) error {
+	return s.modify(func(fs *FileSys) error {
+		return fs.RemoveAlias(alias)
+	})
+}
+
+// SetRating sets a game's content rating.
+func (s *ObjectStore) SetRating(name string, rating string) (*GameInfo, error) {
+	var game *GameInfo
+	err := s.modify(func(fs *FileSys) (err error) {
+		game, err = fs.SetRating(name, rating)
+		return err
+	})
+	return game, err
+}
+
+// SetCategories sets a game's categories.
+func (s *ObjectStore) SetCategories(name string, categories []string) (*GameInfo, error) {
+	var game *GameInfo
+	err := s.modify(func(fs *FileSys) (err error) {
+		game, err = fs.SetCategories(name, categories)
+		return err
+	})
+	return game, err
+}
+
+// ImportCatalog adds the entries in an iFiction catalog to the library's
+// catalog.
+func (s *ObjectStore) ImportCatalog(r io.Reader) (*CatalogImport, error) {
+	var result *CatalogImport
+	err := s.modify(func(fs *FileSys) (err error) {
+		result, err = fs.ImportCatalog(r)
+		return err
+	})
+	return result, err
+}
+
+// DeleteGameFile removes a game from the repository.
+func (s *ObjectStore) DeleteGameFile(name string) error {
+	return s.modify(func(fs *FileSys) error {
+		return fs.DeleteGameFile(name)
+	})
+}
+
+// Rescan refreshes the cache from the bucket (if it's been a while), picks up
+// any files changed in the cache directory itself, and pushes those.
+func (s *ObjectStore) Rescan() error {
+	return s.use(func(fs *FileSys) error {
+		return fs.Rescan()
+	})
+}
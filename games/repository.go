@@ -7,17 +7,77 @@ import (
 // Repository abstracts the available games (TODO: move this to a new
 // package?)  Future work: allow adding/downloading of new game files.
 type Repository interface {
-	// GetGames returns the list of available games.  This may (in the future)
-	// include metadata about the games, a game image/icon, etc.
-	GetGames() ([]string, error)
+	// GetGames returns the available games, sorted by name.
+	GetGames() ([]*GameInfo, error)
+
+	// GetGame returns a single game, given by name, file name, IFID, or alias.
+	// Names and titles are matched loosely; if nothing matches, the error is
+	// a *NotFoundError with suggestions.
+	GetGame(game string) (*GameInfo, error)
 
 	// GetGameFile returns the path to the game (in a form that can be passed to
-	// things like game interpreters).
+	// things like game interpreters).  The game can be given by name or IFID.
 	GetGameFile(game string) (string, error)
 
-	// AddGameFile adds a new game to the repository
-	AddGameFile(fileName string, r io.Reader) error
+	// FindGamesByIFID returns all the games with the given IFID.
+	FindGamesByIFID(ifid string) ([]*GameInfo, error)
+
+	// AddGameFile adds a new game to the repository, noting who added it.  It
+	// returns an *InvalidStoryError if the data isn't a playable story file,
+	// or a *ConflictError if it would collide with an existing game's name.
+	AddGameFile(fileName string, r io.Reader, addedBy string) (*GameInfo, error)
+
+	// AddArchive adds the story files in a zip or tar.gz archive as games,
+	// keeping the other files in the archive with them as companions.
+	AddArchive(fileName string, r io.Reader, addedBy string) (*UploadResult, error)
+
+	// GetCompanionFile returns the path to one of a game's companion files.
+	GetCompanionFile(game string, name string) (string, error)
+
+	// AddCompanions attaches a file (or every file in an archive) to a game,
+	// returning the game and the names of the files that were attached.
+	AddCompanions(game string, fileName string, r io.Reader) (*GameInfo, []string, error)
+
+	// RemoveCompanion removes one of a game's companion files.  This, like
+	// the other changes that are hard to undo (RollbackGame, SetAlias, and
+	// DeleteGameFile), needs the game's exact name or file name; the lookups
+	// match loosely.
+	RemoveCompanion(game string, name string) error
+
+	// GetVersions returns all the versions of a game, oldest first, with the
+	// one that new games use marked as Current.
+	GetVersions(game string) ([]*GameVersion, error)
+
+	// GetGameVersionFile returns the path to an unchanging copy of a version
+	// of a game (by number or checksum; "" for the current version).
+	GetGameVersionFile(game string, version string) (string, *GameVersion, error)
+
+	// RollbackGame makes an earlier version of a game the current one.
+	RollbackGame(game string, version string) (*GameVersion, error)
+
+	// GetAliases returns the games' aliases, mapped to the games' names.
+	GetAliases() (map[string]string, error)
+
+	// SetAlias makes alias another name for a game.
+	SetAlias(alias string, game string) (*GameInfo, error)
+
+	// RemoveAlias removes an alias.
+	RemoveAlias(alias string) error
+
+	// SetRating sets a game's content rating (one of Ratings, or "" for none).
+	SetRating(game string, rating string) (*GameInfo, error)
+
+	// SetCategories sets a game's categories, replacing any it had.
+	SetCategories(game string, categories []string) (*GameInfo, error)
+
+	// ImportCatalog adds the entries in an iFiction catalog (like an IFDB
+	// export) to the library's catalog.
+	ImportCatalog(r io.Reader) (*CatalogImport, error)
+
+	// DeleteGameFile removes a game from the repository.
+	DeleteGameFile(game string) error
 
-	// DeleteGameFile removes a game from the repository
-	DeleteGameFile(fileName string) error
+	// Rescan looks for games that have been added, changed, or removed
+	// behind the repository's back, and updates the index to match.
+	Rescan() error
 }
@@ -0,0 +1,304 @@
+package games
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every version of a game that has been played or replaced is kept in the
+// library, so that replacing a game (with a new release, say) doesn't break
+// games in progress, or saves made against the old release.  Each game file
+// has a directory of numbered copies, along with a versions.json describing
+// them.  Version files are never modified once written.
+
+const (
+	versionsDir  = "versions"
+	versionsFile = "versions.json"
+)
+
+// GameVersion describes one version of a game's file.
+type GameVersion struct {
+	Number    int
+	File      string `json:"-"` // path to the (unchanging) copy of this version
+	Checksum  string // SHA-256 of the file
+	Size      int64
+	IFID      string
+	Release   int
+	Serial    string
+	ZChecksum uint16 // the checksum in the Z-machine header
+	AddedBy   string `json:",omitempty"`
+	AddedAt   time.Time
+	Current   bool `json:"-"`
+}
+
+func (v *GameVersion) String() string {
+	return fmt.Sprintf("release %d / %s", v.Release, v.Serial)
+}
+
+func (fs *FileSys) versionDir(fileName string) string {
+	return path.Join(fs.Directory, libraryDir, versionsDir, fileName)
+}
+
+func (fs *FileSys) versionFile(fileName string, number int) string {
+	return path.Join(fs.versionDir(fileName), fmt.Sprintf("%d-%s", number, fileName))
+}
+
+func (fs *FileSys) readVersions(fileName string) ([]*GameVersion, error) {
+	b, err := ioutil.ReadFile(path.Join(fs.versionDir(fileName), versionsFile))
+	if os.IsNotExist(err) {
+		return []*GameVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]*GameVersion, 0)
+	err = json.Unmarshal(b, &versions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		v.File = fs.versionFile(fileName, v.Number)
+	}
+
+	return versions, nil
+}
+
+func (fs *FileSys) writeVersions(fileName string, versions []*GameVersion) error {
+	b, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = writeFileAtomic(path.Join(fs.versionDir(fileName), versionsFile), b)
+	return err
+}
+
+// recordVersion keeps a copy of a game's file as a new version, unless that
+// exact file has already been recorded.  The caller must hold the mutex.
+func (fs *FileSys) recordVersion(game *GameInfo, b []byte) (*GameVersion, error) {
+	versions, err := fs.readVersions(game.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if v.Checksum == game.Checksum {
+			return v, nil
+		}
+	}
+
+	dir := fs.versionDir(game.FileName)
+	err = os.MkdirAll(dir, os.FileMode(0755))
+	if err != nil {
+		return nil, err
+	}
+
+	v := &GameVersion{
+		Number:   len(versions) + 1,
+		Checksum: game.Checksum,
+		Size:     int64(len(b)),
+		IFID:     game.ID,
+		AddedBy:  game.AddedBy,
+		AddedAt:  game.AddedAt,
+	}
+	if game.Story != nil {
+		v.Release = game.Story.Release
+		v.Serial = game.Story.Serial
+		v.ZChecksum = game.Story.Checksum
+	}
+	v.File = fs.versionFile(game.FileName, v.Number)
+
+	_, err = writeFileAtomic(v.File, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.writeVersions(game.FileName, append(versions, v))
+	if err != nil {
+		os.Remove(v.File)
+		return nil, err
+	}
+
+	fs.Logger.WithField("game", game.FileName).WithField("version", v.Number).Info("recorded game version")
+	return v, nil
+}
+
+// recordCurrentVersion makes sure the game's file, as it is now, has been
+// kept as a version.  The caller must hold the mutex.
+func (fs *FileSys) recordCurrentVersion(game *GameInfo) (*GameVersion, error) {
+	versions, err := fs.readVersions(game.FileName)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Checksum == game.Checksum {
+			return v, nil
+		}
+	}
+
+	b, err := ioutil.ReadFile(path.Join(fs.Directory, game.FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	// The file may have been changed behind our back since it was indexed.
+	if checksum(b) != game.Checksum {
+		fs.invalidateIndex()
+		game, err = fs.findGame(game.FileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return fs.recordVersion(game, b)
+}
+
+// GetVersions returns all the versions of a game, oldest first, with the one
+// in use for new games marked as Current.
+func (fs *FileSys) GetVersions(name string) ([]*GameVersion, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGame(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !game.Playable() {
+		return nil, fmt.Errorf("“%s” can’t be played: %s", game.Name, game.Problem)
+	}
+
+	_, err = fs.recordCurrentVersion(game)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := fs.readVersions(game.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		v.Current = v.Checksum == game.Checksum
+	}
+
+	return versions, nil
+}
+
+// GetGameVersionFile returns the path to an unchanging copy of a particular
+// version of a game, given by number or checksum (or a prefix of one).  An
+// empty version means the current one.  Interpreters should be given these
+// files rather than the game's own file, so that replacing the game doesn't
+// pull the rug out from under games in progress.
+func (fs *FileSys) GetGameVersionFile(name string, version string) (string, *GameVersion, error) {
+	versions, err := fs.GetVersions(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	v := findVersion(versions, version)
+	if v == nil {
+		return "", nil, fmt.Errorf("“%s” doesn’t have a version “%s”", name, version)
+	}
+
+	return v.File, v, nil
+}
+
+func findVersion(versions []*GameVersion, version string) *GameVersion {
+	if version == "" {
+		for _, v := range versions {
+			if v.Current {
+				return v
+			}
+		}
+		return nil
+	}
+
+	if n, err := strconv.Atoi(strings.TrimPrefix(version, "v")); err == nil && len(version) < 8 {
+		for _, v := range versions {
+			if v.Number == n {
+				return v
+			}
+		}
+		return nil
+	}
+
+	for _, v := range versions {
+		if strings.HasPrefix(v.Checksum, strings.ToLower(version)) {
+			return v
+		}
+	}
+	return nil
+}
+
+// RollbackGame makes an earlier version of a game (given by its exact name or
+// file name) the current one, which is what new games will use.
+func (fs *FileSys) RollbackGame(name string, version string) (*GameVersion, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	game, err := fs.findGameExactly(name)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := fs.readVersions(game.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	v := findVersion(versions, version)
+	if v == nil || version == "" {
+		return nil, fmt.Errorf("“%s” doesn’t have a version “%s”", game.Name, version)
+	}
+
+	b, err := ioutil.ReadFile(v.File)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure what we're about to replace isn't lost.
+	_, err = fs.recordCurrentVersion(game)
+	if err != nil {
+		return nil, err
+	}
+
+	story, err := Inspect(b)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := writeFileAtomic(path.Join(fs.Directory, game.FileName), b)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.replaceIndexEntry(&GameInfo{
+		ID:       story.IFID,
+		FileName: game.FileName,
+		Format:   story.Format,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		AddedBy:  v.AddedBy,
+		AddedAt:  v.AddedAt,
+		Checksum: v.Checksum,
+		Metadata: story.Metadata,
+		Story:    story,
+	})
+
+	fs.Logger.WithField("game", game.FileName).WithField("version", v.Number).Info("rolled back game")
+	v.Current = true
+	return v, nil
+}
+
+func (fs *FileSys) removeVersions(fileName string) error {
+	return os.RemoveAll(fs.versionDir(fileName))
+}
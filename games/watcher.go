@@ -0,0 +1,138 @@
+package games
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Games can arrive without going through the bot at all: copied into the game
+// directory by hand, or through a Docker volume, say.  There's no portable
+// file-notification package available to us, so the Watcher simply polls the
+// repository and compares what it finds with what it found last time.
+
+// Changes describes how the games in a repository changed between two looks.
+type Changes struct {
+	Added   []*GameInfo
+	Changed []*GameInfo // the new information for each changed game
+	Removed []*GameInfo // the last information we had for each removed game
+}
+
+// Empty reports whether nothing changed.
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// Watcher periodically rescans a Repository, and calls OnChange whenever games
+// have been added, changed, or removed.  Changes made through the repository
+// (uploads, for instance) are reported too, the next time it looks.
+type Watcher struct {
+	Repository Repository
+	OnChange   func(changes *Changes)
+	Logger     log.FieldLogger
+
+	mutex sync.Mutex
+	games map[string]*GameInfo // by file name
+	quit  chan bool
+}
+
+// Start takes note of the current games, and then checks for changes every
+// interval until Stop is called.
+func (w *Watcher) Start(interval time.Duration) {
+	w.quit = make(chan bool)
+	logger := w.logger()
+
+	_, err := w.Check()
+	if err != nil {
+		logger.WithError(err).Error("scanning games")
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-w.quit:
+				return
+			}
+
+			changes, err := w.Check()
+			if err != nil {
+				logger.WithError(err).Error("scanning games")
+				continue
+			}
+
+			if !changes.Empty() && w.OnChange != nil {
+				w.OnChange(changes)
+			}
+		}
+	}()
+}
+
+// Stop stops watching.
+func (w *Watcher) Stop() {
+	if w.quit != nil {
+		close(w.quit)
+	}
+}
+
+func (w *Watcher) logger() log.FieldLogger {
+	return w.Logger.WithField("component", "games.watcher")
+}
+
+// Check rescans the repository and returns what has changed since the last
+// check.  The first check only takes note of what's there, and reports no
+// changes.
+func (w *Watcher) Check() (*Changes, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.Repository.Rescan()
+	if err != nil {
+		return nil, err
+	}
+
+	games, err := w.Repository.GetGames()
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*GameInfo, len(games))
+	for _, g := range games {
+		current[g.FileName] = g
+	}
+
+	changes := &Changes{}
+	if w.games != nil {
+		for _, g := range games {
+			old, ok := w.games[g.FileName]
+			switch {
+			case !ok:
+				changes.Added = append(changes.Added, g)
+			case old.Checksum != g.Checksum:
+				changes.Changed = append(changes.Changed, g)
+			}
+		}
+
+		for fileName, old := range w.games {
+			if _, ok := current[fileName]; !ok {
+				changes.Removed = append(changes.Removed, old)
+			}
+		}
+	}
+
+	w.games = current
+
+	if !changes.Empty() {
+		w.logger().WithFields(log.Fields{
+			"added":   len(changes.Added),
+			"changed": len(changes.Changed),
+			"removed": len(changes.Removed),
+		}).Info("games changed")
+	}
+
+	return changes, nil
+}
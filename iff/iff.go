@@ -0,0 +1,108 @@
+package iff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Both Quetzal save files and Blorb resource files are IFF "FORM" containers: a
+// 4-byte "FORM" marker, a big-endian 32-bit length, a 4-byte form type, and
+// then a sequence of chunks.  Each chunk is a 4-byte ID, a 32-bit length, and
+// the data, padded to an even length.  We only need to read these, never write
+// them.
+
+// ErrNotForm is returned when the data does not start with an IFF FORM header.
+var ErrNotForm = errors.New("not an IFF FORM")
+
+// Chunk is a single chunk from an IFF FORM.
+type Chunk struct {
+	ID     string
+	Offset int64 // offset of the chunk header from the start of the FORM
+	Data   []byte
+}
+
+// Form is a parsed IFF FORM.
+type Form struct {
+	Type   string
+	Chunks []*Chunk
+}
+
+// IsForm reports whether the data looks like the start of an IFF FORM of the
+// given type.  An empty formType matches any type.
+func IsForm(b []byte, formType string) bool {
+	if len(b) < 12 || string(b[0:4]) != "FORM" {
+		return false
+	}
+	return formType == "" || string(b[8:12]) == formType
+}
+
+// ReadForm reads an entire IFF FORM.
+func ReadForm(r io.Reader) (*Form, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseForm(b)
+}
+
+// ParseForm parses an IFF FORM held in memory.
+func ParseForm(b []byte) (*Form, error) {
+	if !IsForm(b, "") {
+		return nil, ErrNotForm
+	}
+
+	length := int64(binary.BigEndian.Uint32(b[4:8]))
+	end := 8 + length
+	if end > int64(len(b)) {
+		return nil, fmt.Errorf("IFF FORM claims %d bytes but only %d are present", length, len(b)-8)
+	}
+
+	form := &Form{
+		Type: string(b[8:12]),
+	}
+
+	offset := int64(12)
+	for offset+8 <= end {
+		id := string(b[offset : offset+4])
+		size := int64(binary.BigEndian.Uint32(b[offset+4 : offset+8]))
+		start := offset + 8
+		if start+size > end {
+			return nil, fmt.Errorf("IFF chunk %q at offset %d overruns the FORM", id, offset)
+		}
+
+		form.Chunks = append(form.Chunks, &Chunk{
+			ID:     id,
+			Offset: offset,
+			Data:   b[start : start+size],
+		})
+
+		// chunks are padded to an even length
+		offset = start + size + size%2
+	}
+
+	return form, nil
+}
+
+// Chunk returns the first chunk with the given ID, or nil if there isn't one.
+func (f *Form) Chunk(id string) *Chunk {
+	for _, c := range f.Chunks {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// ChunkAt returns the chunk whose header starts at the given offset, or nil.
+// (Blorb resource indexes refer to chunks by offset.)
+func (f *Form) ChunkAt(offset int64) *Chunk {
+	for _, c := range f.Chunks {
+		if c.Offset == offset {
+			return c
+		}
+	}
+	return nil
+}
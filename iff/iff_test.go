@@ -0,0 +1,121 @@
+package iff
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// form builds an IFF FORM from chunks, given as alternating IDs and data.
+func form(formType string, chunks ...string) []byte {
+	body := []byte(formType)
+	for i := 0; i+1 < len(chunks); i += 2 {
+		body = append(body, chunks[i]...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(chunks[i+1])))
+		body = append(body, chunks[i+1]...)
+		if len(chunks[i+1])%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	b := []byte("FORM")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+func TestParseFormBlorb(t *testing.T) {
+	b, err := ioutil.ReadFile("../sample-games/LostPig.zblorb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ParseForm(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != "IFRS" {
+		t.Errorf("got form type %q", f.Type)
+	}
+
+	want := []struct {
+		id     string
+		offset int64
+		size   int
+	}{
+		{"RIdx", 12, 28},
+		{"ZCOD", 48, 285184},
+		{"IFmd", 285240, 1970},
+		{"Fspc", 287218, 4},
+		{"JPEG", 287230, 103018},
+	}
+	if len(f.Chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(f.Chunks), len(want))
+	}
+	for i, w := range want {
+		c := f.Chunks[i]
+		if c.ID != w.id || c.Offset != w.offset || len(c.Data) != w.size {
+			t.Errorf("chunk %d: got %s at %d (%d bytes), want %s at %d (%d bytes)", i, c.ID, c.Offset, len(c.Data), w.id, w.offset, w.size)
+		}
+		if f.ChunkAt(w.offset) != c {
+			t.Errorf("chunk %d: ChunkAt(%d) didn’t find it", i, w.offset)
+		}
+	}
+
+	if f.Chunk("ZCOD") != f.Chunks[1] || f.Chunk("nope") != nil {
+		t.Error("Chunk() found the wrong chunk")
+	}
+}
+
+func TestParseFormPadding(t *testing.T) {
+	f, err := ParseForm(form("TEST", "ODD ", "abc", "EVEN", "de"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(f.Chunks))
+	}
+	if string(f.Chunks[0].Data) != "abc" || string(f.Chunks[1].Data) != "de" {
+		t.Errorf("got chunks %q and %q", f.Chunks[0].Data, f.Chunks[1].Data)
+	}
+	if f.Chunks[1].Offset != 12+8+4 {
+		t.Errorf("the chunk after an odd-sized one is at %d", f.Chunks[1].Offset)
+	}
+}
+
+func TestParseFormDamaged(t *testing.T) {
+	good := form("TEST", "ONE ", "hello", "TWO ", "world!")
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"not a form", []byte("LIST\x00\x00\x00\x04TEST")},
+		{"truncated header", good[:10]},
+		{"truncated chunk", good[:len(good)-3]},
+		{"oversized chunk", func() []byte {
+			b := append([]byte(nil), good...)
+			binary.BigEndian.PutUint32(b[16:], 0xFFFFFFF0)
+			return b
+		}()},
+		{"oversized form", func() []byte {
+			b := append([]byte(nil), good...)
+			binary.BigEndian.PutUint32(b[4:], 0xFFFFFFF0)
+			return b
+		}()},
+	}
+
+	for _, test := range tests {
+		_, err := ParseForm(test.b)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+
+	// Any cut must fail cleanly (or, at a chunk boundary, just have fewer
+	// chunks).
+	for n := 0; n < len(good); n++ {
+		ParseForm(good[:n])
+	}
+}
@@ -0,0 +1,132 @@
+package ifiction
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// iFiction is the XML bibliographic format defined by the Treaty of Babel
+// (http://babel.ifarchive.org/).  It's embedded in Blorb files as the "IFmd"
+// chunk, and is also used for catalog dumps.  We only parse the parts we have
+// a use for.
+
+// Index is the root <ifindex> element.
+type Index struct {
+	Stories []*Story `xml:"story"`
+}
+
+// Story is a single <story> entry.
+type Story struct {
+	Identification Identification `xml:"identification"`
+	Bibliographic  Bibliographic  `xml:"bibliographic"`
+	Cover          *Cover         `xml:"cover"`
+	IFDB           *IFDB          `xml:"ifdb"`
+}
+
+// Identification identifies the story.
+type Identification struct {
+	IFIDs  []string `xml:"ifid"`
+	Format string   `xml:"format"`
+}
+
+// Bibliographic is the descriptive metadata for a story.
+type Bibliographic struct {
+	Title          string `xml:"title"`
+	Author         string `xml:"author"`
+	Headline       string `xml:"headline,omitempty"`
+	Genre          string `xml:"genre,omitempty"`
+	FirstPublished string `xml:"firstpublished,omitempty"`
+	Description    string `xml:"description,omitempty"`
+	Language       string `xml:"language,omitempty"`
+	Group          string `xml:"group,omitempty"`
+}
+
+// Cover describes the story's cover art.
+type Cover struct {
+	Format string `xml:"format"`
+	Height int    `xml:"height"`
+	Width  int    `xml:"width"`
+}
+
+// IFDB is the section IFDB adds to the stories in its catalog exports.  The
+// numbers are left as text, since IFDB leaves them empty when there aren't
+// any ratings; and not every export has play times or awards.
+type IFDB struct {
+	TUID          string   `xml:"tuid"`
+	Link          string   `xml:"link"`
+	AverageRating string   `xml:"averageRating"`
+	RatingCount   string   `xml:"ratingCountTot"`
+	PlayTime      string   `xml:"playTime"`
+	Awards        []string `xml:"awards>award"`
+}
+
+// Parse reads an iFiction document.
+func Parse(r io.Reader) (*Index, error) {
+	index := &Index{}
+	decoder := xml.NewDecoder(r)
+	// iFiction is supposed to be UTF-8, but some tools write other labels
+	// for what is effectively ASCII/UTF-8 content.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	err := decoder.Decode(index)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range index.Stories {
+		s.Bibliographic.normalize()
+		if s.IFDB != nil {
+			s.IFDB.normalize()
+		}
+	}
+
+	return index, nil
+}
+
+// ParseString reads an iFiction document from a string.
+func ParseString(s string) (*Index, error) {
+	return Parse(strings.NewReader(s))
+}
+
+// Story returns the story with the given IFID (case-insensitively), or nil.
+func (index *Index) Story(ifid string) *Story {
+	for _, s := range index.Stories {
+		if s.HasIFID(ifid) {
+			return s
+		}
+	}
+	return nil
+}
+
+// HasIFID reports whether the story is identified by ifid.
+func (s *Story) HasIFID(ifid string) bool {
+	for _, id := range s.Identification.IFIDs {
+		if strings.EqualFold(strings.TrimSpace(id), strings.TrimSpace(ifid)) {
+			return true
+		}
+	}
+	return false
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// Descriptions may contain <br/> paragraph markers (which the XML decoder
+// drops), and the usual XML indentation whitespace; we collapse it all.
+func (b *Bibliographic) normalize() {
+	for _, f := range []*string{&b.Title, &b.Author, &b.Headline, &b.Genre, &b.FirstPublished, &b.Description, &b.Language, &b.Group} {
+		*f = strings.TrimSpace(whitespace.ReplaceAllString(*f, " "))
+	}
+}
+
+func (i *IFDB) normalize() {
+	for _, f := range []*string{&i.TUID, &i.Link, &i.AverageRating, &i.RatingCount, &i.PlayTime} {
+		*f = strings.TrimSpace(whitespace.ReplaceAllString(*f, " "))
+	}
+	for n, a := range i.Awards {
+		i.Awards[n] = strings.TrimSpace(whitespace.ReplaceAllString(a, " "))
+	}
+}
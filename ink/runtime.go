@@ -0,0 +1,938 @@
+package ink
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A story that runs this long without stopping for a choice is probably stuck
+// in a loop.
+const maxSteps = 1000000
+
+type frameType int
+
+// Call stack frame types.  The bottom frame (and any plain divert) is
+// noFrame.
+const (
+	noFrame frameType = iota
+	functionFrame
+	tunnelFrame
+	threadFrame
+)
+
+type pointer struct {
+	c *container
+	i int
+}
+
+// frame is an entry on the call stack: where the story is, and the temporary
+// variables in scope there.
+type frame struct {
+	kind       frameType
+	pointer    pointer
+	temps      map[string]interface{}
+	evaluating bool // whether we're evaluating an expression (between "ev" and "/ev")
+}
+
+func (f *frame) copy() *frame {
+	temps := make(map[string]interface{}, len(f.temps))
+	for k, v := range f.temps {
+		temps[k] = v
+	}
+	return &frame{
+		kind:       f.kind,
+		pointer:    f.pointer,
+		temps:      temps,
+		evaluating: f.evaluating,
+	}
+}
+
+// Choice is one of the choices the player can make.
+type Choice struct {
+	Text string
+}
+
+type pendingChoice struct {
+	Choice
+	target    pointer
+	invisible bool // a fallback choice, taken when there are no others
+	frames    []*frame
+}
+
+// Passage is the text the story produced before stopping, and the choices
+// that the player can make next.
+type Passage struct {
+	Lines   []string
+	Tags    []string
+	Choices []*Choice
+}
+
+// Markers in the output stream...
+type stringStart struct{}
+type tagStart struct{}
+
+// Runner plays a story.
+type Runner struct {
+	story *Story
+
+	frames  []*frame
+	eval    []interface{}
+	output  []interface{}
+	tags    []string
+	choices []*pendingChoice
+	globals map[string]interface{}
+	visits  map[string]int
+	turnsAt map[string]int
+	turn    int
+	ended   bool
+
+	seed           int64
+	previousRandom int64
+
+	// threadNext is set by the "thread" command; the following divert starts
+	// a thread.
+	threadNext bool
+}
+
+// NewRunner starts a new play-through of the story.
+func (s *Story) NewRunner() (*Runner, error) {
+	r := &Runner{
+		story: s,
+		seed:  time.Now().UnixNano() % 100,
+	}
+
+	err := r.Reset()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reset starts the story again from the beginning.
+func (r *Runner) Reset() error {
+	r.frames = []*frame{{temps: make(map[string]interface{})}}
+	r.eval = nil
+	r.output = nil
+	r.tags = nil
+	r.choices = nil
+	r.globals = make(map[string]interface{})
+	r.visits = make(map[string]int)
+	r.turnsAt = make(map[string]int)
+	r.turn = 0
+	r.ended = false
+	r.previousRandom = 0
+
+	// The global variables are declared (and initialized) by a special
+	// container, which ends with "end".
+	if decl, ok := r.story.root.named["global decl"]; ok {
+		r.top().pointer = pointer{decl, 0}
+		err := r.run()
+		if err != nil {
+			return err
+		}
+		r.frames = []*frame{{temps: make(map[string]interface{})}}
+		r.eval = nil
+		r.output = nil
+		r.ended = false
+	}
+
+	r.setPointer(pointer{r.story.root, 0})
+	return nil
+}
+
+// Ended reports whether the story has reached an "END" (or can't go any
+// further).
+func (r *Runner) Ended() bool {
+	return r.ended || (r.top().pointer.c == nil && len(r.visibleChoices()) == 0)
+}
+
+// Turn returns the number of choices made so far.
+func (r *Runner) Turn() int {
+	return r.turn
+}
+
+// Choices returns the choices the player can make now.
+func (r *Runner) Choices() []*Choice {
+	visible := r.visibleChoices()
+	choices := make([]*Choice, len(visible))
+	for i, c := range visible {
+		choice := c.Choice
+		choices[i] = &choice
+	}
+	return choices
+}
+
+func (r *Runner) visibleChoices() []*pendingChoice {
+	visible := make([]*pendingChoice, 0, len(r.choices))
+	for _, c := range r.choices {
+		if !c.invisible {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+// Continue runs the story until the player needs to make a choice, or the
+// story ends.
+func (r *Runner) Continue() (*Passage, error) {
+	for {
+		err := r.run()
+		if err != nil {
+			return nil, err
+		}
+
+		// If the only choices are fallbacks, the story takes the first one
+		// and carries on.
+		if len(r.visibleChoices()) > 0 || r.ended || len(r.choices) == 0 {
+			break
+		}
+		r.take(r.choices[0])
+	}
+
+	passage := &Passage{
+		Lines:   r.flushOutput(),
+		Tags:    r.tags,
+		Choices: r.Choices(),
+	}
+	r.tags = nil
+	return passage, nil
+}
+
+// Choose makes one of the choices returned by Choices (by index); call
+// Continue to see what happens.
+func (r *Runner) Choose(index int) error {
+	visible := r.visibleChoices()
+	if index < 0 || index >= len(visible) {
+		return fmt.Errorf("there’s no choice %d", index+1)
+	}
+	r.take(visible[index])
+	return nil
+}
+
+func (r *Runner) take(choice *pendingChoice) {
+	// The story carries on from wherever the choice was offered, but a thread
+	// that offered it is now the whole story.
+	r.frames = make([]*frame, len(choice.frames))
+	for i, f := range choice.frames {
+		r.frames[i] = f.copy()
+		if r.frames[i].kind == threadFrame {
+			r.frames[i].kind = noFrame
+		}
+	}
+
+	r.choices = nil
+	r.turn++
+	r.setPointer(choice.target)
+}
+
+func (r *Runner) top() *frame {
+	return r.frames[len(r.frames)-1]
+}
+
+func (r *Runner) push(value interface{}) {
+	r.eval = append(r.eval, value)
+}
+
+func (r *Runner) pop() (interface{}, error) {
+	if len(r.eval) == 0 {
+		return nil, fmt.Errorf("the story tried to use a value that isn’t there (%s)", r.location())
+	}
+	value := r.eval[len(r.eval)-1]
+	r.eval = r.eval[:len(r.eval)-1]
+	return value, nil
+}
+
+func (r *Runner) location() string {
+	p := r.top().pointer
+	if p.c == nil {
+		return "at the end"
+	}
+	return fmt.Sprintf("in %s", describePath(p.c.path))
+}
+
+// setPointer moves the story, counting visits to the containers it enters.
+func (r *Runner) setPointer(p pointer) {
+	prev := make(map[*container]bool)
+	for c := r.top().pointer.c; c != nil; c = c.parent {
+		prev[c] = true
+	}
+
+	r.top().pointer = p
+
+	atStart := p.i == 0
+	for c := p.c; c != nil && !prev[c]; c = c.parent {
+		if atStart || c.flags&countStartOnly == 0 {
+			r.visit(c)
+		}
+		atStart = atStart && c.index == 0
+	}
+}
+
+func (r *Runner) visit(c *container) {
+	if c.flags&countVisits != 0 {
+		r.visits[c.path]++
+	}
+	if c.flags&countTurns != 0 {
+		r.turnsAt[c.path] = r.turn
+	}
+}
+
+func (r *Runner) run() error {
+	for steps := 0; ; steps++ {
+		if steps > maxSteps {
+			return fmt.Errorf("the story seems to be stuck in a loop (%s)", r.location())
+		}
+
+		f := r.top()
+		p := f.pointer
+		if p.c == nil {
+			return nil
+		}
+
+		if p.i >= len(p.c.content) {
+			if !r.exitContainer() {
+				return nil
+			}
+			continue
+		}
+
+		obj := p.c.content[p.i]
+		f.pointer.i++
+
+		stop, err := r.step(obj)
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+}
+
+// exitContainer moves on from the end of a container, returning false if the
+// story can't go any further.
+func (r *Runner) exitContainer() bool {
+	f := r.top()
+	c := f.pointer.c
+	if c.parent != nil && c.index >= 0 {
+		f.pointer = pointer{c.parent, c.index + 1}
+		return true
+	}
+
+	// We've run off the end of a knot, function, or thread.
+	switch f.kind {
+	case functionFrame:
+		r.popFrame()
+		if r.top().evaluating {
+			r.push(void{})
+		}
+		return true
+	case tunnelFrame, threadFrame:
+		r.popFrame()
+		return true
+	}
+
+	f.pointer = pointer{}
+	return false
+}
+
+func (r *Runner) popFrame() {
+	if len(r.frames) > 1 {
+		r.frames = r.frames[:len(r.frames)-1]
+	}
+}
+
+func (r *Runner) step(obj interface{}) (bool, error) {
+	f := r.top()
+
+	switch v := obj.(type) {
+	case *container:
+		r.setPointer(pointer{v, 0})
+
+	case text:
+		if f.evaluating {
+			r.push(string(v))
+		} else {
+			r.output = append(r.output, string(v))
+		}
+
+	case glue:
+		r.output = append(r.output, v)
+
+	case legacyTag:
+		r.tags = append(r.tags, strings.TrimSpace(string(v)))
+
+	case command:
+		return r.command(v)
+
+	case native:
+		return false, r.callNative(v)
+
+	case *divert:
+		return false, r.divert(v)
+
+	case *choicePoint:
+		return false, r.choicePoint(v)
+
+	case *variableReference:
+		if v.readCount != "" {
+			c, _, err := r.story.resolve(v.readCount, v.owner)
+			if err != nil {
+				return false, err
+			}
+			r.push(r.visits[c.path])
+			return false, nil
+		}
+		value, err := r.getVariable(v.name)
+		if err != nil {
+			return false, err
+		}
+		r.push(value)
+
+	case *variableAssignment:
+		value, err := r.pop()
+		if err != nil {
+			return false, err
+		}
+		r.assign(v, value)
+
+	case *divertTarget:
+		c, i, err := r.story.resolve(v.path, v.owner)
+		if err != nil {
+			return false, err
+		}
+		r.push(&divertTarget{path: absolutePath(c, i)})
+
+	case *variablePointer:
+		r.push(r.resolvePointer(v))
+
+	case void:
+		r.push(v)
+
+	default:
+		if f.evaluating {
+			r.push(v)
+		} else {
+			r.output = append(r.output, formatValue(v))
+		}
+	}
+
+	return false, nil
+}
+
+func absolutePath(c *container, i int) string {
+	if i == 0 {
+		return c.path
+	}
+	if c.path == "" {
+		return strconv.Itoa(i)
+	}
+	return fmt.Sprintf("%s.%d", c.path, i)
+}
+
+func (r *Runner) command(cmd command) (bool, error) {
+	f := r.top()
+
+	switch cmd {
+	case "ev":
+		f.evaluating = true
+	case "/ev":
+		f.evaluating = false
+
+	case "out":
+		value, err := r.pop()
+		if err != nil {
+			return false, err
+		}
+		r.output = append(r.output, formatValue(value))
+
+	case "pop":
+		_, err := r.pop()
+		return false, err
+
+	case "du":
+		value, err := r.pop()
+		if err != nil {
+			return false, err
+		}
+		r.push(value)
+		r.push(value)
+
+	case "nop":
+
+	case "str":
+		r.output = append(r.output, stringStart{})
+		f.evaluating = false
+
+	case "/str":
+		r.push(r.collectOutput(stringStart{}))
+		f.evaluating = true
+
+	case "#":
+		r.output = append(r.output, tagStart{})
+
+	case "/#":
+		tag := strings.TrimSpace(r.collectOutput(tagStart{}))
+		// Tags on choices (which are evaluated as strings) are dropped.
+		if !r.inString() {
+			r.tags = append(r.tags, tag)
+		}
+
+	case "~ret", "->->":
+		var override *divertTarget
+		if cmd == "->->" {
+			value, err := r.pop()
+			if err != nil {
+				return false, err
+			}
+			override, _ = value.(*divertTarget)
+		}
+		if len(r.frames) == 1 {
+			return false, fmt.Errorf("the story tried to return from a function or tunnel it wasn’t in (%s)", r.location())
+		}
+		r.popFrame()
+		if override != nil {
+			return false, r.divertToPath(override.path, nil)
+		}
+
+	case "done":
+		if r.top().kind == threadFrame {
+			r.popFrame()
+			return false, nil
+		}
+		f.pointer = pointer{}
+		return true, nil
+
+	case "end":
+		r.ended = true
+		r.frames = r.frames[:1]
+		r.top().pointer = pointer{}
+		return true, nil
+
+	case "choiceCnt":
+		r.push(len(r.choices))
+
+	case "turns":
+		r.push(r.turn)
+
+	case "turn", "readc":
+		value, err := r.pop()
+		if err != nil {
+			return false, err
+		}
+		target, ok := value.(*divertTarget)
+		if !ok {
+			return false, fmt.Errorf("the story tried to count visits to something that isn’t a knot or stitch (%s)", r.location())
+		}
+		c, _, err := r.story.resolve(target.path, nil)
+		if err != nil {
+			return false, err
+		}
+		if cmd == "readc" {
+			r.push(r.visits[c.path])
+		} else if at, ok := r.turnsAt[c.path]; ok {
+			r.push(r.turn - at)
+		} else {
+			r.push(-1)
+		}
+
+	case "visit":
+		r.push(r.visits[f.pointer.c.path] - 1)
+
+	case "seq":
+		return false, r.sequence()
+
+	case "rnd":
+		max, err := r.popInt()
+		if err != nil {
+			return false, err
+		}
+		min, err := r.popInt()
+		if err != nil {
+			return false, err
+		}
+		if max < min {
+			return false, fmt.Errorf("RANDOM was given an empty range, %d to %d (%s)", min, max, r.location())
+		}
+		random := rand.New(rand.NewSource(r.seed + r.previousRandom)).Int63()
+		r.previousRandom = random % 1000000
+		r.push(min + int(random%int64(max-min+1)))
+
+	case "srnd":
+		seed, err := r.popInt()
+		if err != nil {
+			return false, err
+		}
+		r.seed = int64(seed)
+		r.previousRandom = 0
+		r.push(void{})
+
+	case "thread":
+		r.threadNext = true
+
+	default:
+		return false, fmt.Errorf("the story uses “%s”, which I don’t support (%s)", cmd, r.location())
+	}
+
+	return false, nil
+}
+
+func (r *Runner) popInt() (int, error) {
+	value, err := r.pop()
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		return int(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("the story expected a number, not %s (%s)", formatValue(value), r.location())
+}
+
+// sequence picks the next element of a shuffle.
+func (r *Runner) sequence() error {
+	count, err := r.popInt()
+	if err != nil {
+		return err
+	}
+	seqCount, err := r.popInt()
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		r.push(0)
+		return nil
+	}
+
+	loop := seqCount / count
+	iteration := seqCount % count
+
+	hash := 0
+	for _, c := range r.top().pointer.c.path {
+		hash += int(c)
+	}
+	random := rand.New(rand.NewSource(int64(hash+loop) + r.seed))
+
+	unpicked := make([]int, count)
+	for i := range unpicked {
+		unpicked[i] = i
+	}
+	for i := 0; i <= iteration; i++ {
+		chosen := random.Intn(len(unpicked))
+		if i == iteration {
+			r.push(unpicked[chosen])
+			break
+		}
+		unpicked = append(unpicked[:chosen], unpicked[chosen+1:]...)
+	}
+	return nil
+}
+
+func (r *Runner) inString() bool {
+	for i := len(r.output) - 1; i >= 0; i-- {
+		if _, ok := r.output[i].(stringStart); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectOutput removes (and returns as a string) the output since the most
+// recent marker.
+func (r *Runner) collectOutput(marker interface{}) string {
+	start := len(r.output)
+	for i := len(r.output) - 1; i >= 0; i-- {
+		if r.output[i] == marker {
+			start = i
+			break
+		}
+	}
+
+	var b strings.Builder
+	for _, item := range r.output[start:] {
+		if s, ok := item.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	if start < len(r.output) {
+		r.output = r.output[:start]
+	}
+	return b.String()
+}
+
+func (r *Runner) divert(d *divert) error {
+	if d.conditional {
+		value, err := r.pop()
+		if err != nil {
+			return err
+		}
+		if !truthy(value) {
+			return nil
+		}
+	}
+
+	kind := d.pushType
+	if r.threadNext {
+		kind = threadFrame
+		r.threadNext = false
+	}
+
+	path := d.target
+	owner := d.owner
+	switch {
+	case d.variable:
+		value, err := r.getVariable(d.target)
+		if err != nil {
+			return err
+		}
+		target, ok := value.(*divertTarget)
+		if !ok {
+			return fmt.Errorf("the story tried to divert to “%s”, which isn’t a knot or stitch (%s)", d.target, r.location())
+		}
+		path = target.path
+		owner = nil
+
+	case d.external:
+		// We can only call external functions that have an ink fallback.
+		if _, ok := r.story.root.named[d.target]; !ok {
+			return fmt.Errorf("the story calls the external function “%s”, which I don’t have (%s)", d.target, r.location())
+		}
+		owner = nil
+	}
+
+	switch kind {
+	case functionFrame, tunnelFrame:
+		r.frames = append(r.frames, &frame{
+			kind:    kind,
+			pointer: r.top().pointer,
+			temps:   make(map[string]interface{}),
+		})
+	case threadFrame:
+		f := r.top().copy()
+		f.kind = threadFrame
+		f.evaluating = false
+		r.frames = append(r.frames, f)
+	}
+
+	return r.divertToPath(path, owner)
+}
+
+func (r *Runner) divertToPath(path string, owner *container) error {
+	c, i, err := r.story.resolve(path, owner)
+	if err != nil {
+		return err
+	}
+	r.setPointer(pointer{c, i})
+	return nil
+}
+
+func (r *Runner) choicePoint(cp *choicePoint) error {
+	show := true
+	if cp.flags&choiceHasCondition != 0 {
+		value, err := r.pop()
+		if err != nil {
+			return err
+		}
+		show = truthy(value)
+	}
+
+	var start, choiceOnly string
+	if cp.flags&choiceHasChoiceOnly != 0 {
+		value, err := r.pop()
+		if err != nil {
+			return err
+		}
+		choiceOnly = formatValue(value)
+	}
+	if cp.flags&choiceHasStartContent != 0 {
+		value, err := r.pop()
+		if err != nil {
+			return err
+		}
+		start = formatValue(value)
+	}
+
+	c, i, err := r.story.resolve(cp.target, cp.owner)
+	if err != nil {
+		return err
+	}
+
+	if cp.flags&choiceOnceOnly != 0 && r.visits[c.path] > 0 {
+		show = false
+	}
+	if !show {
+		return nil
+	}
+
+	frames := make([]*frame, len(r.frames))
+	for i, f := range r.frames {
+		frames[i] = f.copy()
+	}
+
+	r.choices = append(r.choices, &pendingChoice{
+		Choice:    Choice{Text: cleanLine(start + choiceOnly)},
+		target:    pointer{c, i},
+		invisible: cp.flags&choiceIsInvisibleDefault != 0,
+		frames:    frames,
+	})
+	return nil
+}
+
+func (r *Runner) getVariable(name string) (interface{}, error) {
+	value, ok := r.top().temps[name]
+	if !ok {
+		value, ok = r.globals[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("the story uses the variable “%s” without setting it (%s)", name, r.location())
+	}
+
+	// Reference parameters point at the variable they refer to.
+	if p, ok := value.(*variablePointer); ok {
+		return r.getPointedVariable(p)
+	}
+	return value, nil
+}
+
+func (r *Runner) getPointedVariable(p *variablePointer) (interface{}, error) {
+	vars := r.globals
+	if p.context > 0 && p.context <= len(r.frames) {
+		vars = r.frames[p.context-1].temps
+	}
+	value, ok := vars[p.name]
+	if !ok {
+		return nil, fmt.Errorf("the story refers to the variable “%s”, which doesn’t exist (%s)", p.name, r.location())
+	}
+	return value, nil
+}
+
+// resolvePointer works out which variable a pointer refers to: a temporary
+// variable in the current frame, or else a global.
+func (r *Runner) resolvePointer(p *variablePointer) *variablePointer {
+	if p.context >= 0 {
+		return p
+	}
+	if value, ok := r.top().temps[p.name]; ok {
+		// A pointer to a pointer is really a pointer to its target.
+		if inner, ok := value.(*variablePointer); ok {
+			return inner
+		}
+		return &variablePointer{name: p.name, context: len(r.frames)}
+	}
+	return &variablePointer{name: p.name, context: 0}
+}
+
+func (r *Runner) assign(a *variableAssignment, value interface{}) {
+	if p, ok := value.(*variablePointer); ok {
+		value = r.resolvePointer(p)
+	}
+
+	vars := r.globals
+	if !a.global {
+		vars = r.top().temps
+	}
+
+	// Assigning to a reference parameter assigns to what it refers to.
+	if a.reassign {
+		if p, ok := vars[a.name].(*variablePointer); ok {
+			if _, isPointer := value.(*variablePointer); !isPointer {
+				vars = r.globals
+				if p.context > 0 && p.context <= len(r.frames) {
+					vars = r.frames[p.context-1].temps
+				}
+				vars[p.name] = value
+				return
+			}
+		}
+	}
+
+	vars[a.name] = value
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case *divertTarget:
+		return true
+	}
+	return false
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case *divertTarget:
+		return v.path
+	case *variablePointer:
+		return v.name
+	}
+	return ""
+}
+
+var spaces = regexp.MustCompile(`[ \t]+`)
+
+func cleanLine(s string) string {
+	return strings.TrimSpace(spaces.ReplaceAllString(s, " "))
+}
+
+// flushOutput turns the output stream into lines of text, applying glue and
+// dropping blank lines.
+func (r *Runner) flushOutput() []string {
+	var text []byte
+	glued := false
+	for _, item := range r.output {
+		switch v := item.(type) {
+		case glue:
+			for len(text) > 0 && text[len(text)-1] == '\n' {
+				text = text[:len(text)-1]
+			}
+			glued = true
+
+		case string:
+			if v == "\n" {
+				if !glued && len(text) > 0 && text[len(text)-1] != '\n' {
+					text = append(text, '\n')
+				}
+				continue
+			}
+			if strings.TrimSpace(v) != "" {
+				glued = false
+			}
+			text = append(text, v...)
+		}
+	}
+	r.output = nil
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(text), "\n") {
+		if line = cleanLine(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
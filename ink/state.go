@@ -0,0 +1,245 @@
+package ink
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The saved state of a story is everything but the story itself: where the
+// story is (the call stack), the variables, the visit counts, and the choices
+// on offer.  Places in the story are saved as container paths, so a state can
+// only be loaded into the same story (or, with luck, one that's only changed a
+// little).
+
+type savedValue struct {
+	Int     *int     `json:"i,omitempty"`
+	Float   *float64 `json:"f,omitempty"`
+	String  *string  `json:"s,omitempty"`
+	Bool    *bool    `json:"b,omitempty"`
+	Divert  *string  `json:"d,omitempty"`
+	Pointer *string  `json:"p,omitempty"`
+	Context int      `json:"c,omitempty"`
+	Void    bool     `json:"v,omitempty"`
+}
+
+type savedFrame struct {
+	Kind       frameType             `json:",omitempty"`
+	Container  *string               `json:",omitempty"` // nil when the frame has nowhere left to go
+	Index      int                   `json:",omitempty"`
+	Temps      map[string]savedValue `json:",omitempty"`
+	Evaluating bool                  `json:",omitempty"`
+}
+
+type savedChoice struct {
+	Text      string
+	Container string
+	Index     int  `json:",omitempty"`
+	Invisible bool `json:",omitempty"`
+	Frames    []savedFrame
+}
+
+type savedState struct {
+	Frames         []savedFrame
+	Eval           []savedValue          `json:",omitempty"`
+	Globals        map[string]savedValue `json:",omitempty"`
+	Visits         map[string]int        `json:",omitempty"`
+	TurnsAt        map[string]int        `json:",omitempty"`
+	Turn           int
+	Choices        []savedChoice `json:",omitempty"`
+	Ended          bool          `json:",omitempty"`
+	Seed           int64
+	PreviousRandom int64 `json:",omitempty"`
+}
+
+// SaveState returns the state of the story, to be restored with LoadState.
+// It should be called between turns (after Continue).
+func (r *Runner) SaveState() ([]byte, error) {
+	s := &savedState{
+		Frames:         saveFrames(r.frames),
+		Eval:           make([]savedValue, len(r.eval)),
+		Globals:        saveValues(r.globals),
+		Visits:         r.visits,
+		TurnsAt:        r.turnsAt,
+		Turn:           r.turn,
+		Choices:        make([]savedChoice, len(r.choices)),
+		Ended:          r.ended,
+		Seed:           r.seed,
+		PreviousRandom: r.previousRandom,
+	}
+
+	for i, value := range r.eval {
+		s.Eval[i] = saveValue(value)
+	}
+
+	for i, c := range r.choices {
+		s.Choices[i] = savedChoice{
+			Text:      c.Text,
+			Container: c.target.c.path,
+			Index:     c.target.i,
+			Invisible: c.invisible,
+			Frames:    saveFrames(c.frames),
+		}
+	}
+
+	return json.Marshal(s)
+}
+
+// LoadState restores a state returned by SaveState.
+func (r *Runner) LoadState(b []byte) error {
+	s := &savedState{}
+	err := json.Unmarshal(b, s)
+	if err != nil {
+		return fmt.Errorf("the saved state is damaged (%s)", err.Error())
+	}
+
+	if len(s.Frames) == 0 {
+		return fmt.Errorf("the saved state doesn’t say where the story was")
+	}
+
+	frames, err := r.loadFrames(s.Frames)
+	if err != nil {
+		return err
+	}
+
+	choices := make([]*pendingChoice, len(s.Choices))
+	for i, c := range s.Choices {
+		target, err := r.story.containerAt(c.Container)
+		if err != nil {
+			return r.mismatch(err)
+		}
+		choiceFrames, err := r.loadFrames(c.Frames)
+		if err != nil {
+			return err
+		}
+		choices[i] = &pendingChoice{
+			Choice:    Choice{Text: c.Text},
+			target:    pointer{target, c.Index},
+			invisible: c.Invisible,
+			frames:    choiceFrames,
+		}
+	}
+
+	eval := make([]interface{}, len(s.Eval))
+	for i, value := range s.Eval {
+		eval[i] = loadValue(value)
+	}
+
+	r.frames = frames
+	r.eval = eval
+	r.output = nil
+	r.tags = nil
+	r.choices = choices
+	r.globals = loadValues(s.Globals)
+	r.visits = s.Visits
+	r.turnsAt = s.TurnsAt
+	r.turn = s.Turn
+	r.ended = s.Ended
+	r.seed = s.Seed
+	r.previousRandom = s.PreviousRandom
+	r.threadNext = false
+
+	if r.visits == nil {
+		r.visits = make(map[string]int)
+	}
+	if r.turnsAt == nil {
+		r.turnsAt = make(map[string]int)
+	}
+
+	return nil
+}
+
+func (r *Runner) mismatch(err error) error {
+	return fmt.Errorf("the saved state doesn’t fit this story (%s)", err.Error())
+}
+
+func saveFrames(frames []*frame) []savedFrame {
+	saved := make([]savedFrame, len(frames))
+	for i, f := range frames {
+		saved[i] = savedFrame{
+			Kind:       f.kind,
+			Index:      f.pointer.i,
+			Temps:      saveValues(f.temps),
+			Evaluating: f.evaluating,
+		}
+		if f.pointer.c != nil {
+			path := f.pointer.c.path
+			saved[i].Container = &path
+		}
+	}
+	return saved
+}
+
+func (r *Runner) loadFrames(saved []savedFrame) ([]*frame, error) {
+	frames := make([]*frame, len(saved))
+	for i, s := range saved {
+		f := &frame{
+			kind:       s.Kind,
+			temps:      loadValues(s.Temps),
+			evaluating: s.Evaluating,
+		}
+		if s.Container != nil {
+			c, err := r.story.containerAt(*s.Container)
+			if err != nil {
+				return nil, r.mismatch(err)
+			}
+			if s.Index > len(c.content) {
+				return nil, r.mismatch(fmt.Errorf("%s is shorter than it was", describePath(c.path)))
+			}
+			f.pointer = pointer{c, s.Index}
+		}
+		frames[i] = f
+	}
+	return frames, nil
+}
+
+func saveValues(values map[string]interface{}) map[string]savedValue {
+	saved := make(map[string]savedValue, len(values))
+	for name, value := range values {
+		saved[name] = saveValue(value)
+	}
+	return saved
+}
+
+func loadValues(saved map[string]savedValue) map[string]interface{} {
+	values := make(map[string]interface{}, len(saved))
+	for name, value := range saved {
+		values[name] = loadValue(value)
+	}
+	return values
+}
+
+func saveValue(value interface{}) savedValue {
+	switch v := value.(type) {
+	case int:
+		return savedValue{Int: &v}
+	case float64:
+		return savedValue{Float: &v}
+	case string:
+		return savedValue{String: &v}
+	case bool:
+		return savedValue{Bool: &v}
+	case *divertTarget:
+		return savedValue{Divert: &v.path}
+	case *variablePointer:
+		return savedValue{Pointer: &v.name, Context: v.context}
+	}
+	return savedValue{Void: true}
+}
+
+func loadValue(saved savedValue) interface{} {
+	switch {
+	case saved.Int != nil:
+		return *saved.Int
+	case saved.Float != nil:
+		return *saved.Float
+	case saved.String != nil:
+		return *saved.String
+	case saved.Bool != nil:
+		return *saved.Bool
+	case saved.Divert != nil:
+		return &divertTarget{path: *saved.Divert}
+	case saved.Pointer != nil:
+		return &variablePointer{name: *saved.Pointer, context: saved.Context}
+	}
+	return void{}
+}
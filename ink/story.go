@@ -0,0 +1,527 @@
+package ink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ink (https://www.inklestudios.com/ink/) is a scripting language for
+// choice-based stories.  Its compiler, inklecate, produces a JSON "story"
+// that's run by a small virtual machine: the story is a tree of containers
+// (arrays of content), the content being text, control commands, diverts
+// (gotos, function calls, and tunnels), choice points, and variable
+// operations.  See
+// https://github.com/inkle/ink/blob/master/Documentation/ink_JSON_runtime_format.md
+//
+// This package runs the parts of that format that a story written for the web
+// or for Inky typically uses: text, glue, tags, choices (including once-only,
+// conditional, and fallback choices), knots and stitches, diverts, tunnels,
+// functions, threads, sequences and shuffles, and global and temporary
+// variables.  Lists and external functions (other than those with ink
+// fallbacks) aren't supported.
+
+// The range of compiled story formats we can run.
+const (
+	minInkVersion = 18
+	maxInkVersion = 21
+)
+
+// Container flags...
+const (
+	countVisits    = 0x1
+	countTurns     = 0x2
+	countStartOnly = 0x4
+)
+
+// container is an array of content, with optional named sub-containers.
+type container struct {
+	name    string
+	content []interface{}
+	named   map[string]*container
+	parent  *container
+	flags   int
+	index   int    // position in the parent's content, or -1 if named only
+	path    string // "" for the root
+}
+
+// text is literal output (or, during string evaluation, part of a string).
+type text string
+
+// command is a control command, like "ev" or "done".
+type command string
+
+// native is a native (built-in) function, like "+" or "MIN".
+type native string
+
+type glue struct{}
+
+type void struct{}
+
+// divert moves the story elsewhere: a plain divert, a function call, a tunnel,
+// or the start of a thread.
+type divert struct {
+	target      string
+	variable    bool // target is the name of a variable holding a divert target
+	conditional bool
+	pushType    frameType
+	external    bool
+	externalArg int
+	owner       *container
+}
+
+type choicePoint struct {
+	target string
+	flags  int
+	owner  *container
+}
+
+// Choice point flags...
+const (
+	choiceHasCondition       = 0x1
+	choiceHasStartContent    = 0x2
+	choiceHasChoiceOnly      = 0x4
+	choiceIsInvisibleDefault = 0x8
+	choiceOnceOnly           = 0x10
+)
+
+type variableReference struct {
+	name      string
+	readCount string // path of the container whose visits are read
+	owner     *container
+}
+
+type variableAssignment struct {
+	name     string
+	global   bool
+	reassign bool
+}
+
+// divertTarget is a value naming a place in the story.
+type divertTarget struct {
+	path  string
+	owner *container
+}
+
+// variablePointer is a value referring to a variable (for ref parameters).
+type variablePointer struct {
+	name    string
+	context int // -1 until resolved, 0 for a global, or the frame's depth
+}
+
+// legacyTag is a tag from older story formats, which hold the tag's text.
+type legacyTag string
+
+// Story is a compiled Ink story.
+type Story struct {
+	InkVersion int
+	root       *container
+}
+
+// IsStory reports whether the data looks like a compiled Ink story.
+func IsStory(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n\xef\xbb\xbf")
+	if !bytes.HasPrefix(b, []byte("{")) {
+		return false
+	}
+	n := len(b)
+	if n > 64 {
+		n = 64
+	}
+	return bytes.Contains(b[:n], []byte(`"inkVersion"`))
+}
+
+// Parse reads a compiled Ink story.
+func Parse(b []byte) (*Story, error) {
+	// inklecate writes a byte-order mark.
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))
+
+	var raw struct {
+		InkVersion int             `json:"inkVersion"`
+		Root       json.RawMessage `json:"root"`
+	}
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("it isn’t valid JSON (%s)", err.Error())
+	}
+
+	if raw.InkVersion == 0 || raw.Root == nil {
+		return nil, fmt.Errorf("it isn’t a compiled Ink story")
+	}
+	if raw.InkVersion < minInkVersion || raw.InkVersion > maxInkVersion {
+		return nil, fmt.Errorf("it’s in Ink format %d, and I can only run formats %d to %d", raw.InkVersion, minInkVersion, maxInkVersion)
+	}
+
+	var root []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw.Root))
+	decoder.UseNumber()
+	err = decoder.Decode(&root)
+	if err != nil {
+		return nil, fmt.Errorf("its root isn’t a container (%s)", err.Error())
+	}
+
+	c, err := parseContainer(root, nil, -1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Story{
+		InkVersion: raw.InkVersion,
+		root:       c,
+	}, nil
+}
+
+func parseContainer(raw []interface{}, parent *container, index int, name string) (*container, error) {
+	c := &container{
+		name:   name,
+		named:  make(map[string]*container),
+		parent: parent,
+		index:  index,
+	}
+
+	// The last element is the terminator: null, or an object with the named
+	// content, flags, and name.
+	var terminator map[string]interface{}
+	if len(raw) > 0 {
+		terminator, _ = raw[len(raw)-1].(map[string]interface{})
+		raw = raw[:len(raw)-1]
+	}
+	if n, ok := terminator["#n"].(string); ok {
+		c.name = n
+	}
+	if f, ok := terminator["#f"].(json.Number); ok {
+		flags, _ := f.Int64()
+		c.flags = int(flags)
+	}
+
+	c.path = childPath(parent, c.name, index)
+
+	for i, item := range raw {
+		obj, err := parseObject(item, c, i)
+		if err != nil {
+			return nil, err
+		}
+		c.content = append(c.content, obj)
+		if child, ok := obj.(*container); ok && child.name != "" {
+			c.named[child.name] = child
+		}
+	}
+
+	for key, value := range terminator {
+		if key == "#n" || key == "#f" {
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("named content “%s” in %s isn’t a container", key, describePath(c.path))
+		}
+		child, err := parseContainer(items, c, -1, key)
+		if err != nil {
+			return nil, err
+		}
+		c.named[key] = child
+	}
+
+	return c, nil
+}
+
+func childPath(parent *container, name string, index int) string {
+	if parent == nil {
+		return ""
+	}
+
+	component := name
+	if component == "" {
+		component = strconv.Itoa(index)
+	}
+	if parent.path == "" {
+		return component
+	}
+	return parent.path + "." + component
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the root"
+	}
+	return fmt.Sprintf("“%s”", path)
+}
+
+func parseObject(item interface{}, owner *container, index int) (interface{}, error) {
+	switch v := item.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+
+	case bool:
+		return v, nil
+
+	case string:
+		switch {
+		case strings.HasPrefix(v, "^"):
+			return text(v[1:]), nil
+		case v == "\n":
+			return text(v), nil
+		case v == "<>":
+			return glue{}, nil
+		case v == "void":
+			return void{}, nil
+		case isCommand(v):
+			return command(v), nil
+		case isNative(v):
+			return native(v), nil
+		case v == "L^":
+			// (The list intersection operator, which we don't support.)
+			return native(v), nil
+		}
+		return nil, fmt.Errorf("unknown content “%s” in %s", v, describePath(owner.path))
+
+	case []interface{}:
+		return parseContainer(v, owner, index, "")
+
+	case map[string]interface{}:
+		return parseMap(v, owner)
+
+	case nil:
+		return nil, fmt.Errorf("unexpected null in %s", describePath(owner.path))
+	}
+
+	return nil, fmt.Errorf("unknown content %v in %s", item, describePath(owner.path))
+}
+
+func parseMap(m map[string]interface{}, owner *container) (interface{}, error) {
+	str := func(key string) (string, bool) {
+		s, ok := m[key].(string)
+		return s, ok
+	}
+	flag := func(key string) bool {
+		b, _ := m[key].(bool)
+		return b
+	}
+
+	if target, ok := str("^->"); ok {
+		return &divertTarget{path: target, owner: owner}, nil
+	}
+
+	if name, ok := str("^var"); ok {
+		ctx := -1
+		if ci, ok := m["ci"].(json.Number); ok {
+			i, _ := ci.Int64()
+			ctx = int(i)
+		}
+		return &variablePointer{name: name, context: ctx}, nil
+	}
+
+	for key, pushType := range map[string]frameType{
+		"->":    noFrame,
+		"f()":   functionFrame,
+		"->t->": tunnelFrame,
+		"x()":   functionFrame,
+	} {
+		target, ok := str(key)
+		if !ok {
+			continue
+		}
+		d := &divert{
+			target:      target,
+			variable:    flag("var"),
+			conditional: flag("c"),
+			pushType:    pushType,
+			external:    key == "x()",
+			owner:       owner,
+		}
+		if n, ok := m["exArgs"].(json.Number); ok {
+			i, _ := n.Int64()
+			d.externalArg = int(i)
+		}
+		return d, nil
+	}
+
+	if target, ok := str("*"); ok {
+		flags := 0
+		if f, ok := m["flg"].(json.Number); ok {
+			i, _ := f.Int64()
+			flags = int(i)
+		}
+		return &choicePoint{target: target, flags: flags, owner: owner}, nil
+	}
+
+	if name, ok := str("VAR?"); ok {
+		return &variableReference{name: name, owner: owner}, nil
+	}
+	if path, ok := str("CNT?"); ok {
+		return &variableReference{readCount: path, owner: owner}, nil
+	}
+
+	if name, ok := str("VAR="); ok {
+		return &variableAssignment{name: name, global: true, reassign: flag("re")}, nil
+	}
+	if name, ok := str("temp="); ok {
+		return &variableAssignment{name: name, reassign: flag("re")}, nil
+	}
+
+	if tag, ok := str("#"); ok {
+		return legacyTag(tag), nil
+	}
+
+	if _, ok := m["list"]; ok {
+		return nil, fmt.Errorf("the story uses lists (in %s), which I don’t support", describePath(owner.path))
+	}
+
+	return nil, fmt.Errorf("unknown content %v in %s", m, describePath(owner.path))
+}
+
+var commands = map[string]bool{
+	"ev": true, "/ev": true, "out": true, "pop": true, "->->": true,
+	"~ret": true, "du": true, "str": true, "/str": true, "nop": true,
+	"choiceCnt": true, "turns": true, "turn": true, "readc": true,
+	"rnd": true, "srnd": true, "visit": true, "seq": true, "thread": true,
+	"done": true, "end": true, "listInt": true, "range": true, "lrnd": true,
+	"#": true, "/#": true,
+}
+
+func isCommand(s string) bool {
+	return commands[s]
+}
+
+var natives = map[string]int{
+	"+": 2, "-": 2, "/": 2, "*": 2, "%": 2, "_": 1, "==": 2, ">": 2,
+	"<": 2, ">=": 2, "<=": 2, "!=": 2, "!": 1, "&&": 2, "||": 2,
+	"MIN": 2, "MAX": 2, "POW": 2, "FLOOR": 1, "CEILING": 1, "INT": 1,
+	"FLOAT": 1, "?": 2, "!?": 2, "LIST_MIN": 1, "LIST_MAX": 1,
+	"LIST_ALL": 1, "LIST_COUNT": 1, "LIST_VALUE": 1, "LIST_INVERT": 1,
+}
+
+func isNative(s string) bool {
+	_, ok := natives[s]
+	return ok
+}
+
+// GlobalTags returns the tags at the very start of the story, which by
+// convention describe it (“title: The Intercept”, “author: inkle”).
+func (s *Story) GlobalTags() []string {
+	tags := make([]string, 0)
+
+	c := s.root
+	for len(c.content) > 0 {
+		if first, ok := c.content[0].(*container); ok {
+			c = first
+			continue
+		}
+		break
+	}
+
+	inTag := false
+	for _, obj := range c.content {
+		switch v := obj.(type) {
+		case legacyTag:
+			tags = append(tags, strings.TrimSpace(string(v)))
+		case command:
+			switch v {
+			case "#":
+				inTag = true
+				tags = append(tags, "")
+			case "/#":
+				inTag = false
+			case "ev", "/ev", "str", "/str", "nop":
+			default:
+				return tags
+			}
+		case text:
+			if !inTag {
+				return tags
+			}
+			tags[len(tags)-1] = strings.TrimSpace(tags[len(tags)-1] + string(v))
+		default:
+			return tags
+		}
+	}
+
+	return tags
+}
+
+// GlobalTag returns the value of a global “key: value” tag, or "".
+func (s *Story) GlobalTag(key string) string {
+	for _, tag := range s.GlobalTags() {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), key) {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+	return ""
+}
+
+// resolve finds the container (and index within it) that a path refers to.
+// Relative paths (starting with ".") are relative to owner.
+func (s *Story) resolve(path string, owner *container) (*container, int, error) {
+	c := s.root
+	components := strings.Split(path, ".")
+	if strings.HasPrefix(path, ".") {
+		// The first component is the (empty) start; the one after is the
+		// "^" that steps from the object to its container.
+		c = owner
+		components = components[1:]
+		if len(components) > 0 && components[0] == "^" {
+			components = components[1:]
+		}
+	}
+
+	for i, component := range components {
+		last := i == len(components)-1
+
+		if component == "^" {
+			if c.parent == nil {
+				return nil, 0, fmt.Errorf("path “%s” goes above the root", path)
+			}
+			c = c.parent
+			continue
+		}
+
+		if n, err := strconv.Atoi(component); err == nil {
+			if n < 0 || n > len(c.content) {
+				return nil, 0, fmt.Errorf("path “%s” is out of range", path)
+			}
+			if n < len(c.content) {
+				if child, ok := c.content[n].(*container); ok {
+					c = child
+					continue
+				}
+			}
+			if last {
+				return c, n, nil
+			}
+			return nil, 0, fmt.Errorf("path “%s” goes through something that isn’t a container", path)
+		}
+
+		child, ok := c.named[component]
+		if !ok {
+			return nil, 0, fmt.Errorf("path “%s” doesn’t exist (there’s no “%s” in %s)", path, component, describePath(c.path))
+		}
+		c = child
+	}
+
+	return c, 0, nil
+}
+
+// containerAt finds a container by its full path.
+func (s *Story) containerAt(path string) (*container, error) {
+	if path == "" {
+		return s.root, nil
+	}
+	c, index, err := s.resolve(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if index != 0 {
+		return nil, fmt.Errorf("path “%s” isn’t a container", path)
+	}
+	return c, nil
+}
@@ -0,0 +1,245 @@
+package ink
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// callNative runs one of the built-in functions on the values at the top of
+// the evaluation stack.
+func (r *Runner) callNative(fn native) error {
+	arity := natives[string(fn)]
+	if strings.HasPrefix(string(fn), "LIST_") || fn == "L^" {
+		return fmt.Errorf("the story uses lists (“%s”), which I don’t support (%s)", fn, r.location())
+	}
+
+	args := make([]interface{}, arity)
+	for i := arity - 1; i >= 0; i-- {
+		value, err := r.pop()
+		if err != nil {
+			return err
+		}
+		if _, ok := value.(void); ok {
+			return fmt.Errorf("the story used the result of a function that doesn’t return anything (%s)", r.location())
+		}
+		args[i] = value
+	}
+
+	var result interface{}
+	var err error
+	if arity == 1 {
+		result, err = unary(fn, args[0])
+	} else {
+		result, err = binary(fn, args[0], args[1])
+	}
+	if err != nil {
+		return fmt.Errorf("%s (%s)", err.Error(), r.location())
+	}
+
+	r.push(result)
+	return nil
+}
+
+func unary(fn native, a interface{}) (interface{}, error) {
+	switch fn {
+	case "!":
+		return !truthy(a), nil
+	}
+
+	switch v := a.(type) {
+	case int:
+		switch fn {
+		case "_":
+			return -v, nil
+		case "FLOOR", "CEILING", "INT":
+			return v, nil
+		case "FLOAT":
+			return float64(v), nil
+		}
+	case float64:
+		switch fn {
+		case "_":
+			return -v, nil
+		case "FLOOR":
+			return math.Floor(v), nil
+		case "CEILING":
+			return math.Ceil(v), nil
+		case "INT":
+			return int(v), nil
+		case "FLOAT":
+			return v, nil
+		}
+	case bool:
+		n := 0
+		if v {
+			n = 1
+		}
+		return unary(fn, n)
+	}
+
+	return nil, fmt.Errorf("the story can’t use “%s” on %s", fn, describeValue(a))
+}
+
+func binary(fn native, a interface{}, b interface{}) (interface{}, error) {
+	switch fn {
+	case "&&":
+		return truthy(a) && truthy(b), nil
+	case "||":
+		return truthy(a) || truthy(b), nil
+	}
+
+	// Strings win over numbers, and floats over ints.
+	_, aString := a.(string)
+	_, bString := b.(string)
+	if aString || bString {
+		return stringOp(fn, formatValue(a), formatValue(b))
+	}
+
+	if target, ok := a.(*divertTarget); ok {
+		other, ok := b.(*divertTarget)
+		switch fn {
+		case "==":
+			return ok && target.path == other.path, nil
+		case "!=":
+			return !ok || target.path != other.path, nil
+		}
+		return nil, fmt.Errorf("the story can’t use “%s” on a divert target", fn)
+	}
+
+	x, xok := toFloat(a)
+	y, yok := toFloat(b)
+	if !xok || !yok {
+		return nil, fmt.Errorf("the story can’t use “%s” on %s and %s", fn, describeValue(a), describeValue(b))
+	}
+
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	if aFloat || bFloat {
+		return floatOp(fn, x, y)
+	}
+	return intOp(fn, int(x), int(y))
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func intOp(fn native, a int, b int) (interface{}, error) {
+	switch fn {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return nil, fmt.Errorf("the story divided by zero")
+		}
+		if fn == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	case "<=":
+		return a <= b, nil
+	case ">=":
+		return a >= b, nil
+	case "MIN":
+		if a < b {
+			return a, nil
+		}
+		return b, nil
+	case "MAX":
+		if a > b {
+			return a, nil
+		}
+		return b, nil
+	case "POW":
+		return int(math.Pow(float64(a), float64(b))), nil
+	}
+	return nil, fmt.Errorf("the story can’t use “%s” on numbers", fn)
+}
+
+func floatOp(fn native, a float64, b float64) (interface{}, error) {
+	switch fn {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		return a / b, nil
+	case "%":
+		return math.Mod(a, b), nil
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case ">":
+		return a > b, nil
+	case "<=":
+		return a <= b, nil
+	case ">=":
+		return a >= b, nil
+	case "MIN":
+		return math.Min(a, b), nil
+	case "MAX":
+		return math.Max(a, b), nil
+	case "POW":
+		return math.Pow(a, b), nil
+	}
+	return nil, fmt.Errorf("the story can’t use “%s” on numbers", fn)
+}
+
+func stringOp(fn native, a string, b string) (interface{}, error) {
+	switch fn {
+	case "+":
+		return a + b, nil
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "?":
+		return strings.Contains(a, b), nil
+	case "!?":
+		return !strings.Contains(a, b), nil
+	}
+	return nil, fmt.Errorf("the story can’t use “%s” on text", fn)
+}
+
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("the text “%s”", v)
+	case int, float64:
+		return fmt.Sprintf("the number %s", formatValue(v))
+	case bool:
+		return formatValue(v)
+	case *divertTarget:
+		return fmt.Sprintf("the divert target “%s”", v.path)
+	}
+	return "nothing"
+}
@@ -6,21 +6,33 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"path"
 	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/JaredReisinger/xyzzybot/backup"
+	"github.com/JaredReisinger/xyzzybot/channels"
 	"github.com/JaredReisinger/xyzzybot/console"
 	"github.com/JaredReisinger/xyzzybot/fizmo"
 	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/ink"
+	"github.com/JaredReisinger/xyzzybot/retention"
+	"github.com/JaredReisinger/xyzzybot/sessions"
 	"github.com/JaredReisinger/xyzzybot/slack"
+	"github.com/JaredReisinger/xyzzybot/twine"
 )
 
 const (
 	defaultGameDirectory = "/usr/local/games"
 	defaultWorkingRoot   = "/usr/local/var/xyzzybot"
 	defaultConfigFile    = "/usr/local/etc/xyzzybot/config.json"
+
+	defaultShutdownTimeout    = 30 // seconds
+	defaultSweepIntervalHours = 24
+	defaultWatchSeconds       = 30
 )
 
 func main() {
@@ -28,6 +40,13 @@ func main() {
 	logBase.Level = log.DebugLevel
 	logger := logBase.WithField("component", "main")
 
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			subcommand(os.Args[2:], logBase)
+			return
+		}
+	}
+
 	configParam := AddConfigFlag()
 
 	gameDirParam := flag.String("game-dir", "",
@@ -102,6 +121,20 @@ func main() {
 	fallback("game directory", &config.GameDirectory, defaultGameDirectory, logger)
 	fallback("working root", &config.WorkingRoot, defaultWorkingRoot, logger)
 
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = defaultShutdownTimeout
+	}
+	shutdownTimeout := time.Duration(config.ShutdownTimeout) * time.Second
+
+	if config.WatchSeconds == 0 {
+		config.WatchSeconds = defaultWatchSeconds
+	}
+
+	fallback("archive directory", &config.Retention.ArchiveDirectory, path.Join(config.WorkingRoot, "archive"), logger)
+	if config.Retention.SweepIntervalHours <= 0 {
+		config.Retention.SweepIntervalHours = defaultSweepIntervalHours
+	}
+
 	// If we don't have a bot token, that's a fatal error...
 	if config.BotToken == "" {
 		logger.Fatal("no bot token found")
@@ -110,12 +143,53 @@ func main() {
 	logger.WithField("config", config).Debug("using config")
 
 	// Create components...
-	terpFactory := &fizmo.ExternalProcessFactory{
+	terpFactory := newInterpreterFactory(logBase)
+
+	gameRepo, err := newGameRepository(config, logBase)
+	if err != nil {
+		logger.WithError(err).Fatal("setting up game storage")
+	}
+
+	sessionStore := &sessions.Store{
+		Directory: path.Join(config.WorkingRoot, "sessions"),
+		Logger:    logBase,
+	}
+	defer func() {
+		err := sessionStore.Flush()
+		if err != nil {
+			logger.WithError(err).Warn("saving session activity")
+		}
+	}()
+
+	sweeper := &retention.Sweeper{
+		WorkingRoot:      config.WorkingRoot,
+		ArchiveDirectory: config.Retention.ArchiveDirectory,
+		Policy: retention.Policy{
+			MaxAge:     time.Duration(config.Retention.MaxAgeDays) * 24 * time.Hour,
+			RoomQuota:  int64(config.Retention.RoomQuotaMB) * 1024 * 1024,
+			TotalQuota: int64(config.Retention.TotalQuotaMB) * 1024 * 1024,
+		},
+		Ignore: []string{"sessions", "channels", "backups", "console"},
+		InUse: func(room string) bool {
+			session, err := sessionStore.Current(room)
+			return err != nil || session != nil
+		},
 		Logger: logBase,
 	}
 
-	gamesFS := &games.FileSys{
-		Directory: config.GameDirectory,
+	channelStore := &channels.Store{
+		Directory: path.Join(config.WorkingRoot, "channels"),
+		Logger:    logBase,
+	}
+
+	watcher := &games.Watcher{
+		Repository: gameRepo,
+		Logger:     logBase,
+	}
+
+	backupPlan := &backup.Plan{
+		Sources:   backupSources(config),
+		Directory: backupDirectory(config),
 		Logger:    logBase,
 	}
 
@@ -126,7 +200,7 @@ func main() {
 	if *consoleParam {
 		consoleConfig := &console.Config{
 			Logger:             logBase,
-			Games:              gamesFS,
+			Games:              gameRepo,
 			WorkingRoot:        config.WorkingRoot,
 			InterpreterFactory: terpFactory,
 		}
@@ -136,15 +210,20 @@ func main() {
 			logger.WithError(err).Error("starting console")
 			return
 		}
-		defer c.Disconnect()
+		defer c.Shutdown(shutdownTimeout)
 	} else {
 		botConfig := &slack.Config{
 			BotToken:           config.BotToken,
 			Admins:             config.Admins,
 			Logger:             logBase,
-			Games:              gamesFS,
+			Games:              gameRepo,
 			WorkingRoot:        config.WorkingRoot,
 			InterpreterFactory: terpFactory,
+			Sessions:           sessionStore,
+			Channels:           channelStore,
+			Retention:          sweeper,
+			Backup:             backupPlan,
+			AnnounceShutdown:   config.AnnounceShutdown,
 		}
 
 		manager, err := slack.StartManager(botConfig)
@@ -152,9 +231,19 @@ func main() {
 			logger.WithError(err).Error("starting slack manager")
 			return
 		}
-		defer manager.Disconnect()
+		defer manager.Shutdown(shutdownTimeout)
+
+		watcher.OnChange = manager.GamesChanged
+	}
+
+	if config.WatchSeconds > 0 {
+		watcher.Start(time.Duration(config.WatchSeconds) * time.Second)
+		defer watcher.Stop()
 	}
 
+	sweeper.Start(time.Duration(config.Retention.SweepIntervalHours) * time.Hour)
+	defer sweeper.Stop()
+
 	runUntilSignal(logger)
 
 	logger.Info("xyzzybot exiting")
@@ -217,11 +306,41 @@ func readTokenFile(tokenFile string, logger log.FieldLogger) (token string, err
 	return
 }
 
+// newInterpreterFactory creates the interpreter factory for all the story
+// formats we can play: Ink and Twine stories are played in-process, and
+// everything else (Z-code) by the external interpreter.
+func newInterpreterFactory(logBase log.FieldLogger) fizmo.InterpreterFactory {
+	return &fizmo.FormatFactory{
+		Default: &fizmo.ExternalProcessFactory{
+			Logger: logBase,
+		},
+		Formats: []*fizmo.Format{
+			{
+				Name:    fizmo.InkFormat,
+				Detect:  ink.IsStory,
+				Factory: &fizmo.InkFactory{Logger: logBase},
+			},
+			{
+				Name:    fizmo.TwineFormat,
+				Detect:  twine.IsStory,
+				Factory: &fizmo.TwineFactory{Logger: logBase},
+			},
+		},
+	}
+}
+
 func runUntilSignal(logger log.FieldLogger) {
 	// wait until signal....
 	q := make(chan os.Signal, 1)
 	signal.Notify(q, syscall.SIGHUP, syscall.SIGINT /*syscall.SIGPIPE,*/, syscall.SIGKILL, syscall.SIGTERM)
 	sig := <-q
 	logger.WithField("signal", sig).Info("got signal")
-	// os.Exit(1)
+
+	// Shutting down can take a while (games need to be saved), so a second
+	// signal means "stop *now*".
+	go func() {
+		sig := <-q
+		logger.WithField("signal", sig).Warn("got second signal, exiting immediately")
+		os.Exit(1)
+	}()
 }
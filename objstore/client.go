@@ -0,0 +1,303 @@
+package objstore
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object doesn't exist.
+var ErrNotFound = errors.New("object not found")
+
+// metadataPrefix is the header prefix S3 uses for user-defined metadata.
+const metadataPrefix = "X-Amz-Meta-"
+
+// defaultHTTPClient is used when the Client doesn't have one of its own.  (The
+// timeout is generous, since objects can be whole games.)
+var defaultHTTPClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Client is a minimal client for the S3 API: just enough to store, fetch,
+// list, and delete objects in a single bucket.  It works with AWS S3 and with
+// compatible servers like MinIO.
+type Client struct {
+	Endpoint  string // like "https://s3.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// VirtualHost puts the bucket name in the host name
+	// ("bucket.s3.amazonaws.com") rather than the path ("/bucket/key").
+	// Path-style is the default, since that's what MinIO expects.
+	VirtualHost bool
+
+	HTTPClient *http.Client
+}
+
+// ObjectInfo describes an object in the bucket.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string // without quotes
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string // user metadata, with lower-case keys
+}
+
+// Error is an error response from the server.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("object storage error: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("object storage error: %s (%s)", e.Code, e.Message)
+}
+
+func (c *Client) objectURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.VirtualHost {
+		u.Host = fmt.Sprintf("%s.%s", c.Bucket, u.Host)
+		u.Path = "/" + key
+	} else if key == "" {
+		u.Path = "/" + c.Bucket
+	} else {
+		u.Path = fmt.Sprintf("/%s/%s", c.Bucket, key)
+	}
+
+	// Setting RawPath makes sure the path is sent exactly as we sign it.
+	u.RawPath = canonicalURI(&url.URL{Path: u.Path})
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+func (c *Client) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := c.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for name, vals := range header {
+		req.Header[name] = vals
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	sign(req, payloadHash, c.AccessKey, c.SecretKey, c.region(), time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, readError(resp)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) region() string {
+	if c.Region == "" {
+		return "us-east-1"
+	}
+	return c.Region
+}
+
+func readError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(b, &body) == nil {
+		e.Code = body.Code
+		e.Message = body.Message
+	}
+	return e
+}
+
+// PutObject stores an object, with optional user metadata, returning its
+// ETag.
+func (c *Client) PutObject(key string, data []byte, contentType string, metadata map[string]string) (string, error) {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	for k, v := range metadata {
+		header.Set(metadataPrefix+k, v)
+	}
+
+	resp, err := c.do(http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// GetObject fetches an object and its metadata.
+func (c *Client) GetObject(key string) ([]byte, *ObjectInfo, error) {
+	resp, err := c.do(http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, objectInfo(key, resp), nil
+}
+
+// HeadObject fetches an object's metadata.
+func (c *Client) HeadObject(key string) (*ObjectInfo, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return objectInfo(key, resp), nil
+}
+
+// DeleteObject removes an object.  Deleting an object that doesn't exist is
+// not an error.
+func (c *Client) DeleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType: resp.Header.Get("Content-Type"),
+		Metadata:    make(map[string]string),
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = t
+	}
+	for name, vals := range resp.Header {
+		if strings.HasPrefix(name, metadataPrefix) && len(vals) > 0 {
+			info.Metadata[strings.ToLower(strings.TrimPrefix(name, metadataPrefix))] = vals[0]
+		}
+	}
+	return info
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		LastModified time.Time
+		ETag         string
+		Size         int64
+	}
+}
+
+// ListObjects lists all the objects whose keys start with prefix.  (Listing
+// doesn't include user metadata; use HeadObject for that.)
+func (c *Client) ListObjects(prefix string) ([]*ObjectInfo, error) {
+	objects := make([]*ObjectInfo, 0)
+	token := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unreadable object listing: %s", err.Error())
+		}
+
+		for _, o := range result.Contents {
+			objects = append(objects, &ObjectInfo{
+				Key:          o.Key,
+				Size:         o.Size,
+				ETag:         strings.Trim(o.ETag, `"`),
+				LastModified: o.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return objects, nil
+}
+
+// EnsureBucket creates the bucket if it doesn't already exist.
+func (c *Client) EnsureBucket() error {
+	resp, err := c.do(http.MethodHead, "", nil, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		return nil
+	}
+	if err != ErrNotFound {
+		return err
+	}
+
+	resp, err = c.do(http.MethodPut, "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
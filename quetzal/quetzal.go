@@ -0,0 +1,121 @@
+package quetzal
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/JaredReisinger/xyzzybot/iff"
+	"github.com/JaredReisinger/xyzzybot/zmachine"
+)
+
+// Quetzal is the standard Z-machine save file format: an IFF FORM of type
+// "IFZS".  The "IFhd" chunk identifies the story file the game was saved from,
+// which is all we really care about; the memory and stack chunks are left for
+// the interpreter.  See http://inform-fiction.org/zmachine/standards/quetzal/
+
+// Save contains the identifying information from a Quetzal save file.
+type Save struct {
+	Release    int
+	Serial     string
+	Checksum   uint16
+	PC         int
+	Compressed bool   // whether memory is stored as "CMem" (vs. "UMem")
+	Annotation string // optional "ANNO" chunk
+}
+
+// Parse reads a Quetzal save file.
+func Parse(r io.Reader) (*Save, error) {
+	form, err := iff.ReadForm(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromForm(form)
+}
+
+// ReadFile reads a Quetzal save file from disk.
+func ReadFile(file string) (*Save, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+func fromForm(form *iff.Form) (*Save, error) {
+	if form.Type != "IFZS" {
+		return nil, fmt.Errorf("IFF file of type %q is not a Quetzal save", form.Type)
+	}
+
+	ifhd := form.Chunk("IFhd")
+	if ifhd == nil {
+		return nil, fmt.Errorf("Quetzal save is missing its IFhd chunk")
+	}
+
+	d := ifhd.Data
+	if len(d) < 13 {
+		return nil, fmt.Errorf("Quetzal IFhd chunk is only %d bytes (expected 13)", len(d))
+	}
+
+	save := &Save{
+		Release:    int(d[0])<<8 | int(d[1]),
+		Serial:     string(d[2:8]),
+		Checksum:   uint16(d[8])<<8 | uint16(d[9]),
+		PC:         int(d[10])<<16 | int(d[11])<<8 | int(d[12]),
+		Compressed: form.Chunk("CMem") != nil,
+	}
+
+	if anno := form.Chunk("ANNO"); anno != nil {
+		save.Annotation = string(anno.Data)
+	}
+
+	return save, nil
+}
+
+// String returns a human-friendly description like "release 2 / 080406".
+func (s *Save) String() string {
+	return fmt.Sprintf("release %d / %s", s.Release, s.Serial)
+}
+
+// Matches reports whether the save was made from the story with the given
+// header.
+func (s *Save) Matches(h *zmachine.Header) bool {
+	return s.Release == h.Release &&
+		s.Serial == h.Serial &&
+		s.Checksum == h.Checksum
+}
+
+// CheckCompatible returns an error explaining why the save cannot be restored
+// into the story with the given header, or nil if it can.
+func (s *Save) CheckCompatible(h *zmachine.Header) error {
+	switch {
+	case s.Release != h.Release || s.Serial != h.Serial:
+		return &MismatchError{
+			Reason: fmt.Sprintf("it was saved from %s, but the game is %s", s, h),
+		}
+
+	case s.Checksum != h.Checksum:
+		return &MismatchError{
+			Reason: fmt.Sprintf("it was saved from a story with checksum 0x%04X, but the game’s checksum is 0x%04X (the story file may have been modified)", s.Checksum, h.Checksum),
+		}
+
+	case int64(s.PC) >= h.ActualLength:
+		return &MismatchError{
+			Reason: fmt.Sprintf("its program counter (0x%06x) is past the end of the story", s.PC),
+		}
+	}
+
+	return nil
+}
+
+// MismatchError describes why a save is not compatible with a story.
+type MismatchError struct {
+	Reason string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("save file does not belong to this game: %s", e.Reason)
+}
@@ -0,0 +1,145 @@
+package quetzal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path"
+	"testing"
+
+	"github.com/JaredReisinger/xyzzybot/zmachine"
+)
+
+type chunk struct {
+	id   string
+	data []byte
+}
+
+// form builds an IFF FORM.
+func form(formType string, chunks ...chunk) []byte {
+	body := []byte(formType)
+	for _, c := range chunks {
+		body = append(body, c.id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(c.data)))
+		body = append(body, c.data...)
+		if len(c.data)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+
+	b := []byte("FORM")
+	b = binary.BigEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// ifhd builds the IFhd chunk that identifies the story with the given header.
+func ifhd(h *zmachine.Header, pc int) chunk {
+	data := []byte{byte(h.Release >> 8), byte(h.Release)}
+	data = append(data, h.Serial...)
+	data = append(data, byte(h.Checksum>>8), byte(h.Checksum))
+	data = append(data, byte(pc>>16), byte(pc>>8), byte(pc))
+	return chunk{"IFhd", data}
+}
+
+// saveFor builds a Quetzal save from the story with the given header.
+func saveFor(h *zmachine.Header, pc int, memory string) []byte {
+	return form("IFZS",
+		ifhd(h, pc),
+		chunk{memory, []byte{0, 1, 2}},
+		chunk{"ANNO", []byte("saved by a test")})
+}
+
+func readHeader(t *testing.T, game string) *zmachine.Header {
+	t.Helper()
+	h, err := zmachine.ReadHeader(path.Join("..", "sample-games", game))
+	if err != nil {
+		t.Fatalf("reading %s: %v", game, err)
+	}
+	return h
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, game := range []string{"curses.z5", "dreamhold.z8", "LostPig.zblorb"} {
+		h := readHeader(t, game)
+
+		for _, memory := range []string{"CMem", "UMem"} {
+			save, err := Parse(bytes.NewReader(saveFor(h, h.InitialPC, memory)))
+			if err != nil {
+				t.Errorf("%s: %v", game, err)
+				continue
+			}
+
+			if save.Release != h.Release || save.Serial != h.Serial || save.Checksum != h.Checksum || save.PC != h.InitialPC {
+				t.Errorf("%s: got %+v from a save of %s", game, save, h)
+			}
+			if save.Compressed != (memory == "CMem") {
+				t.Errorf("%s: %s save has Compressed = %v", game, memory, save.Compressed)
+			}
+			if save.Annotation != "saved by a test" {
+				t.Errorf("%s: got annotation %q", game, save.Annotation)
+			}
+			if !save.Matches(h) {
+				t.Errorf("%s: save doesn’t match its own game", game)
+			}
+			if err := save.CheckCompatible(h); err != nil {
+				t.Errorf("%s: %v", game, err)
+			}
+		}
+	}
+}
+
+func TestCheckCompatible(t *testing.T) {
+	curses := readHeader(t, "curses.z5")
+	dreamhold := readHeader(t, "dreamhold.z8")
+
+	modified := *curses
+	modified.Checksum++
+
+	tests := []struct {
+		name string
+		save *zmachine.Header // the game the save was made from
+		pc   int
+		game *zmachine.Header // the game it's restored into
+	}{
+		{"different game", curses, curses.InitialPC, dreamhold},
+		{"modified story", &modified, curses.InitialPC, curses},
+		{"program counter past the end", curses, int(curses.ActualLength), curses},
+	}
+
+	for _, test := range tests {
+		save, err := Parse(bytes.NewReader(saveFor(test.save, test.pc, "CMem")))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		err = save.CheckCompatible(test.game)
+		if _, ok := err.(*MismatchError); !ok {
+			t.Errorf("%s: expected a MismatchError, got %v", test.name, err)
+		}
+	}
+}
+
+func TestParseDamaged(t *testing.T) {
+	h := readHeader(t, "curses.z5")
+	good := saveFor(h, 0x1234, "CMem")
+	short := ifhd(h, 0x1234)
+	short.data = short.data[:12]
+
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"empty", nil},
+		{"not a save", form("IFRS", ifhd(h, 0x1234))},
+		{"missing IFhd", form("IFZS", chunk{"CMem", []byte{0}})},
+		{"short IFhd", form("IFZS", short)},
+		{"truncated", good[:20]},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(bytes.NewReader(test.b))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
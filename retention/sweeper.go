@@ -0,0 +1,456 @@
+package retention
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+	"github.com/JaredReisinger/xyzzybot/iff"
+)
+
+// Kind classifies the files found in a room's working directory.
+type Kind int
+
+// Values of Kind...
+const (
+	OtherKind Kind = iota
+	SaveKind
+	TranscriptKind
+	SnapshotKind
+)
+
+func (k Kind) String() string {
+	switch k {
+	case SaveKind:
+		return "save"
+	case TranscriptKind:
+		return "transcript"
+	case SnapshotKind:
+		return "snapshot"
+	}
+
+	return "other"
+}
+
+// Policy describes how long files are kept, and how much space they may use.
+// Zero values mean "no limit".
+type Policy struct {
+	MaxAge     time.Duration
+	RoomQuota  int64 // bytes
+	TotalQuota int64 // bytes
+}
+
+// FileUsage describes a single file in a room's working directory.
+type FileUsage struct {
+	Room    string
+	Name    string
+	Path    string
+	Kind    Kind
+	Size    int64
+	ModTime time.Time
+}
+
+// RoomUsage describes the disk use of a single room's working directory.
+type RoomUsage struct {
+	Room   string
+	Files  []*FileUsage // oldest first
+	Size   int64
+	InUse  bool
+	Counts map[Kind]int
+}
+
+// Result summarizes what a sweep did.
+type Result struct {
+	Expired  int // files removed for being too old
+	Evicted  int // files removed to get under quota
+	Freed    int64
+	Archives []string
+}
+
+// Sweeper applies a retention Policy to the per-room working directories
+// under WorkingRoot.  Files are archived (as a .tar.gz per room, per sweep)
+// into ArchiveDirectory before they are deleted.
+type Sweeper struct {
+	WorkingRoot      string
+	ArchiveDirectory string
+	Policy           Policy
+	Ignore           []string               // non-room directories in WorkingRoot
+	InUse            func(room string) bool // rooms with a game in progress are left alone
+	Logger           log.FieldLogger
+
+	mutex sync.Mutex
+	quit  chan bool
+}
+
+// Start runs a sweep immediately, and then every interval until Stop is
+// called.
+func (s *Sweeper) Start(interval time.Duration) {
+	s.quit = make(chan bool)
+	logger := s.logger()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			result, err := s.Sweep()
+			if err != nil {
+				logger.WithError(err).Error("sweeping working directories")
+			} else {
+				logger.WithFields(log.Fields{
+					"expired": result.Expired,
+					"evicted": result.Evicted,
+					"freed":   result.Freed,
+				}).Info("swept working directories")
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops periodic sweeping.
+func (s *Sweeper) Stop() {
+	if s.quit != nil {
+		close(s.quit)
+	}
+}
+
+func (s *Sweeper) logger() log.FieldLogger {
+	return s.Logger.WithField("component", "retention")
+}
+
+// Usage reports the disk use of every room's working directory, largest
+// first.
+func (s *Sweeper) Usage() ([]*RoomUsage, error) {
+	infos, err := ioutil.ReadDir(s.WorkingRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]*RoomUsage, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() || s.ignored(info.Name()) {
+			continue
+		}
+
+		room, err := s.roomUsage(info.Name())
+		if err != nil {
+			s.logger().WithField("room", info.Name()).WithError(err).Warn("skipping unreadable room directory")
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Size > rooms[j].Size
+	})
+
+	return rooms, nil
+}
+
+// ArchiveSize returns the total size of the archives that have been written.
+func (s *Sweeper) ArchiveSize() (int64, error) {
+	infos, err := ioutil.ReadDir(s.ArchiveDirectory)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
+func (s *Sweeper) ignored(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	if path.Dir(path.Clean(s.ArchiveDirectory)) == path.Clean(s.WorkingRoot) &&
+		path.Base(s.ArchiveDirectory) == name {
+		return true
+	}
+
+	for _, i := range s.Ignore {
+		if i == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Sweeper) roomUsage(room string) (*RoomUsage, error) {
+	dir := path.Join(s.WorkingRoot, room)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &RoomUsage{
+		Room:   room,
+		Files:  make([]*FileUsage, 0, len(infos)),
+		InUse:  s.InUse != nil && s.InUse(room),
+		Counts: make(map[Kind]int),
+	}
+
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		file := path.Join(dir, info.Name())
+		kind := classify(file, info.Name())
+		usage.Files = append(usage.Files, &FileUsage{
+			Room:    room,
+			Name:    info.Name(),
+			Path:    file,
+			Kind:    kind,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		usage.Size += info.Size()
+		usage.Counts[kind]++
+	}
+
+	sort.Slice(usage.Files, func(i, j int) bool {
+		return usage.Files[i].ModTime.Before(usage.Files[j].ModTime)
+	})
+
+	return usage, nil
+}
+
+// classify guesses what kind of file this is.  Saves are recognized by their
+// content (Quetzal) or extension (state saves), the rest by name.
+func classify(file string, name string) Kind {
+	lower := strings.ToLower(name)
+	ext := path.Ext(lower)
+
+	switch {
+	case isQuetzal(file), ext == fizmo.StateSaveExtension:
+		return SaveKind
+	case ext == ".txt" || ext == ".log" || strings.Contains(lower, "transcript") || strings.Contains(lower, "script"):
+		return TranscriptKind
+	case ext == ".glksave" || ext == ".sav" || ext == ".qzl" || strings.Contains(lower, "snapshot") || strings.Contains(lower, "autosave"):
+		return SnapshotKind
+	}
+
+	return OtherKind
+}
+
+func isQuetzal(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	b := make([]byte, 12)
+	_, err = io.ReadFull(f, b)
+	return err == nil && iff.IsForm(b, "IFZS")
+}
+
+// Sweep applies the policy once: expiring old files, and then evicting the
+// oldest files from rooms (and overall) that are over quota.  Rooms with a
+// game in progress are never touched, although they count toward the total.
+func (s *Sweeper) Sweep() (*Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rooms, err := s.Usage()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	doomed := make(map[string][]*FileUsage)
+	now := time.Now()
+	var total int64
+
+	for _, room := range rooms {
+		total += room.Size
+		if room.InUse {
+			continue
+		}
+
+		kept := make([]*FileUsage, 0, len(room.Files))
+		for _, f := range room.Files {
+			if s.Policy.MaxAge > 0 && now.Sub(f.ModTime) > s.Policy.MaxAge {
+				doomed[room.Room] = append(doomed[room.Room], f)
+				room.Size -= f.Size
+				total -= f.Size
+				result.Expired++
+				continue
+			}
+			kept = append(kept, f)
+		}
+
+		// Files are oldest-first, so evicting from the front removes the
+		// oldest.
+		for s.Policy.RoomQuota > 0 && room.Size > s.Policy.RoomQuota && len(kept) > 0 {
+			f := kept[0]
+			kept = kept[1:]
+			doomed[room.Room] = append(doomed[room.Room], f)
+			room.Size -= f.Size
+			total -= f.Size
+			result.Evicted++
+		}
+
+		room.Files = kept
+	}
+
+	if s.Policy.TotalQuota > 0 && total > s.Policy.TotalQuota {
+		candidates := make([]*FileUsage, 0)
+		for _, room := range rooms {
+			if !room.InUse {
+				candidates = append(candidates, room.Files...)
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return candidates[i].ModTime.Before(candidates[j].ModTime)
+		})
+
+		for _, f := range candidates {
+			if total <= s.Policy.TotalQuota {
+				break
+			}
+			doomed[f.Room] = append(doomed[f.Room], f)
+			total -= f.Size
+			result.Evicted++
+		}
+
+		if total > s.Policy.TotalQuota {
+			s.logger().WithField("total", total).Warn("still over total quota (games in progress?)")
+		}
+	}
+
+	for room, files := range doomed {
+		archive, err := s.archive(room, files, now)
+		if err != nil {
+			// If we can't archive, we don't delete.
+			s.logger().WithField("room", room).WithError(err).Error("archiving files, not deleting them")
+			continue
+		}
+		result.Archives = append(result.Archives, archive)
+
+		for _, f := range files {
+			err = os.Remove(f.Path)
+			if err != nil {
+				s.logger().WithField("file", f.Path).WithError(err).Error("deleting file")
+				continue
+			}
+			result.Freed += f.Size
+		}
+
+		// Remove the room directory entirely if it's now empty; it gets
+		// re-created when a game starts.
+		dir := path.Join(s.WorkingRoot, room)
+		if remaining, err := ioutil.ReadDir(dir); err == nil && len(remaining) == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return result, nil
+}
+
+// archive writes the files to a single .tar.gz in the archive directory.
+func (s *Sweeper) archive(room string, files []*FileUsage, now time.Time) (string, error) {
+	err := os.MkdirAll(s.ArchiveDirectory, os.FileMode(0755))
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Join(s.ArchiveDirectory, fmt.Sprintf("%s-%s.tar.gz", room, now.Format("20060102-150405")))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeArchive(f, room, files)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+
+	s.logger().WithFields(log.Fields{
+		"room":    room,
+		"files":   len(files),
+		"archive": name,
+	}).Info("archived files")
+
+	return name, nil
+}
+
+func writeArchive(w io.Writer, room string, files []*FileUsage) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range files {
+		err := addFile(tw, path.Join(room, f.Name), f.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, name string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,361 @@
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// We keep at most this many past sessions per room; older ones are dropped
+// when a new session begins.
+const maxHistory = 50
+
+// Turns and statuses are recorded with every command and every response, so
+// they're only written out this often (and whenever a session begins or ends,
+// or the store is flushed).
+const activityFlushInterval = 30 * time.Second
+
+// Session records the metadata for a single game session in a room.
+type Session struct {
+	Room         string
+	RoomName     string
+	Game         string
+	IFID         string // stable identity of the game, even if it's renamed
+	Version      int    // the version of the game's file being played
+	StartedBy    string
+	StartTime    time.Time
+	Turns        int
+	LastActivity time.Time
+	LastStatus   string
+	EndTime      time.Time
+	EndReason    string `json:",omitempty"`
+}
+
+// Active reports whether the session is still in progress.
+func (s *Session) Active() bool {
+	return s.EndTime.IsZero()
+}
+
+// copy returns a copy of the session, so that callers don't see it change
+// under them.
+func (s *Session) copy() *Session {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+// roomHistory is what's persisted for each room: the sessions, oldest first.
+// The last entry is the current session if it hasn't ended.
+type roomHistory struct {
+	Sessions []*Session
+}
+
+// Store is an embedded, file-based store of session metadata.  Each room's
+// sessions are kept in their own JSON file in the store's directory, so that
+// they survive restarts.  The histories are kept in memory too, once they've
+// been read.
+type Store struct {
+	Directory string
+	Logger    log.FieldLogger
+
+	mutex   sync.Mutex
+	cache   map[string]*roomHistory
+	saved   map[string]time.Time // when each room's history was last written
+	unsaved map[string]bool      // rooms with activity that hasn't been written
+}
+
+// Begin starts a new session in the given room.  If there is already an
+// active session (say, because the bot was restarted mid-game), it's ended
+// first.
+func (s *Store) Begin(room string, roomName string, game string, ifid string, version int, user string) (*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if cur := h.current(); cur != nil {
+		cur.EndTime = now
+		cur.EndReason = "superseded by a new game"
+	}
+
+	session := &Session{
+		Room:         room,
+		RoomName:     roomName,
+		Game:         game,
+		IFID:         ifid,
+		Version:      version,
+		StartedBy:    user,
+		StartTime:    now,
+		LastActivity: now,
+	}
+
+	h.Sessions = append(h.Sessions, session)
+	if len(h.Sessions) > maxHistory {
+		h.Sessions = h.Sessions[len(h.Sessions)-maxHistory:]
+	}
+
+	err = s.save(room, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.copy(), nil
+}
+
+// Update applies fn to the room's active session, if there is one, and
+// persists the result.
+func (s *Store) Update(room string, fn func(session *Session)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return err
+	}
+
+	cur := h.current()
+	if cur == nil {
+		return nil
+	}
+
+	fn(cur)
+	return s.save(room, h)
+}
+
+// RecordTurn notes that a command was sent to the room's active game.
+func (s *Store) RecordTurn(room string) error {
+	return s.recordActivity(room, func(session *Session) {
+		session.Turns++
+		session.LastActivity = time.Now()
+	})
+}
+
+// RecordStatus notes the most recent status line from the room's active game.
+func (s *Store) RecordStatus(room string, status string) error {
+	return s.recordActivity(room, func(session *Session) {
+		session.LastStatus = status
+		session.LastActivity = time.Now()
+	})
+}
+
+// recordActivity is like Update, but only writes the history out if it
+// hasn't been for a while (see activityFlushInterval).
+func (s *Store) recordActivity(room string, fn func(session *Session)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return err
+	}
+
+	cur := h.current()
+	if cur == nil {
+		return nil
+	}
+
+	fn(cur)
+	if time.Since(s.saved[room]) < activityFlushInterval {
+		s.unsaved[room] = true
+		return nil
+	}
+	return s.save(room, h)
+}
+
+// Flush writes out any activity that hasn't been yet.
+func (s *Store) Flush() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var firstErr error
+	for room := range s.unsaved {
+		err := s.save(room, s.cache[room])
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// End marks the room's active session as finished, for the given reason.
+func (s *Store) End(room string, reason string) error {
+	return s.Update(room, func(session *Session) {
+		session.EndTime = time.Now()
+		session.EndReason = reason
+	})
+}
+
+// Current returns the room's active session, or nil if there isn't one.
+func (s *Store) Current(room string) (*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.current().copy(), nil
+}
+
+// Latest returns the room's most recent session (active or not), or nil if
+// there has never been one.
+func (s *Store) Latest(room string) (*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.latest().copy(), nil
+}
+
+// History returns all of the room's recorded sessions, oldest first.
+func (s *Store) History(room string) ([]*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	h, err := s.load(room)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, len(h.Sessions))
+	for i, session := range h.Sessions {
+		sessions[i] = session.copy()
+	}
+	return sessions, nil
+}
+
+// All returns the most recent session for every room that has one, most
+// recently active first.
+func (s *Store) All() ([]*Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	infos, err := ioutil.ReadDir(s.Directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]*Session, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || path.Ext(name) != ".json" {
+			continue
+		}
+
+		h, err := s.load(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			s.Logger.WithField("file", name).WithError(err).Warn("skipping unreadable session file")
+			continue
+		}
+
+		if latest := h.latest(); latest != nil {
+			all = append(all, latest.copy())
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastActivity.After(all[j].LastActivity)
+	})
+
+	return all, nil
+}
+
+func (h *roomHistory) latest() *Session {
+	if len(h.Sessions) == 0 {
+		return nil
+	}
+	return h.Sessions[len(h.Sessions)-1]
+}
+
+func (h *roomHistory) current() *Session {
+	latest := h.latest()
+	if latest == nil || !latest.Active() {
+		return nil
+	}
+	return latest
+}
+
+func (s *Store) roomFile(room string) string {
+	return path.Join(s.Directory, fmt.Sprintf("%s.json", room))
+}
+
+// load returns the room's history, reading it if it isn't already in memory.
+// The caller must hold the mutex.
+func (s *Store) load(room string) (*roomHistory, error) {
+	if h, ok := s.cache[room]; ok {
+		return h, nil
+	}
+
+	h := &roomHistory{}
+
+	b, err := ioutil.ReadFile(s.roomFile(room))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		err = json.Unmarshal(b, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.cache == nil {
+		s.cache = make(map[string]*roomHistory)
+		s.saved = make(map[string]time.Time)
+		s.unsaved = make(map[string]bool)
+	}
+	s.cache[room] = h
+	return h, nil
+}
+
+func (s *Store) save(room string, h *roomHistory) error {
+	err := os.MkdirAll(s.Directory, os.FileMode(0755))
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename, so that a crash mid-write never
+	// leaves a truncated history behind.
+	file := s.roomFile(room)
+	tmp := fmt.Sprintf("%s.tmp", file)
+	err = ioutil.WriteFile(tmp, b, os.FileMode(0644))
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, file)
+	if err != nil {
+		return err
+	}
+
+	s.saved[room] = time.Now()
+	delete(s.unsaved, room)
+	return nil
+}
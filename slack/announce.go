@@ -0,0 +1,138 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/channels"
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// Long descriptions are cut down to something that fits in an announcement.
+const maxBlurbLength = 300
+
+func (r *Room) commandAnnounce(cmdContext *commandContext, command string, args ...string) {
+	if r.manager.config.Channels == nil {
+		r.sendMessage("I’m not able to keep track of channel settings right now.")
+		return
+	}
+
+	if len(args) == 0 {
+		settings, err := r.manager.config.Channels.Get(r.ID)
+		if err != nil {
+			r.logger.WithError(err).Error("getting channel settings")
+			r.sendMessage(fmt.Sprintf("I couldn’t check this channel’s settings: %s", err.Error()))
+			return
+		}
+
+		if settings.AnnounceGames {
+			r.sendMessage("I’ll announce new games here when they arrive.  Tell me *announce off* if you’d rather I didn’t.")
+		} else {
+			r.sendMessage("I don’t announce new games here.  Tell me *announce on* if you’d like me to.")
+		}
+		return
+	}
+
+	var announce bool
+	switch strings.ToLower(args[0]) {
+	case "on", "yes":
+		announce = true
+	case "off", "no":
+		announce = false
+	default:
+		r.sendMessage("You can tell me *announce on* or *announce off*.")
+		return
+	}
+
+	err := r.manager.config.Channels.Update(r.ID, func(settings *channels.Settings) {
+		settings.AnnounceGames = announce
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("updating channel settings")
+		r.sendMessage(fmt.Sprintf("I couldn’t change this channel’s settings: %s", err.Error()))
+		return
+	}
+
+	if announce {
+		r.sendMessage("Okay, I’ll announce new games here when they arrive.")
+	} else {
+		r.sendMessage("Okay, I won’t announce new games here.")
+	}
+}
+
+// GamesChanged is called (by a games.Watcher) when the games in the
+// repository change; new games are announced in every channel that has asked
+// for it, and new builds are reloaded in author-mode channels.
+func (manager *Manager) GamesChanged(changes *games.Changes) {
+	manager.reloadAuthorGames(changes.Changed)
+
+	if manager.config.Channels == nil {
+		return
+	}
+
+	added := make([]*games.GameInfo, 0, len(changes.Added))
+	for _, game := range changes.Added {
+		if game.Playable() {
+			added = append(added, game)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	all, err := manager.config.Channels.All()
+	if err != nil {
+		manager.logger.WithError(err).Error("getting channel settings")
+		return
+	}
+
+	for _, settings := range all {
+		if !settings.AnnounceGames {
+			continue
+		}
+
+		// We can only post in rooms we're (still) in.
+		if _, ok := manager.getRoom(settings.Room); !ok {
+			continue
+		}
+
+		for _, game := range added {
+			if ok, _ := settings.Permits(game); ok {
+				manager.sendMessage(settings.Room, formatAnnouncement(game))
+			}
+		}
+	}
+}
+
+func formatAnnouncement(game *games.GameInfo) string {
+	title := fmt.Sprintf("*%s*", game.DisplayName)
+	if game.Author() != "" {
+		title = fmt.Sprintf("%s by %s", title, game.Author())
+	}
+
+	text := fmt.Sprintf("A new game has arrived: %s!", title)
+	if blurb := gameBlurb(game); blurb != "" {
+		text = fmt.Sprintf("%s\n_%s_", text, blurb)
+	}
+
+	return fmt.Sprintf("%s\nTell me *play %s* to start it.", text, game.Name)
+}
+
+// gameBlurb returns the game's headline or (shortened) description, if it has
+// either.
+func gameBlurb(game *games.GameInfo) string {
+	meta := game.Bibliographic()
+	blurb := meta.Description
+	if blurb == "" {
+		return meta.Headline
+	}
+
+	if len(blurb) > maxBlurbLength {
+		cut := strings.LastIndex(blurb[:maxBlurbLength], " ")
+		if cut <= 0 {
+			cut = maxBlurbLength
+		}
+		blurb = blurb[:cut] + "…"
+	}
+	return blurb
+}
@@ -0,0 +1,261 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JaredReisinger/xyzzybot/channels"
+	"github.com/JaredReisinger/xyzzybot/diff"
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// In author mode, a room replays its game's history whenever a new build of
+// the game arrives.  We keep at most this many turns of history...
+const maxReplayTurns = 1000
+
+// ...and wait this long for the game to respond to each replayed command.
+const replayTimeout = 5 * time.Second
+
+// The most lines of a diff we show when the replay diverges.
+const maxDiffLines = 30
+
+// gameTurn is a command sent to the game, and what the game said in response.
+// The first turn of a game is its opening, with no command.
+type gameTurn struct {
+	command string
+	output  string
+}
+
+// gameReplay is a replay in progress.
+type gameReplay struct {
+	output  *fizmo.Output // the last output, shown once the replay is done
+	stopped string        // why the replay was stopped, if it was
+}
+
+func (r *Room) commandAuthor(cmdContext *commandContext, command string, args ...string) {
+	if r.manager.config.Channels == nil {
+		r.sendMessage("I’m not able to keep track of channel settings right now.")
+		return
+	}
+
+	if len(args) == 0 {
+		if r.settings().AuthorMode {
+			r.sendMessage("This channel is in author mode: when a new build of the game being played here arrives, I’ll restart it and replay everything so far.  Tell me *author off* to stop.")
+		} else {
+			r.sendMessage("This channel isn’t in author mode.  Tell me *author on* if you’d like me to restart the game and replay everything so far whenever a new build of it arrives.")
+		}
+		return
+	}
+
+	var author bool
+	switch strings.ToLower(args[0]) {
+	case "on", "yes":
+		author = true
+	case "off", "no":
+		author = false
+	default:
+		r.sendMessage("You can tell me *author on* or *author off*.")
+		return
+	}
+
+	err := r.manager.config.Channels.Update(r.ID, func(settings *channels.Settings) {
+		settings.AuthorMode = author
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("updating channel settings")
+		r.sendMessage(fmt.Sprintf("I couldn’t change this channel’s settings: %s", err.Error()))
+		return
+	}
+
+	if author {
+		r.sendMessage("Okay, this channel is in author mode: when a new build of the game arrives (uploaded, or dropped into my game directory), I’ll restart it and replay everything so far.")
+	} else {
+		r.sendMessage("Okay, this channel isn’t in author mode any more.")
+	}
+}
+
+// reloadAuthorGames restarts the games in author-mode rooms that are playing
+// an older version of one of the given games.
+func (manager *Manager) reloadAuthorGames(updated []*games.GameInfo) {
+	if manager.config.Channels == nil || len(updated) == 0 {
+		return
+	}
+
+	for _, r := range manager.allRooms() {
+		// The room's session says what it's playing (see Room.mutex).
+		session, err := manager.config.Sessions.Current(r.ID)
+		if err != nil || session == nil {
+			continue
+		}
+
+		for _, game := range updated {
+			if game.Name != session.Game {
+				continue
+			}
+
+			_, current, err := manager.config.Games.GetGameVersionFile(game.FileName, "")
+			if err != nil || current.Number == session.Version {
+				break
+			}
+			if r.settings().AuthorMode {
+				go r.reloadGame(game.Name, current.Number)
+			}
+			break
+		}
+	}
+}
+
+// recordOutput adds the game's output to the current turn of the history.
+func (r *Room) recordOutput(output *fizmo.Output) {
+	if len(r.history) == 0 {
+		r.history = append(r.history, &gameTurn{})
+	}
+	turn := r.history[len(r.history)-1]
+	turn.output = joinOutput(turn.output, fizmo.StoryText(output.Story))
+}
+
+// recordCommand starts a new turn of the history.
+func (r *Room) recordCommand(command string) {
+	if len(r.history) >= maxReplayTurns {
+		return
+	}
+	r.history = append(r.history, &gameTurn{command: command})
+}
+
+func joinOutput(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "\n" + b
+}
+
+// reloadGame restarts the in-progress game on a new version of its file,
+// replays the commands sent so far, and reports where (if anywhere) the game
+// responded differently than it did before.  The room is only locked while
+// each command is sent, so the replay can be stopped with *!kill*.
+func (r *Room) reloadGame(game string, version int) {
+	r.mutex.Lock()
+	if !r.gameInProgress() || r.replay != nil || r.game != game || r.gameVersion == version {
+		// It's already been reloaded (after an upload, say, before the
+		// watcher noticed), or something else is being played now.
+		r.mutex.Unlock()
+		return
+	}
+
+	history := r.history
+	if len(history) == 0 {
+		history = []*gameTurn{&gameTurn{}}
+	}
+	user := ""
+	if session, err := r.config.Sessions.Current(r.ID); err == nil && session != nil {
+		user = session.StartedBy
+	}
+
+	r.logger.WithField("turns", len(history)).Info("reloading game")
+	r.sendMessage(fmt.Sprintf("A new build of _%s_ has arrived!  I’m restarting it and replaying the %s so far…", game, pluralize(len(history)-1, "command")))
+
+	r.killGame("reloading a new build")
+	replay := &gameReplay{}
+	r.replay = replay
+	r.drainOutputSignal()
+	err := r.startGame(game, user)
+	if err != nil {
+		r.replay = nil
+		r.mutex.Unlock()
+		r.sendMessage(fmt.Sprintf("I’m sorry, I wasn’t able to restart _%s_: %s", game, err.Error()))
+		return
+	}
+	r.mutex.Unlock()
+	r.waitForOutput()
+
+	for _, turn := range history[1:] {
+		r.mutex.Lock()
+		if replay.stopped != "" || r.manager.shuttingDown() {
+			r.mutex.Unlock()
+			break
+		}
+		r.drainOutputSignal()
+		r.sendToGame(turn.command)
+		r.mutex.Unlock()
+		r.waitForOutput()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch {
+	case replay.stopped != "":
+		r.sendMessage(fmt.Sprintf("I stopped replaying _%s_: %s.", game, replay.stopped))
+		return
+	case r.manager.shuttingDown():
+		r.replay = nil
+		return
+	}
+
+	r.replay = nil
+	r.sendMessage(formatReplay(game, r.gameVersion, history, r.history))
+	if replay.output != nil {
+		r.sendOutputMessage(replay.output)
+	}
+}
+
+func (r *Room) drainOutputSignal() {
+	select {
+	case <-r.outputSignal:
+	default:
+	}
+}
+
+// waitForOutput waits (up to replayTimeout) for the game to respond.
+func (r *Room) waitForOutput() {
+	select {
+	case <-r.outputSignal:
+	case <-time.After(replayTimeout):
+		r.logger.Warn("timed out waiting for game to respond")
+	}
+}
+
+// formatReplay reports the first turn at which the replayed game responded
+// differently.  The opening isn't compared, since it usually includes the
+// build's release and serial number.
+func formatReplay(game string, version int, before []*gameTurn, after []*gameTurn) string {
+	summary := fmt.Sprintf("I’ve restarted _%s_ on version %d and replayed %s", game, version, pluralize(len(before)-1, "command"))
+
+	for i := 1; i < len(before); i++ {
+		if i >= len(after) {
+			return fmt.Sprintf("%s, but the game stopped responding after command %d.", summary, i-1)
+		}
+
+		lines := diff.Lines(before[i].output, after[i].output)
+		if !diff.Changed(lines) {
+			continue
+		}
+
+		// When commands are sent in quick succession (like "restore" and the
+		// save name), the game's response to one can be recorded with the
+		// next; if the two turns agree taken together, they're the same.
+		if i+1 < len(before) && i+1 < len(after) &&
+			joinOutput(before[i].output, before[i+1].output) == joinOutput(after[i].output, after[i+1].output) {
+			i++
+			continue
+		}
+
+		changes := strings.Split(diff.Changes(lines, 1), "\n")
+		if len(changes) > maxDiffLines {
+			changes = append(changes[:maxDiffLines], "…")
+		}
+		return fmt.Sprintf("%s.  The game first responded differently to command %d, *%s*:\n```\n%s\n```",
+			summary, i, before[i].command, strings.Join(changes, "\n"))
+	}
+
+	return fmt.Sprintf("%s, and the game responded to each of them just as it did before.", summary)
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
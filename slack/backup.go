@@ -0,0 +1,60 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (r *Room) commandBackup(cmdContext *commandContext, command string, args ...string) {
+	if r.config.Backup == nil {
+		r.sendMessage("I’m not set up to make backups.")
+		return
+	}
+
+	if !r.manager.startBackup() {
+		r.sendMessage("There’s already a backup under way; I’ll let its channel know when it’s done.")
+		return
+	}
+
+	r.sendMessage("Starting a backup… I’ll let you know when it’s done.")
+
+	// Writing the archive can take a while, so it's done in the background.
+	// Games in progress are left alone: their saves are backed up as they
+	// are.
+	go func() {
+		defer r.manager.finishBackup()
+
+		file, manifest, err := r.config.Backup.Run()
+		if err != nil {
+			r.logger.WithError(err).Error("creating backup")
+			r.sendMessage(fmt.Sprintf("I wasn’t able to create the backup... %s", err.Error()))
+			return
+		}
+
+		parts := make([]string, 0, len(manifest.Sections))
+		for _, s := range manifest.Sections {
+			parts = append(parts, fmt.Sprintf("%s: %d files (%s)", s.Name, s.Files, formatBytes(s.Bytes)))
+		}
+
+		r.sendMessage(fmt.Sprintf("Backup complete!  I wrote `%s` — %s.", file, strings.Join(parts, "; ")))
+	}()
+}
+
+// startBackup notes that a backup is under way, unless one already is.
+func (manager *Manager) startBackup() bool {
+	manager.backupMutex.Lock()
+	defer manager.backupMutex.Unlock()
+
+	if manager.backingUp {
+		return false
+	}
+	manager.backingUp = true
+	return true
+}
+
+func (manager *Manager) finishBackup() {
+	manager.backupMutex.Lock()
+	defer manager.backupMutex.Unlock()
+
+	manager.backingUp = false
+}
@@ -0,0 +1,72 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+)
+
+// Slack's RTM API doesn't give us buttons, so choice-based games offer their
+// choices as numbered reactions on the message: clicking one makes that
+// choice.  (Typing the number works too.)
+var choiceReactions = []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine"}
+
+func formatChoices(choices []*fizmo.Choice) string {
+	lines := make([]string, len(choices))
+	for i, c := range choices {
+		lines[i] = fmt.Sprintf("     *%d.* %s", c.Number, c.Text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// offerChoices adds the choice reactions to the message offering them.
+func (r *Room) offerChoices(timestamp string, choices []*fizmo.Choice) {
+	r.choiceMessage = ""
+	if timestamp == "" || len(choices) > len(choiceReactions) {
+		return
+	}
+
+	r.choiceMessage = timestamp
+	r.choiceCount = len(choices)
+
+	item := slack.NewRefToMessage(r.ID, timestamp)
+	for i := range choices {
+		err := r.manager.slackRTM.AddReaction(choiceReactions[i], item)
+		if err != nil {
+			r.logger.WithError(err).Warn("adding choice reaction")
+			return
+		}
+	}
+}
+
+// handleReaction makes a choice when someone reacts to the message offering
+// the choices.
+func (r *Room) handleReaction(reaction *slack.ReactionAddedEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.gameInProgress() || r.choiceMessage == "" || reaction.Item.Timestamp != r.choiceMessage {
+		return
+	}
+
+	choice := 0
+	for i, name := range choiceReactions {
+		if reaction.Reaction == name {
+			choice = i + 1
+			break
+		}
+	}
+	if choice == 0 || choice > r.choiceCount {
+		return
+	}
+
+	r.logger.WithField("user", reaction.User).WithField("choice", choice).Info("choice made by reaction")
+
+	// Only the first reaction counts.
+	r.choiceMessage = ""
+	r.sendToGame(strconv.Itoa(choice))
+}
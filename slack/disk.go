@@ -0,0 +1,113 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/retention"
+)
+
+func (r *Room) commandDisk(cmdContext *commandContext, command string, args ...string) {
+	if r.config.Retention == nil {
+		r.sendMessage("I’m not keeping track of disk use right now.")
+		return
+	}
+
+	if len(args) > 0 && args[0] == "sweep" {
+		result, err := r.config.Retention.Sweep()
+		if err != nil {
+			r.logger.WithError(err).Error("sweeping")
+			r.sendMessage(fmt.Sprintf("I wasn’t able to clean up the working directories... %s", err.Error()))
+			return
+		}
+		r.sendMessage(fmt.Sprintf("All clean!  I expired %d old files and evicted %d more to stay under quota, freeing %s.", result.Expired, result.Evicted, formatBytes(result.Freed)))
+		return
+	}
+
+	rooms, err := r.config.Retention.Usage()
+	if err != nil {
+		r.logger.WithError(err).Error("getting disk usage")
+		r.sendMessage(fmt.Sprintf("I wasn’t able to check the disk use... %s", err.Error()))
+		return
+	}
+
+	var total int64
+	lines := make([]string, 0, len(rooms)+3)
+	for _, room := range rooms {
+		total += room.Size
+		inUse := ""
+		if room.InUse {
+			inUse = " _(game in progress)_"
+		}
+		lines = append(lines, fmt.Sprintf("     %s — %s in %s%s", r.manager.getRoomLink(room.Room), formatBytes(room.Size), formatKindCounts(room.Counts), inUse))
+	}
+
+	archived, err := r.config.Retention.ArchiveSize()
+	if err != nil {
+		r.logger.WithError(err).Warn("getting archive size")
+	}
+
+	policy := r.config.Retention.Policy
+	summary := fmt.Sprintf("Rooms are using %s in total (quota: %s), plus %s of archives.  Each room’s quota is %s, and files are kept for %s.",
+		formatBytes(total), formatQuota(policy.TotalQuota), formatBytes(archived), formatQuota(policy.RoomQuota), formatMaxAge(policy))
+
+	if len(lines) == 0 {
+		r.sendMessage(fmt.Sprintf("No rooms have any files yet.  %s", summary))
+		return
+	}
+
+	r.sendMessage(fmt.Sprintf("Here’s the disk use for each room:\n%s\n\n%s", strings.Join(lines, "\n"), summary))
+}
+
+func formatKindCounts(counts map[retention.Kind]int) string {
+	kinds := []retention.Kind{retention.SaveKind, retention.TranscriptKind, retention.SnapshotKind, retention.OtherKind}
+	parts := make([]string, 0, len(kinds))
+	for _, k := range kinds {
+		n := counts[k]
+		if n == 0 {
+			continue
+		}
+		plural := "s"
+		if n == 1 {
+			plural = ""
+		}
+		parts = append(parts, fmt.Sprintf("%d %s%s", n, k, plural))
+	}
+
+	if len(parts) == 0 {
+		return "no files"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatQuota(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return formatBytes(n)
+}
+
+func formatMaxAge(policy retention.Policy) string {
+	if policy.MaxAge <= 0 {
+		return "ever"
+	}
+	days := int(policy.MaxAge.Hours() / 24)
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
@@ -0,0 +1,274 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// Game downloads come from URLs that admins paste and from files shared in
+// Slack, so they're treated with suspicion: the bot token is only ever sent
+// to Slack's own file hosts, connections to private and loopback addresses are
+// refused (so a URL can't be used to poke at things on the bot's network), and
+// responses are size-limited.
+
+const (
+	downloadTimeout = 60 * time.Second
+	maxRedirects    = 5
+)
+
+var errPrivateAddress = errors.New("that address is on a private network")
+
+// slackFileHosts are the hosts that Slack serves uploaded files from.
+var slackFileHosts = []string{
+	"files.slack.com",
+	"slack-files.com",
+}
+
+func isSlackFileHost(u *url.URL) bool {
+	if u.Scheme != "https" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range slackFileHosts {
+		if host == h {
+			return true
+		}
+	}
+
+	// Enterprise Grid and regional hosts look like "files-edu.slack.com".
+	return strings.HasPrefix(host, "files") && strings.HasSuffix(host, ".slack.com")
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which isn't
+// "private" as far as net.IP is concerned, but isn't public either.
+var sharedAddressSpace = &net.IPNet{
+	IP:   net.IPv4(100, 64, 0, 0),
+	Mask: net.CIDRMask(10, 32),
+}
+
+// isPublicIP reports whether an address is somewhere we're willing to
+// connect to.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		sharedAddressSpace.Contains(ip) ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast())
+}
+
+// checkDestination runs just before each connection, after name resolution,
+// so it catches names that resolve to private addresses (and redirects to
+// them) too.
+func checkDestination(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errPrivateAddress
+	}
+
+	return nil
+}
+
+func newDownloadClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: checkDestination,
+	}
+
+	return &http.Client{
+		Timeout: downloadTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to a %s URL", req.URL.Scheme)
+			}
+			// Never let a redirect carry the token somewhere else.
+			if !isSlackFileHost(req.URL) {
+				req.Header.Del("Authorization")
+			}
+			return nil
+		},
+	}
+}
+
+// download fetches a file for uploading, applying the same checks (scheme,
+// destination, type, and size) whatever it's for.  Errors are suitable for
+// showing to the user.
+func (manager *Manager) download(uri string, filename string) ([]byte, error) {
+	logger := manager.logger.WithFields(log.Fields{
+		"url":  uri,
+		"name": filename,
+	})
+
+	u, err := url.Parse(uri)
+	if err == nil && u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("only http and https URLs are supported")
+	}
+	if err != nil {
+		logger.WithError(err).Warn("refusing url")
+		return nil, fmt.Errorf("I wasn’t able to download %s... %s", uri, err.Error())
+	}
+
+	logger.Debug("downloading file")
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		logger.WithError(err).Error("creating request")
+		return nil, fmt.Errorf("I wasn’t able to download %s... %s", uri, err.Error())
+	}
+	if isSlackFileHost(u) {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", manager.config.BotToken))
+	}
+
+	resp, err := newDownloadClient().Do(req)
+	if err != nil {
+		logger.WithError(err).Error("downloading file")
+		if errors.Is(err, errPrivateAddress) {
+			return nil, fmt.Errorf("I won’t download %s, because %s.", uri, errPrivateAddress.Error())
+		}
+		return nil, fmt.Errorf("I wasn’t able to download %s... %s", uri, err.Error())
+	}
+	defer resp.Body.Close()
+
+	b, err := readDownload(resp)
+	if err != nil {
+		logger.WithError(err).Warn("rejecting download")
+		return nil, fmt.Errorf("I didn’t add %s, because %s.", filename, err.Error())
+	}
+
+	return b, nil
+}
+
+func (manager *Manager) downloadGame(uri string, filename string, user string) (*games.UploadResult, error) {
+	logger := manager.logger.WithFields(log.Fields{
+		"url":  uri,
+		"name": filename,
+	})
+
+	b, err := manager.download(uri, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// Archives (like most IF Archive downloads) are unpacked, and each story
+	// inside them added.
+	var result *games.UploadResult
+	if games.IsArchive(b) {
+		result, err = manager.config.Games.AddArchive(filename, bytes.NewReader(b), user)
+	} else {
+		var game *games.GameInfo
+		game, err = manager.config.Games.AddGameFile(filename, bytes.NewReader(b), user)
+		if err == nil {
+			result = &games.UploadResult{Games: []*games.GameInfo{game}}
+		}
+	}
+
+	if e, ok := err.(games.RejectedError); ok {
+		logger.WithField("game", filename).WithError(err).Warn("rejected game")
+		return nil, rejectedFile(filename, e)
+	}
+	if err != nil {
+		logger.WithField("game", filename).WithError(err).Error("saving game")
+		return nil, fmt.Errorf("I wasn't able to save %s to %s... %s", uri, filename, err.Error())
+	}
+
+	return result, nil
+}
+
+// rejectedFile tells the user why the repository turned away a file.
+func rejectedFile(filename string, e games.RejectedError) error {
+	return fmt.Errorf("I didn’t add %s, because %s.", filename, e.Reason())
+}
+
+// readDownload checks the response's status, type, and size before reading
+// it.  (The content itself is checked when it's added to the repository.)
+func readDownload(resp *http.Response) ([]byte, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the server said “%s”", resp.Status)
+	}
+
+	// Slack answers requests it doesn't like with a sign-in page, rather than
+	// an error status.
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil &&
+		(mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		return nil, errors.New("I got a web page rather than a file (is the link right, and am I allowed to see it?)")
+	}
+
+	if resp.ContentLength > games.MaxUploadSize {
+		return nil, fmt.Errorf("it’s larger than %d MB", games.MaxUploadSize/(1024*1024))
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, games.MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > games.MaxUploadSize {
+		return nil, fmt.Errorf("it’s larger than %d MB", games.MaxUploadSize/(1024*1024))
+	}
+
+	return b, nil
+}
+
+// downloadCompanions fetches a file (or archive of files) and attaches it to
+// a game, returning a summary for the user.
+func (manager *Manager) downloadCompanions(uri string, filename string, name string) (string, error) {
+	b, err := manager.download(uri, filename)
+	if err != nil {
+		return "", err
+	}
+
+	game, added, err := manager.config.Games.AddCompanions(name, filename, bytes.NewReader(b))
+	switch e := err.(type) {
+	case *games.NotFoundError:
+		return "", errors.New(formatNotFound(e))
+	case games.RejectedError:
+		return "", rejectedFile(filename, e)
+	}
+	if err != nil {
+		manager.logger.WithField("game", name).WithError(err).Error("saving companion files")
+		return "", fmt.Errorf("I wasn't able to save %s... %s", filename, err.Error())
+	}
+
+	return fmt.Sprintf("I’ve added %s to *%s*; tell me *feelies %s* to see %s.", formatFileList(added), game.Name, game.Name, pluralIt(len(added))), nil
+}
+
+func formatFileList(names []string) string {
+	switch len(names) {
+	case 1:
+		return names[0]
+	case 2:
+		return fmt.Sprintf("%s and %s", names[0], names[1])
+	}
+	return fmt.Sprintf("%s, and %s", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
@@ -0,0 +1,132 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// We'll share this many of a game's companion files at once; beyond that, we
+// list them and let the players pick.
+const maxFeelies = 5
+
+func (r *Room) commandFeelies(cmdContext *commandContext, command string, args ...string) {
+	if len(args) == 0 {
+		if !r.gameInProgress() {
+			r.sendMessage("Which game’s extras would you like?  Tell me *feelies _game-name_*.")
+			return
+		}
+		args = []string{r.game}
+	}
+
+	game, file, err := r.findFeelie(args)
+	if err != nil {
+		r.sendGameError("find the game", err)
+		return
+	}
+
+	if len(game.Companions) == 0 {
+		r.sendMessage(fmt.Sprintf("*%s* doesn’t have any maps, manuals, or other extras.", game.Name))
+		return
+	}
+
+	names := game.Companions
+	if file != "" {
+		names = []string{file}
+	} else if len(names) > maxFeelies {
+		r.sendMessage(fmt.Sprintf("*%s* comes with %s.  Tell me *feelies %s _file-name_* for the one you’d like.", game.Name, formatFileList(names), game.Name))
+		return
+	}
+
+	for _, name := range names {
+		r.shareFeelie(game, name)
+	}
+}
+
+// findFeelie works out which game (and which of its files, if any) the
+// arguments name.  Since both game names and file names can have spaces, we
+// try the whole thing as a game name first, then the last word as a file
+// name.
+func (r *Room) findFeelie(args []string) (*games.GameInfo, string, error) {
+	game, err := r.config.Games.GetGame(strings.Join(args, " "))
+	if err == nil || len(args) < 2 {
+		return game, "", err
+	}
+
+	game, err2 := r.config.Games.GetGame(strings.Join(args[:len(args)-1], " "))
+	if err2 != nil {
+		return nil, "", err
+	}
+
+	file := args[len(args)-1]
+	for _, c := range game.Companions {
+		if strings.EqualFold(c, file) {
+			return game, c, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("*%s* doesn’t have a file called “%s”; it comes with %s", game.Name, file, formatFileList(game.Companions))
+}
+
+// shareFeelie uploads one of a game's companion files into the room, or links
+// to it if it's already been uploaded here.
+func (r *Room) shareFeelie(game *games.GameInfo, name string) {
+	key := fmt.Sprintf("%s/%s", game.FileName, name)
+	if link, ok := r.sharedFiles[key]; ok {
+		r.sendMessage(fmt.Sprintf("Here’s <%s|%s> from *%s* again.", link, name, game.Name))
+		return
+	}
+
+	filePath, err := r.config.Games.GetCompanionFile(game.FileName, name)
+	if err != nil {
+		r.logger.WithError(err).Error("finding companion file")
+		r.sendMessage(fmt.Sprintf("I couldn’t find “%s”: %s", name, err.Error()))
+		return
+	}
+
+	file, err := r.manager.slackRTM.UploadFile(slack.FileUploadParameters{
+		File:     filePath,
+		Filename: name,
+		Title:    fmt.Sprintf("%s: %s", game.DisplayName, name),
+		Channels: []string{r.ID},
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("uploading companion file")
+		r.sendMessage(fmt.Sprintf("I couldn’t share “%s”: %s", name, err.Error()))
+		return
+	}
+
+	if file != nil && file.Permalink != "" {
+		r.sharedFiles[key] = file.Permalink
+	}
+}
+
+func (r *Room) commandDetach(cmdContext *commandContext, command string, args ...string) {
+	if len(args) < 2 {
+		r.sendMessage("Tell me *detach _game-name_ _file-name_* to remove one of a game’s extras.")
+		return
+	}
+
+	game, file, err := r.findFeelie(args)
+	if err == nil && file == "" {
+		err = fmt.Errorf("you didn’t say which of *%s*’s files to remove", game.Name)
+	}
+	if err != nil {
+		r.sendGameError("remove the file", err)
+		return
+	}
+
+	err = r.config.Games.RemoveCompanion(game.FileName, file)
+	if err != nil {
+		r.logger.WithError(err).Error("removing companion file")
+		r.sendGameError("remove the file", err)
+		return
+	}
+
+	delete(r.sharedFiles, fmt.Sprintf("%s/%s", game.FileName, file))
+
+	r.sendMessage(fmt.Sprintf("Okay, *%s* doesn’t come with “%s” any more.", game.Name, file))
+}
@@ -106,5 +106,5 @@ func formatDebugOutput(output *fizmo.Output) string {
 
 	lines = append(lines, sep1)
 
-	return fmt.Sprintf(strings.Join(lines, "\n"))
+	return strings.Join(lines, "\n")
 }
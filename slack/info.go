@@ -0,0 +1,103 @@
+package slack
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// formatCatalogRating describes a game's average rating in the catalog, like
+// “★★★★☆ 4.2 (31 ratings)”.
+func formatCatalogRating(entry *games.CatalogEntry) string {
+	if entry == nil || entry.RatingCount == 0 {
+		return ""
+	}
+
+	// (The catalog may have been written before ratings were checked.)
+	rating := entry.AverageRating
+	if math.IsNaN(rating) {
+		return ""
+	}
+	rating = math.Max(0, math.Min(rating, 5))
+
+	full := int(rating + 0.5)
+	stars := strings.Repeat("★", full) + strings.Repeat("☆", 5-full)
+
+	ratings := "ratings"
+	if entry.RatingCount == 1 {
+		ratings = "rating"
+	}
+	return fmt.Sprintf("%s %.1f (%d %s)", stars, rating, entry.RatingCount, ratings)
+}
+
+func (r *Room) commandInfo(cmdContext *commandContext, command string, args ...string) {
+	if len(args) == 0 {
+		r.sendMessage("Which game would you like to know about?  Tell me *info _game-name_*.")
+		return
+	}
+
+	game, err := r.config.Games.GetGame(strings.Join(args, " "))
+	if err != nil {
+		r.sendGameError("find the game", err)
+		return
+	}
+
+	r.sendMessage(formatGameInfo(game))
+}
+
+// formatGameInfo describes everything we know about a game.
+func formatGameInfo(game *games.GameInfo) string {
+	meta := game.Bibliographic()
+
+	title := fmt.Sprintf("*%s*", game.DisplayName)
+	if meta.Author != "" {
+		title = fmt.Sprintf("%s by %s", title, meta.Author)
+	}
+	if meta.Headline != "" {
+		title = fmt.Sprintf("%s\n_%s_", title, meta.Headline)
+	}
+
+	lines := []string{title}
+	if meta.Description != "" {
+		lines = append(lines, "", meta.Description, "")
+	}
+
+	field := func(label string, value string) {
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("*%s:* %s", label, value))
+		}
+	}
+
+	field("Published", meta.FirstPublished)
+	field("Genre", meta.Genre)
+	if game.Catalog != nil {
+		field("IFDB rating", formatCatalogRating(game.Catalog))
+		field("Play time", game.Catalog.PlayTime)
+		field("Awards", strings.Join(game.Catalog.Awards, "; "))
+	}
+	field("Content rating", game.Rating)
+	field("Categories", strings.Join(game.Categories, ", "))
+
+	if game.Playable() {
+		field("Story", game.Story.String())
+	} else {
+		field("Problem", game.Problem)
+	}
+	field("IFID", game.ID)
+	field("Extras", strings.Join(game.Companions, ", "))
+
+	added := game.AddedAt.Format("January 2, 2006")
+	if game.AddedBy != "" {
+		added = fmt.Sprintf("%s by <@%s>", added, game.AddedBy)
+	}
+	field("Added", added)
+
+	if game.Catalog != nil && game.Catalog.Link != "" {
+		field("More", fmt.Sprintf("<%s|on IFDB>", game.Catalog.Link))
+	}
+
+	lines = append(lines, "", fmt.Sprintf("You can start it using *play %s*", game.Name))
+	return strings.Join(lines, "\n")
+}
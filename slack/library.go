@@ -0,0 +1,265 @@
+package slack
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// How many games we list at a time.
+const gamesPerPage = 15
+
+// listOptions are the filters, sort order, and page requested for a list of
+// games, like “list zcode #horror by newest 2”.
+type listOptions struct {
+	format string
+	tag    string
+	sortBy string
+	page   int
+}
+
+func parseListOptions(args []string) (*listOptions, error) {
+	opts := &listOptions{page: 1}
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(args[i])
+
+		switch {
+		case arg == "page" || arg == "by":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("you need to say what comes after “%s”", arg)
+			}
+			i++
+			if arg == "by" {
+				opts.sortBy = strings.ToLower(args[i])
+				continue
+			}
+			arg = args[i]
+			fallthrough
+
+		case isNumber(arg):
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("“%s” isn’t a page number", arg)
+			}
+			opts.page = n
+
+		case strings.HasPrefix(arg, "format:"):
+			opts.format = strings.TrimPrefix(arg, "format:")
+
+		case strings.HasPrefix(arg, "tag:"):
+			opts.tag = strings.TrimPrefix(arg, "tag:")
+
+		case strings.HasPrefix(arg, "#"):
+			opts.tag = strings.TrimPrefix(arg, "#")
+
+		case isFormatName(arg):
+			opts.format = arg
+
+		default:
+			opts.tag = arg
+		}
+	}
+
+	return opts, nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func isFormatName(s string) bool {
+	switch strings.TrimPrefix(s, ".") {
+	case games.ZCodeFormat, games.BlorbFormat, games.InkFormat, games.TwineFormat, "twee", "html",
+		"z1", "z2", "z3", "z4", "z5", "z6", "z7", "z8", "zblorb", "zlb":
+		return true
+	}
+	return false
+}
+
+// filter returns the games matching the options' format and tag, sorted.
+func (opts *listOptions) filter(all []*games.GameInfo) ([]*games.GameInfo, error) {
+	matching := make([]*games.GameInfo, 0, len(all))
+	for _, game := range all {
+		if opts.format != "" && !game.HasFormat(opts.format) {
+			continue
+		}
+		if opts.tag != "" && !game.HasTag(opts.tag) {
+			continue
+		}
+		matching = append(matching, game)
+	}
+
+	err := games.SortGames(matching, opts.sortBy)
+	if err != nil {
+		return nil, err
+	}
+	return matching, nil
+}
+
+// describe says what's being listed, like “zcode games tagged _horror_”.
+func (opts *listOptions) describe() string {
+	desc := "games"
+	if opts.format != "" {
+		desc = fmt.Sprintf("%s games", opts.format)
+	}
+	if opts.tag != "" {
+		desc = fmt.Sprintf("%s tagged _%s_", desc, opts.tag)
+	}
+	if opts.sortBy != "" && opts.sortBy != "name" {
+		desc = fmt.Sprintf("%s, by %s", desc, opts.sortBy)
+	}
+	return desc
+}
+
+// words returns the options (other than the page) as list arguments, for
+// asking for another page.
+func (opts *listOptions) words() []string {
+	words := make([]string, 0, 3)
+	if opts.format != "" {
+		words = append(words, opts.format)
+	}
+	if opts.tag != "" {
+		words = append(words, fmt.Sprintf("#%s", opts.tag))
+	}
+	if opts.sortBy != "" {
+		words = append(words, "by", opts.sortBy)
+	}
+	return words
+}
+
+// paginate returns the games on the requested page, and the number of pages.
+func paginate(list []*games.GameInfo, page int) ([]*games.GameInfo, int) {
+	pages := (len(list) + gamesPerPage - 1) / gamesPerPage
+	if page > pages {
+		return nil, pages
+	}
+
+	start := (page - 1) * gamesPerPage
+	end := start + gamesPerPage
+	if end > len(list) {
+		end = len(list)
+	}
+	return list[start:end], pages
+}
+
+func formatGameEntries(list []*games.GameInfo) string {
+	lines := make([]string, 0, len(list))
+	for _, game := range list {
+		lines = append(lines, formatGameEntry(game))
+	}
+	return strings.Join(lines, "\n     ")
+}
+
+// formatNotFound explains that a game wasn't found, offering any suggestions.
+func formatNotFound(err *games.NotFoundError) string {
+	msg := fmt.Sprintf("I don’t have a game called “%s”.", err.Name)
+
+	switch len(err.Suggestions) {
+	case 0:
+		return fmt.Sprintf("%s  You can use *list* or *search* to find one.", msg)
+	case 1:
+		return fmt.Sprintf("%s  Did you mean *%s*?", msg, err.Suggestions[0])
+	}
+
+	names := make([]string, len(err.Suggestions))
+	for i, s := range err.Suggestions {
+		names[i] = fmt.Sprintf("*%s*", s)
+	}
+	most := names[0 : len(names)-1]
+	last := names[len(names)-1]
+	return fmt.Sprintf("%s  Did you mean %s or %s?", msg, strings.Join(most, ", "), last)
+}
+
+func (r *Room) commandSearch(cmdContext *commandContext, command string, args ...string) {
+	if len(args) == 0 {
+		r.sendMessage("What would you like me to search for?  Tell me *search _words_*, and I’ll look for games with those words in their titles, authors, or descriptions.")
+		return
+	}
+
+	all, err := r.config.Games.GetGames()
+	if err != nil {
+		r.logger.WithError(err).Error("unable to get games")
+		r.sendMessage("I’m sorry, I wasn’t able to get to the list of games.")
+		return
+	}
+
+	all, hidden := permittedGames(r.settings(), all)
+
+	query := strings.Join(args, " ")
+	found := games.Search(all, query)
+	if len(found) == 0 {
+		r.sendMessage(fmt.Sprintf("I couldn’t find any games matching “%s”.%s", query, formatHiddenGames(hidden)))
+		return
+	}
+
+	more := ""
+	if len(found) > gamesPerPage {
+		more = fmt.Sprintf("\n_…and %d more; try adding another word to narrow it down._", len(found)-gamesPerPage)
+		found = found[:gamesPerPage]
+	}
+
+	r.sendMessage(fmt.Sprintf("Here’s what I found for “%s”:\n     %s%s\n\nYou can start a game using *play _game-name_*", query, formatGameEntries(found), more))
+}
+
+func (r *Room) commandAlias(cmdContext *commandContext, command string, args ...string) {
+	switch len(args) {
+	case 0:
+		aliases, err := r.config.Games.GetAliases()
+		if err != nil {
+			r.logger.WithError(err).Error("getting aliases")
+			r.sendMessage(fmt.Sprintf("I couldn’t get the aliases: %s", err.Error()))
+			return
+		}
+
+		if len(aliases) == 0 {
+			r.sendMessage("There aren’t any game aliases yet.  Tell me *alias _alias_ _game-name_* to add one.")
+			return
+		}
+
+		names := make([]string, 0, len(aliases))
+		for alias := range aliases {
+			names = append(names, alias)
+		}
+		sort.Strings(names)
+
+		lines := make([]string, len(names))
+		for i, alias := range names {
+			lines[i] = fmt.Sprintf("*%s* → %s", alias, aliases[alias])
+		}
+		r.sendMessage(fmt.Sprintf("These are the game aliases:\n     %s", strings.Join(lines, "\n     ")))
+
+	case 1:
+		r.sendMessage(fmt.Sprintf("Which game should “%s” refer to?  Tell me *alias %s _game-name_*.", args[0], args[0]))
+
+	default:
+		game, err := r.config.Games.SetAlias(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			if nf, ok := err.(*games.NotFoundError); ok {
+				r.sendMessage(formatNotFound(nf))
+				return
+			}
+			r.sendMessage(fmt.Sprintf("I couldn’t add the alias: %s", err.Error()))
+			return
+		}
+		r.sendMessage(fmt.Sprintf("Okay, *%s* now refers to %s.", strings.ToLower(args[0]), formatGameSummary(game)))
+	}
+}
+
+func (r *Room) commandUnalias(cmdContext *commandContext, command string, args ...string) {
+	if len(args) == 0 {
+		r.sendMessage("Which alias should I remove?  Tell me *unalias _alias_*.")
+		return
+	}
+
+	err := r.config.Games.RemoveAlias(args[0])
+	if err != nil {
+		r.sendMessage(fmt.Sprintf("I couldn’t remove the alias: %s", err.Error()))
+		return
+	}
+	r.sendMessage(fmt.Sprintf("Okay, I’ve removed the alias *%s*.", strings.ToLower(args[0])))
+}
@@ -2,15 +2,20 @@ package slack
 
 import (
 	"fmt"
-	"net/http"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/JaredReisinger/xyzzybot/backup"
+	"github.com/JaredReisinger/xyzzybot/channels"
 	"github.com/JaredReisinger/xyzzybot/fizmo"
 	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/retention"
+	"github.com/JaredReisinger/xyzzybot/sessions"
 )
 
 // Config for Slack components...
@@ -21,6 +26,11 @@ type Config struct {
 	Games              games.Repository
 	InterpreterFactory fizmo.InterpreterFactory
 	WorkingRoot        string
+	Sessions           *sessions.Store
+	Channels           *channels.Store
+	Retention          *retention.Sweeper
+	Backup             *backup.Plan
+	AnnounceShutdown   bool
 }
 
 // Manager ...
@@ -32,8 +42,23 @@ type Manager struct {
 	authInfo slack.AuthTestResponse
 	self     *slack.UserDetails
 	selfLink string
-	rooms    roomMap
 	quit     chan bool
+	done     chan bool
+	stopping chan bool
+
+	// events counts the events being handled; once we're stopping, no new
+	// ones are started, and stopped is closed.
+	events  sync.WaitGroup
+	stopped chan bool
+
+	// Events are handled concurrently (see handleEvents), so the rooms are
+	// guarded; use getRoom and allRooms to get at them.
+	rooms      roomMap
+	roomsMutex sync.RWMutex
+
+	// Only one backup runs at a time (see commandBackup).
+	backingUp   bool
+	backupMutex sync.Mutex
 }
 
 type roomMap map[string]*Room
@@ -60,6 +85,9 @@ func StartManager(config *Config) (manager *Manager, err error) {
 		selfLink: fmt.Sprintf("<@%s>", resp.UserID),
 		rooms:    make(roomMap, 0),
 		quit:     make(chan bool),
+		done:     make(chan bool),
+		stopping: make(chan bool),
+		stopped:  make(chan bool),
 	}
 
 	go manager.handleEvents()
@@ -71,11 +99,90 @@ func StartManager(config *Config) (manager *Manager, err error) {
 // Disconnect ...
 func (manager *Manager) Disconnect() {
 	close(manager.quit)
+	<-manager.done
+}
+
+// Shutdown performs an orderly shutdown: it stops accepting commands, waits
+// for the ones already under way, saves every game in progress (optionally
+// letting each room know), waits up to timeout for the interpreters to exit,
+// and then disconnects from Slack.
+func (manager *Manager) Shutdown(timeout time.Duration) {
+	manager.logger.WithField("timeout", timeout).Info("shutting down")
+	deadline := time.After(timeout)
+	close(manager.stopping)
+
+	<-manager.stopped
+	idle := make(chan bool)
+	go func() {
+		manager.events.Wait()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+	case <-deadline:
+		manager.logger.Warn("timed out waiting for commands to finish")
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range manager.allRooms() {
+		wg.Add(1)
+		go func(r *Room) {
+			defer wg.Done()
+			r.shutdownGame(manager.config.AnnounceShutdown)
+		}(r)
+	}
+
+	finished := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		manager.logger.Info("all games saved")
+	case <-deadline:
+		manager.logger.Warn("timed out waiting for games to save")
+	}
+
+	manager.Disconnect()
+}
+
+func (manager *Manager) shuttingDown() bool {
+	select {
+	case <-manager.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// getRoom returns the room with the given ID, if we're in it.
+func (manager *Manager) getRoom(id string) (*Room, bool) {
+	manager.roomsMutex.RLock()
+	defer manager.roomsMutex.RUnlock()
+
+	r, ok := manager.rooms[id]
+	return r, ok
+}
+
+// allRooms returns the rooms we're in.  It's a copy, so rooms can come and go
+// while the caller works through it.
+func (manager *Manager) allRooms() []*Room {
+	manager.roomsMutex.RLock()
+	defer manager.roomsMutex.RUnlock()
+
+	rooms := make([]*Room, 0, len(manager.rooms))
+	for _, r := range manager.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
 }
 
 func (manager *Manager) getActiveRoomLinks() (typeNames map[roomType][]string) {
 	typeNames = make(map[roomType][]string, 0)
-	for _, r := range manager.rooms {
+	for _, r := range manager.allRooms() {
 		typeNames[r.roomType] = append(typeNames[r.roomType], r.link)
 	}
 
@@ -92,19 +199,45 @@ func (manager *Manager) getActiveRoomLinks() (typeNames map[roomType][]string) {
 	return
 }
 
+// getRoomLink returns the link for a room by ID, or just the ID if we're not
+// (or no longer) in the room.
+func (manager *Manager) getRoomLink(id string) string {
+	if r, ok := manager.getRoom(id); ok {
+		return r.link
+	}
+	return fmt.Sprintf("`%s`", id)
+}
+
 func (manager *Manager) handleEvents() {
+	defer close(manager.done)
 	defer manager.slackRTM.Disconnect()
 
+	stopping := manager.stopping
 	for {
 		select {
 		case managerEvent := <-manager.slackRTM.IncomingEvents:
+			// Once we're stopping, events are dropped.
+			if stopping == nil {
+				continue
+			}
+
 			// Always process events in a goroutine to keep this handler loop
 			// unblocked and responsive!
-			go manager.processEvent(managerEvent)
+			manager.events.Add(1)
+			go func() {
+				defer manager.events.Done()
+				manager.processEvent(managerEvent)
+			}()
+
+		case <-stopping:
+			// No more events will be started, so Shutdown can wait for the
+			// ones under way.
+			stopping = nil
+			close(manager.stopped)
 
 		case _, ok := <-manager.quit:
 			manager.logger.WithField("ok", ok).Debug("Got quit")
-			break
+			return
 		}
 	}
 
@@ -174,6 +307,11 @@ func (manager *Manager) processEvent(managerEvent slack.RTMEvent) {
 	case *slack.MessageEvent:
 		manager.handleMessageEvent(t)
 
+	case *slack.ReactionAddedEvent:
+		if r, ok := manager.getRoom(t.Item.Channel); ok && t.User != manager.authInfo.UserID {
+			r.handleReaction(t)
+		}
+
 		// default:
 		// 	manager.logger.WithFields(log.Fields{
 		// 		"eventName":     managerEvent.Type,
@@ -235,9 +373,11 @@ func (manager *Manager) handleConnectedEvent(connEvent *slack.ConnectedEvent) {
 }
 
 func (manager *Manager) addRoom(id string, roomType roomType, name string, link string, initialStartup bool) {
+	manager.roomsMutex.Lock()
 	_, ok := manager.rooms[id]
 
 	if ok {
+		manager.roomsMutex.Unlock()
 		manager.logger.WithField("id", id).Warn("attempting to add existing room")
 		// REVIEW: post a message in this case?
 		return
@@ -246,11 +386,14 @@ func (manager *Manager) addRoom(id string, roomType roomType, name string, link
 	manager.logger.WithField("id", id).Info("adding room")
 	r := newRoom(manager.config, manager, id, roomType, name, link)
 	manager.rooms[id] = r
+	manager.roomsMutex.Unlock()
+
+	r.endStaleSession()
 	r.sendIntro(initialStartup)
 }
 
 func (manager *Manager) renameRoom(id string, name string) {
-	r, ok := manager.rooms[id]
+	r, ok := manager.getRoom(id)
 
 	if !ok {
 		return
@@ -259,11 +402,16 @@ func (manager *Manager) renameRoom(id string, name string) {
 		"id":   id,
 		"name": name,
 	}).Info("renaming room")
+	r.mutex.Lock()
 	r.name = name
+	r.mutex.Unlock()
 }
 
 func (manager *Manager) removeRoom(channel string) {
+	manager.roomsMutex.Lock()
 	r, ok := manager.rooms[channel]
+	delete(manager.rooms, channel)
+	manager.roomsMutex.Unlock()
 
 	if !ok {
 		manager.logger.WithField("channel", channel).Warn("attempting to remove non-tracked channel")
@@ -272,10 +420,13 @@ func (manager *Manager) removeRoom(channel string) {
 	}
 
 	manager.logger.WithField("channel", channel).Info("removing channel")
-	r.killGame()
-	delete(manager.rooms, channel)
+	r.mutex.Lock()
+	r.killGame("I left the channel")
+	r.mutex.Unlock()
 }
 
+var uploadForGame = regexp.MustCompile(`(?i)\bupload\s+for\s+(.+)$`)
+
 func (manager *Manager) handleFileEvent(fileEvent *slack.FileSharedEvent) {
 	file, _, _, err := manager.slackRTM.Client.GetFileInfo(fileEvent.FileID, 0, 0)
 	if err != nil {
@@ -283,6 +434,11 @@ func (manager *Manager) handleFileEvent(fileEvent *slack.FileSharedEvent) {
 		return
 	}
 
+	if manager.shuttingDown() {
+		manager.logger.WithField("file", file.Name).Debug("ignoring file while shutting down")
+		return
+	}
+
 	user, admin := manager.getUser(file.User)
 
 	logger := manager.logger.WithFields(log.Fields{
@@ -319,43 +475,112 @@ func (manager *Manager) handleFileEvent(fileEvent *slack.FileSharedEvent) {
 		// "DUMP":               file,
 	}).Info("file info")
 
-	err = manager.downloadGame(file.URLPrivate, file.Name)
+	if int64(file.Size) > games.MaxUploadSize {
+		logger.WithField("size", file.Size).Warn("ignoring oversized file")
+		manager.sendMessage(file.User, fmt.Sprintf("I didn’t add %s, because it’s larger than %d MB.", file.Name, games.MaxUploadSize/(1024*1024)))
+		return
+	}
+
+	// A comment like “upload for _game-name_” attaches the file to a game.
+	if matches := uploadForGame.FindStringSubmatch(file.InitialComment.Comment); matches != nil {
+		summary, err := manager.downloadCompanions(file.URLPrivate, file.Name, strings.TrimSpace(matches[1]))
+		if err != nil {
+			manager.sendMessage(file.User, err.Error())
+			return
+		}
+		manager.sendMessage(file.User, summary)
+		return
+	}
+
+	result, err := manager.downloadGame(file.URLPrivate, file.Name, file.User)
 	if err != nil {
 		manager.sendMessage(file.User, err.Error())
 		return
 	}
 
-	manager.sendMessage(file.User, fmt.Sprintf("Upload complete!  The game *%s* is now available!", file.Name))
+	manager.sendMessage(file.User, manager.uploadSummary(result))
+	manager.reloadAuthorGames(result.Games)
 }
 
-func (manager *Manager) downloadGame(uri string, filename string) error {
-	logger := manager.logger.WithFields(log.Fields{
-		"url":  uri,
-		"name": filename,
-	})
+// uploadSummary describes the newly-added games, warning about any that are
+// the same story (by IFID) as a game that was already available under another
+// name.
+func (manager *Manager) uploadSummary(result *games.UploadResult) string {
+	names := make([]string, 0, len(result.Games))
+	warnings := make([]string, 0)
+
+	for _, game := range result.Games {
+		name := fmt.Sprintf("*%s*", game.Name)
+		if game.Title() != "" {
+			name = fmt.Sprintf("*%s* (_%s_)", game.Name, game.Title())
+		}
+		names = append(names, name)
 
-	logger.Debug("downloading game")
-	req, err := http.NewRequest(http.MethodGet, uri, nil)
-	if err != nil {
-		logger.WithError(err).Error("creating request")
-		return fmt.Errorf("I wasn’t able to download %s... %s", uri, err.Error())
+		if warning := manager.duplicateWarning(game); warning != "" {
+			warnings = append(warnings, warning)
+		}
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", manager.config.BotToken))
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.WithError(err).Error("downloading game")
-		return fmt.Errorf("I wasn’t able to download %s... %s", uri, err.Error())
+
+	var msg string
+	switch len(names) {
+	case 0:
+		msg = "I didn’t find any games I could add."
+	case 1:
+		msg = fmt.Sprintf("Upload complete!  The %s is now available!", formatUploadList(names, "game"))
+	default:
+		msg = fmt.Sprintf("Upload complete!  The %s are now available!", formatUploadList(names, "game"))
+	}
+
+	if len(result.Companions) > 0 {
+		msg = fmt.Sprintf("%s  I’ve kept %s with %s.", msg, formatUploadList(result.Companions, "the file"), pluralIt(len(names)))
+	}
+
+	for _, skipped := range result.Skipped {
+		msg = fmt.Sprintf("%s\n_I didn’t add %s._", msg, skipped)
+	}
+
+	for _, warning := range warnings {
+		msg = fmt.Sprintf("%s\n\n%s", msg, warning)
 	}
-	defer resp.Body.Close()
 
-	err = manager.config.Games.AddGameFile(filename, resp.Body)
+	return msg
+}
+
+func (manager *Manager) duplicateWarning(game *games.GameInfo) string {
+	dups, err := manager.config.Games.FindGamesByIFID(game.ID)
 	if err != nil {
-		logger.WithField("game", filename).WithError(err).Error("saving game")
-		return fmt.Errorf("I wasn't able to save %s to %s... %s", uri, filename, err.Error())
+		manager.logger.WithField("ifid", game.ID).WithError(err).Warn("finding duplicates")
+		return ""
 	}
 
-	return nil
+	others := make([]string, 0, len(dups))
+	for _, d := range dups {
+		if d.FileName != game.FileName {
+			others = append(others, fmt.Sprintf("*%s*", d.Name))
+		}
+	}
+
+	if len(others) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("_Heads up: *%s* is the same story (IFID `%s`) as %s, which was already available.  You may want to *delete* one of them._", game.Name, game.ID, strings.Join(others, ", "))
+}
+
+// formatUploadList lists uploaded games or files, like “game *a*” or “games
+// *a*, *b*, and *c*”.  There must be at least one.
+func formatUploadList(names []string, noun string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("%s %s", noun, names[0])
+	}
+	return fmt.Sprintf("%ss %s", noun, formatFileList(names))
+}
+
+func pluralIt(n int) string {
+	if n == 1 {
+		return "it"
+	}
+	return "them"
 }
 
 func (manager *Manager) deleteGame(filename string) error {
@@ -387,6 +612,11 @@ func (manager *Manager) handleMessageEvent(msgEvent *slack.MessageEvent) {
 		return
 	}
 
+	if manager.shuttingDown() {
+		manager.logger.WithField("text", msgEvent.Text).Debug("ignoring message while shutting down")
+		return
+	}
+
 	// It's more efficient to perform the minimal evalution needed to determine
 	// whether the message represents a command or not... but it's much harder
 	// to read the logic that way.
@@ -396,13 +626,13 @@ func (manager *Manager) handleMessageEvent(msgEvent *slack.MessageEvent) {
 	// Ensure any direct-address (to us) is stripped first...
 	command := strings.TrimPrefix(text, manager.selfLink)
 	command = strings.TrimSpace(command)
-	looksLike := manager.looksLikeCommand(command)
+	looksLike := manager.looksLikeCommand(msgEvent.Channel, command)
 
 	if forSomeoneElse || (!toUs && !looksLike) {
 		return
 	}
 
-	r, ok := manager.rooms[msgEvent.Channel]
+	r, ok := manager.getRoom(msgEvent.Channel)
 	if !ok {
 		// Can this ever happen?
 		manager.handleCommand(msgEvent, msgEvent.Channel, command)
@@ -411,7 +641,16 @@ func (manager *Manager) handleMessageEvent(msgEvent *slack.MessageEvent) {
 	}
 }
 
-func (manager *Manager) looksLikeCommand(text string) bool {
+// looksLikeCommand decides whether a message that isn't addressed to us is
+// meant as a command anyway.  During a game, the game's vocabulary tells us
+// whether it's a command for the game.
+func (manager *Manager) looksLikeCommand(channel string, text string) bool {
+	if r, ok := manager.getRoom(channel); ok && !strings.HasPrefix(text, metaCommandPrefix) {
+		if command, known := r.looksLikeGameCommand(text); known {
+			return command
+		}
+	}
+
 	words := strings.Fields(text)
 	// If it's more than 4 words, it's *probably* not a command
 	return len(words) <= 4
@@ -464,7 +703,9 @@ func (manager *Manager) sendMessageWithStatus(channel string, text string, statu
 	manager.sendMessageWithNameContext(channel, text, status, "")
 }
 
-func (manager *Manager) sendMessageWithNameContext(channel string, text string, status string, nameContext string) {
+// sendMessageWithNameContext posts a message, returning its timestamp (which
+// identifies it), or "" if it couldn't be posted.
+func (manager *Manager) sendMessageWithNameContext(channel string, text string, status string, nameContext string) string {
 	// All of the message-posting/sending APIs are gross, each in their own way.
 	// You'd think there'd just be one that took a message object and sent it,
 	// but they all take pieces and parts and cram them together.
@@ -487,9 +728,10 @@ func (manager *Manager) sendMessageWithNameContext(channel string, text string,
 		}
 	}
 	// params.Attachments = ...
-	_, _, err := manager.slackRTM.PostMessage(channel, text, params)
+	_, timestamp, err := manager.slackRTM.PostMessage(channel, text, params)
 	if err != nil {
 		manager.logger.WithError(err).Error("posting message")
-		return
+		return ""
 	}
+	return timestamp
 }
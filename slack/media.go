@@ -0,0 +1,93 @@
+package slack
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/nlopes/slack"
+
+	"github.com/JaredReisinger/xyzzybot/blorb"
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// formatGameEntry formats a game for the game list, using the title and
+// author from the game's metadata when we have them, and noting its ratings and
+// any companion files (manuals, maps) that came with it.
+func formatGameEntry(game *games.GameInfo) string {
+	entry := formatGameSummary(game)
+	if stars := formatCatalogRating(game.Catalog); stars != "" {
+		entry = fmt.Sprintf("%s — %s", entry, stars)
+	}
+	if game.Rating != "" {
+		entry = fmt.Sprintf("%s — rated _%s_", entry, game.Rating)
+	}
+	if len(game.Companions) > 0 {
+		entry = fmt.Sprintf("%s — _with %s_", entry, strings.Join(game.Companions, ", "))
+	}
+	return entry
+}
+
+func formatGameSummary(game *games.GameInfo) string {
+	if !game.Playable() {
+		return fmt.Sprintf("*%s* — _(not a playable story file)_", game.Name)
+	}
+
+	if game.Title() == "" {
+		return fmt.Sprintf("*%s* — %s", game.Name, game.Story)
+	}
+
+	meta := game.Bibliographic()
+	byline := fmt.Sprintf("_%s_", meta.Title)
+	if meta.Author != "" {
+		byline = fmt.Sprintf("%s by %s", byline, meta.Author)
+	}
+	if meta.FirstPublished != "" {
+		byline = fmt.Sprintf("%s (%s)", byline, meta.FirstPublished)
+	}
+	if meta.Headline != "" {
+		byline = fmt.Sprintf("%s, “%s”", byline, meta.Headline)
+	}
+
+	return fmt.Sprintf("*%s* — %s — %s", game.Name, byline, game.Story)
+}
+
+// sendCoverArt uploads the game's cover art (if it has any) into the room,
+// along with its title, author, and description.
+func (r *Room) sendCoverArt(game *games.GameInfo, gameFile string) {
+	if game.Story == nil || !game.Story.HasCover {
+		return
+	}
+
+	f, err := blorb.ReadFile(gameFile)
+	if err != nil {
+		r.logger.WithError(err).Warn("reading blorb for cover art")
+		return
+	}
+
+	cover := f.Cover()
+	if cover == nil {
+		return
+	}
+
+	title := game.DisplayName
+
+	comment := fmt.Sprintf("*%s*", title)
+	if game.Author() != "" {
+		comment = fmt.Sprintf("%s by %s", comment, game.Author())
+	}
+	if description := game.Bibliographic().Description; description != "" {
+		comment = fmt.Sprintf("%s\n_%s_", comment, description)
+	}
+
+	_, err = r.manager.slackRTM.UploadFile(slack.FileUploadParameters{
+		Reader:         bytes.NewReader(cover.Data()),
+		Filename:       fmt.Sprintf("%s-cover%s", game.Name, cover.Extension()),
+		Title:          title,
+		InitialComment: comment,
+		Channels:       []string{r.ID},
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("uploading cover art")
+	}
+}
@@ -0,0 +1,288 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/channels"
+	"github.com/JaredReisinger/xyzzybot/games"
+)
+
+// settings returns the room's channel settings, or the defaults if they can't
+// be read.
+func (r *Room) settings() *channels.Settings {
+	if r.manager.config.Channels == nil {
+		return &channels.Settings{Room: r.ID}
+	}
+
+	settings, err := r.manager.config.Channels.Get(r.ID)
+	if err != nil {
+		r.logger.WithError(err).Error("getting channel settings")
+		return &channels.Settings{Room: r.ID}
+	}
+	return settings
+}
+
+// permittedGames splits games into those that may be played in the room, and
+// the number that may not.
+func permittedGames(settings *channels.Settings, all []*games.GameInfo) ([]*games.GameInfo, int) {
+	if !settings.HasPolicy() {
+		return all, 0
+	}
+
+	permitted := make([]*games.GameInfo, 0, len(all))
+	for _, game := range all {
+		if ok, _ := settings.Permits(game); ok {
+			permitted = append(permitted, game)
+		}
+	}
+	return permitted, len(all) - len(permitted)
+}
+
+func formatHiddenGames(hidden int) string {
+	switch hidden {
+	case 0:
+		return ""
+	case 1:
+		return "\n_(There’s one more game that isn’t available in this channel; see *policy*.)_"
+	}
+	return fmt.Sprintf("\n_(There are %d more games that aren’t available in this channel; see *policy*.)_", hidden)
+}
+
+func (r *Room) commandRate(cmdContext *commandContext, command string, args ...string) {
+	if len(args) < 2 {
+		r.sendMessage(fmt.Sprintf("Tell me *rate _game-name_ _rating_*, where the rating is one of %s (or *none*).", formatRatings()))
+		return
+	}
+
+	rating := args[len(args)-1]
+	if strings.EqualFold(rating, "none") {
+		rating = ""
+	}
+
+	game, err := r.config.Games.SetRating(strings.Join(args[:len(args)-1], " "), rating)
+	if err != nil {
+		r.sendGameError("rate the game", err)
+		return
+	}
+
+	if game.Rating == "" {
+		r.sendMessage(fmt.Sprintf("Okay, *%s* isn’t rated any more.", game.Name))
+		return
+	}
+	r.sendMessage(fmt.Sprintf("Okay, *%s* is now rated _%s_.", game.Name, game.Rating))
+}
+
+func (r *Room) commandCategorize(cmdContext *commandContext, command string, args ...string) {
+	if len(args) < 1 {
+		r.sendMessage("Tell me *categorize _game-name_ _category_ …* to give a game categories (or *categorize _game-name_ none* to remove them).")
+		return
+	}
+
+	// The categories are the #-prefixed words (or, failing that, all but the
+	// first word), so that game names can have spaces.
+	name := args[:1]
+	categories := args[1:]
+	for i, arg := range args {
+		if strings.HasPrefix(arg, channels.TagPrefix) {
+			name = args[:i]
+			categories = args[i:]
+			break
+		}
+	}
+	if len(categories) == 1 && strings.EqualFold(categories[0], "none") {
+		categories = nil
+	}
+
+	game, err := r.config.Games.SetCategories(strings.Join(name, " "), categories)
+	if err != nil {
+		r.sendGameError("categorize the game", err)
+		return
+	}
+
+	if len(game.Categories) == 0 {
+		r.sendMessage(fmt.Sprintf("Okay, *%s* doesn’t have any categories now.", game.Name))
+		return
+	}
+	r.sendMessage(fmt.Sprintf("Okay, *%s* is now in %s.", game.Name, formatCategories(game.Categories)))
+}
+
+// sendGameError reports a failure to do something with a game, with
+// suggestions if the game wasn't found.
+func (r *Room) sendGameError(action string, err error) {
+	if nf, ok := err.(*games.NotFoundError); ok {
+		r.sendMessage(formatNotFound(nf))
+		return
+	}
+	r.sendMessage(fmt.Sprintf("I couldn’t %s: %s", action, err.Error()))
+}
+
+func formatRatings() string {
+	names := make([]string, len(games.Ratings))
+	for i, rating := range games.Ratings {
+		names[i] = fmt.Sprintf("_%s_", rating)
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatCategories(categories []string) string {
+	names := make([]string, len(categories))
+	for i, c := range categories {
+		names[i] = fmt.Sprintf("_%s_", c)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (r *Room) commandPolicy(cmdContext *commandContext, command string, args ...string) {
+	if len(args) == 0 {
+		r.sendMessage(r.describePolicy(r.settings()))
+		return
+	}
+
+	if r.manager.config.Channels == nil {
+		r.sendMessage("I’m not able to keep track of channel settings right now.")
+		return
+	}
+
+	if !r.fromAdmin(cmdContext) {
+		r.sendMessage("I’m sorry, only xyzzybot admins can change which games can be played here.")
+		return
+	}
+
+	subcommand := strings.ToLower(args[0])
+	rest := strings.Join(args[1:], " ")
+
+	var update func(settings *channels.Settings) string
+	switch subcommand {
+	case "allow", "deny", "remove":
+		if rest == "" {
+			r.sendMessage(fmt.Sprintf("Tell me *policy %s _game-name_* (or *policy %s #_tag_*).", subcommand, subcommand))
+			return
+		}
+
+		entry, label, err := r.policyEntry(rest)
+		if err != nil {
+			r.sendGameError(fmt.Sprintf("%s that", subcommand), err)
+			return
+		}
+
+		update = func(settings *channels.Settings) string {
+			switch subcommand {
+			case "allow":
+				settings.Deny, _ = channels.RemoveEntry(settings.Deny, entry)
+				settings.Allow = channels.AddEntry(settings.Allow, entry)
+				return fmt.Sprintf("Okay, %s can be played here.", label)
+			case "deny":
+				settings.Allow, _ = channels.RemoveEntry(settings.Allow, entry)
+				settings.Deny = channels.AddEntry(settings.Deny, entry)
+				return fmt.Sprintf("Okay, %s can’t be played here.", label)
+			}
+
+			var allowed, denied bool
+			settings.Allow, allowed = channels.RemoveEntry(settings.Allow, entry)
+			settings.Deny, denied = channels.RemoveEntry(settings.Deny, entry)
+			if !allowed && !denied {
+				return fmt.Sprintf("This channel’s policy doesn’t mention %s.", label)
+			}
+			return fmt.Sprintf("Okay, this channel’s policy doesn’t mention %s any more.", label)
+		}
+
+	case "rating":
+		rating := strings.ToLower(rest)
+		if rating == "none" {
+			rating = ""
+		}
+		if rest == "" || games.RatingLevel(rating) < 0 {
+			r.sendMessage(fmt.Sprintf("Tell me *policy rating _rating_*, where the rating is one of %s (or *none*).", formatRatings()))
+			return
+		}
+
+		update = func(settings *channels.Settings) string {
+			settings.MaxRating = rating
+			if rating == "" {
+				return "Okay, games of any rating can be played here."
+			}
+			return fmt.Sprintf("Okay, only games rated up to _%s_ can be played here.", rating)
+		}
+
+	case "clear":
+		update = func(settings *channels.Settings) string {
+			settings.Allow = nil
+			settings.Deny = nil
+			settings.MaxRating = ""
+			return "Okay, any game can be played here."
+		}
+
+	default:
+		r.sendMessage("You can tell me *policy allow*, *policy deny*, *policy remove*, *policy rating*, or *policy clear*; see *help policy*.")
+		return
+	}
+
+	var msg string
+	err := r.manager.config.Channels.Update(r.ID, func(settings *channels.Settings) {
+		msg = update(settings)
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("updating channel settings")
+		r.sendMessage(fmt.Sprintf("I couldn’t change this channel’s settings: %s", err.Error()))
+		return
+	}
+
+	r.sendMessage(msg)
+}
+
+// policyEntry turns a game name or #tag into a policy entry, and a label for
+// it.
+func (r *Room) policyEntry(arg string) (string, string, error) {
+	if strings.HasPrefix(arg, channels.TagPrefix) {
+		tag := strings.ToLower(strings.TrimPrefix(arg, channels.TagPrefix))
+		return channels.TagPrefix + tag, fmt.Sprintf("games tagged _%s_", tag), nil
+	}
+
+	game, err := r.config.Games.GetGame(arg)
+	if err != nil {
+		return "", "", err
+	}
+	return game.FileName, fmt.Sprintf("*%s*", game.Name), nil
+}
+
+func (r *Room) describePolicy(settings *channels.Settings) string {
+	if !settings.HasPolicy() {
+		return "Any game can be played here."
+	}
+
+	names := make(map[string]string)
+	if all, err := r.config.Games.GetGames(); err == nil {
+		for _, game := range all {
+			names[game.FileName] = game.Name
+		}
+	}
+
+	describe := func(entries []string) string {
+		labels := make([]string, len(entries))
+		for i, entry := range entries {
+			switch {
+			case strings.HasPrefix(entry, channels.TagPrefix):
+				labels[i] = fmt.Sprintf("games tagged _%s_", strings.TrimPrefix(entry, channels.TagPrefix))
+			case names[entry] != "":
+				labels[i] = fmt.Sprintf("*%s*", names[entry])
+			default:
+				labels[i] = fmt.Sprintf("%s _(no longer available)_", entry)
+			}
+		}
+		return strings.Join(labels, ", ")
+	}
+
+	lines := make([]string, 0, 3)
+	if len(settings.Allow) > 0 {
+		lines = append(lines, fmt.Sprintf("Only these can be played: %s", describe(settings.Allow)))
+	}
+	if len(settings.Deny) > 0 {
+		lines = append(lines, fmt.Sprintf("These can’t be played: %s", describe(settings.Deny)))
+	}
+	if settings.MaxRating != "" {
+		lines = append(lines, fmt.Sprintf("Games must be rated _%s_ or below (unrated games are fine).", settings.MaxRating))
+	}
+
+	return fmt.Sprintf("This channel’s game policy:\n     %s", strings.Join(lines, "\n     "))
+}
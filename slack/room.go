@@ -7,16 +7,22 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/JaredReisinger/xyzzybot/fizmo"
+	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/quetzal"
+	"github.com/JaredReisinger/xyzzybot/zmachine"
 )
 
 const (
 	metaCommandPrefix = "!"
+	autosaveSlot      = "autosave"
 )
 
 // The term "channel" has specific meaning for Slack, moreso than just the
@@ -48,7 +54,44 @@ type Room struct {
 	config      *Config
 	manager     *Manager
 	interpreter fizmo.Interpreter
+	game        string // name of the in-progress game
+	gameFile    string // story file of the in-progress game
+	gameVersion int    // version of the in-progress game's file
 	logger      log.FieldLogger
+
+	// mutex serializes everything that touches the room's game: commands,
+	// reactions, the game's output, and reloads.  (Events are handled
+	// concurrently; see Manager.handleEvents.)  Other rooms can't take it
+	// without risking deadlock, so they find out what a room is playing from
+	// its session instead.
+	mutex sync.Mutex
+
+	// choiceMessage is the message offering the in-progress game's choices
+	// (if it's a choice-based game), which can be chosen by reacting to it.
+	choiceMessage string
+	choiceCount   int
+
+	// sharedFiles remembers the companion files already uploaded here (by
+	// game file and name), so that we can link to them instead of uploading
+	// them again.
+	sharedFiles map[string]string
+
+	// vocabulary is the in-progress game's dictionary (if it's Z-code), read
+	// when we first need to tell whether a message is a game command.
+	vocabulary      *zmachine.Dictionary
+	vocabularyRead  bool
+	lastGameCommand string
+
+	// history is the commands sent to the in-progress game, and its responses,
+	// so that they can be replayed on a new build of the game (see
+	// commandAuthor).  While they're being replayed, the game's output isn't
+	// shown.
+	history []*gameTurn
+	replay  *gameReplay
+
+	// outputSignal is poked (without blocking) whenever the game produces
+	// output, so that we can wait for the game to respond to something.
+	outputSignal chan bool
 }
 
 func newRoom(config *Config, manager *Manager, id string, roomType roomType, name string, link string) *Room {
@@ -59,6 +102,9 @@ func newRoom(config *Config, manager *Manager, id string, roomType roomType, nam
 		link:     link,
 		config:   config,
 		manager:  manager,
+
+		sharedFiles:  make(map[string]string),
+		outputSignal: make(chan bool, 1),
 		logger: config.Logger.WithFields(log.Fields{
 			"component": "slack",
 			"room":      name,
@@ -67,7 +113,14 @@ func newRoom(config *Config, manager *Manager, id string, roomType roomType, nam
 	}
 }
 
-func (r *Room) startGame(name string) (err error) {
+func (r *Room) startGame(name string, user string) error {
+	return r.startGameVersion(name, "", user)
+}
+
+// startGameVersion starts a particular version of a game ("" for the current
+// one).  The interpreter is given an unchanging copy of that version, so that
+// the game can be replaced without disturbing this session.
+func (r *Room) startGameVersion(name string, version string, user string) (err error) {
 	if r.gameInProgress() {
 		err = errors.New("game already in progress, ignoring start-game request")
 		r.logger.WithError(err).Error("starting game")
@@ -75,7 +128,7 @@ func (r *Room) startGame(name string) (err error) {
 	}
 
 	// Create a working directory for the interpreter...
-	workingDir := path.Join(r.config.WorkingRoot, r.ID)
+	workingDir := r.workingDir()
 	err = os.MkdirAll(workingDir, os.FileMode(0755))
 	if err != nil {
 		r.logger.WithError(err).Error("creating working directory")
@@ -84,21 +137,32 @@ func (r *Room) startGame(name string) (err error) {
 
 	// Create a new interpreter for the requested game...
 	r.logger.WithField("game", name).Info("starting game")
-	gameFile, err := r.config.Games.GetGameFile(name)
+	game, err := r.config.Games.GetGame(name)
+	if err != nil {
+		r.logger.WithError(err).Error("getting game")
+		return err
+	}
+	gameFile, gameVersion, err := r.config.Games.GetGameVersionFile(game.FileName, version)
 	if err != nil {
 		r.logger.WithError(err).Error("getting game file")
 		return err
 	}
 	i, err := r.config.InterpreterFactory.NewInterpreter(gameFile, workingDir, log.Fields{
-		"game": name,
-		"room": r.ID,
+		"game":    game.Name,
+		"version": gameVersion.Number,
+		"room":    r.ID,
 	})
 	if err != nil {
 		r.logger.WithError(err).Error("starting interpreter")
 		return err
 	}
 
-	go r.listenForGameOutput(i.GetOutputChannel())
+	// Show the cover art (if any) before the game's opening text.
+	if r.replay == nil {
+		r.sendCoverArt(game, gameFile)
+	}
+
+	go r.listenForGameOutput(i)
 
 	err = i.Start()
 	if err != nil {
@@ -107,22 +171,56 @@ func (r *Room) startGame(name string) (err error) {
 	}
 
 	r.interpreter = i
+	r.game = game.Name
+	r.gameFile = gameFile
+	r.gameVersion = gameVersion.Number
+
+	_, err = r.config.Sessions.Begin(r.ID, r.name, game.Name, game.ID, gameVersion.Number, user)
+	if err != nil {
+		r.logger.WithError(err).Error("recording session start")
+	}
+
 	return nil
 }
 
-func (r *Room) listenForGameOutput(outchan chan *fizmo.Output) {
+// listenForGameOutput handles the interpreter's output until it closes.  By
+// the time anything arrives, the interpreter may no longer be the room's (it
+// may have been killed, and another game started), in which case the output is
+// ignored.
+func (r *Room) listenForGameOutput(i fizmo.Interpreter) {
 	r.logger.Info("setting up game output handler")
+	outchan := i.GetOutputChannel()
 	for {
 		output := <-outchan
+		r.mutex.Lock()
+		if r.interpreter != i {
+			r.mutex.Unlock()
+			if output == nil {
+				return
+			}
+			continue
+		}
 		if output == nil {
 			r.logger.Warn("game output has been closed")
-			r.killGame()
+			r.killGame("the game ended")
+			r.mutex.Unlock()
 			return
 		}
 		debugOutput := r.debugFormat(output)
 		r.logger.WithField("output", debugOutput).Debug("recieved output")
 
-		r.sendOutputMessage(output)
+		r.recordOutput(output)
+		if r.replay != nil {
+			r.replay.output = output
+		} else {
+			r.sendOutputMessage(output)
+		}
+		r.mutex.Unlock()
+
+		select {
+		case r.outputSignal <- true:
+		default:
+		}
 	}
 }
 
@@ -139,9 +237,21 @@ func (r *Room) sendOutputMessage(output *fizmo.Output) {
 
 	status := strings.Join(statusParts, " — ")
 
+	if status != "" {
+		err := r.config.Sessions.RecordStatus(r.ID, status)
+		if err != nil {
+			r.logger.WithError(err).Error("recording session status")
+		}
+	}
+
+	r.sendStoryText(output.Story, output.Choices, status)
+	r.offerVocabularyHint(output.Story)
+}
+
+func (r *Room) sendStoryText(story []*fizmo.Spans, choices []*fizmo.Choice, status string) {
 	lines := []string{}
 
-	for _, line := range output.Story {
+	for _, line := range story {
 		lines = append(lines, formatSpans(line))
 	}
 
@@ -159,7 +269,14 @@ func (r *Room) sendOutputMessage(output *fizmo.Output) {
 	}
 
 	msg := fmt.Sprintf("%s%s", leader, text)
-	r.sendMessageWithNameContext(msg, status, "game output")
+	if len(choices) > 0 {
+		msg = fmt.Sprintf("%s\n%s", msg, formatChoices(choices))
+	}
+
+	timestamp := r.sendMessageWithNameContext(msg, status, "game output")
+	if len(choices) > 0 {
+		r.offerChoices(timestamp, choices)
+	}
 }
 
 // func inferStatusWindow(w *fizmo.Window) bool {
@@ -172,13 +289,80 @@ func (r *Room) debugFormat(output *fizmo.Output) string {
 	return formatDebugOutput(output)
 }
 
-func (r *Room) killGame() {
-	r.logger.Info("recieved killGame request")
+func (r *Room) killGame(reason string) {
+	r.logger.WithField("reason", reason).Info("recieved killGame request")
+
+	// Kill() doesn't return until the interpreter has exited.  We forget about
+	// the interpreter *first*, so that its output listener (which ignores
+	// interpreters that aren't the room's) doesn't try to kill it again.
+	i := r.interpreter
+	if i == nil {
+		return
+	}
+
+	r.interpreter = nil
+	r.game = ""
+	r.gameFile = ""
+	r.gameVersion = 0
+	r.choiceMessage = ""
+	r.vocabulary = nil
+	r.vocabularyRead = false
+	r.lastGameCommand = ""
+	r.history = nil
+	if r.replay != nil {
+		r.replay.stopped = reason
+		r.replay = nil
+	}
+
+	err := r.config.Sessions.End(r.ID, reason)
+	if err != nil {
+		r.logger.WithError(err).Error("recording session end")
+	}
+
+	// TODO: stop listening to random stuff?
+	i.Kill()
+}
+
+// shutdownGame saves the in-progress game (as autosaveSlot) and stops it,
+// optionally letting the room know whether the save worked.
+func (r *Room) shutdownGame(announce bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 
-	if r.interpreter != nil {
-		// TODO: stop listening to random stuff?
-		r.interpreter.Kill()
-		r.interpreter = nil
+	if !r.gameInProgress() {
+		return
+	}
+
+	game := r.game
+	r.logger.WithField("game", game).Info("saving game for shutdown")
+
+	// The save is just the game's own "save" command and file name, sent
+	// straight to the interpreter (they aren't the players' turns).  Killing
+	// the interpreter closes its input, so it will finish processing the save
+	// before it exits.  The game might not have been ready to save (it could
+	// have been asking a question, say), so we check that the file was
+	// written before saying so.
+	before := fizmo.StampSave(r.workingDir(), autosaveSlot)
+	for _, input := range []string{"save", autosaveSlot} {
+		err := r.interpreter.Send(input)
+		if err != nil {
+			r.logger.WithError(err).Error("saving game for shutdown")
+		}
+	}
+	r.killGame("I shut down for maintenance")
+
+	saved := fizmo.StampSave(r.workingDir(), autosaveSlot).WrittenSince(before)
+	if !saved {
+		r.logger.WithField("game", game).Error("game wasn’t saved for shutdown")
+	}
+
+	if !announce {
+		return
+	}
+	if saved {
+		r.sendMessage(fmt.Sprintf("I’m going down for maintenance, but don’t worry: your game has been saved as *%[3]s*.  When I’m back, start it again with *play %[2]s* and then use *%[1]srestore %[3]s* to pick up where you left off.", metaCommandPrefix, game, autosaveSlot))
+	} else {
+		r.sendMessage(fmt.Sprintf("I’m going down for maintenance, and I’m sorry to say that I couldn’t save your game first (it may have been waiting for an answer to a question).  When I’m back, start it again with *play %s*, or *%srestore* an earlier save if you have one.", game, metaCommandPrefix))
 	}
 }
 
@@ -205,8 +389,8 @@ func (r *Room) sendMessage(text string) {
 // 	r.manager.sendMessageWithStatus(r.ID, text, status)
 // }
 
-func (r *Room) sendMessageWithNameContext(text string, status string, nameContext string) {
-	r.manager.sendMessageWithNameContext(r.ID, text, status, nameContext)
+func (r *Room) sendMessageWithNameContext(text string, status string, nameContext string) string {
+	return r.manager.sendMessageWithNameContext(r.ID, text, status, nameContext)
 }
 
 type commandContext struct {
@@ -251,7 +435,7 @@ func (r *Room) getCommandDescriptions() []*commandDescription {
 			false,
 			false,
 			"operational status about myself",
-			"[long help for status]",
+			"If you tell me *status*, I’ll tell you where I am and what game (if any) is being played here: who started it, how long ago, and how far along it is.  Admins can use *status all* to see the most recent game in every channel.",
 		},
 		&commandDescription{
 			"list",
@@ -259,7 +443,23 @@ func (r *Room) getCommandDescriptions() []*commandDescription {
 			false,
 			false,
 			"list the available games",
-			"[long help for list]",
+			"If you tell me *list*, I’ll list the available games a page at a time (tell me *list 2* for the second page, and so on).  You can narrow the list to a format (*list zcode*, *list z5*, *list ink*, *list twine*) or a tag (*list #horror*), and sort it *by title*, *by author*, *by newest*, or *by rating* (from the imported catalog): for example, *list #fantasy by newest*.",
+		},
+		&commandDescription{
+			"search",
+			r.commandSearch,
+			false,
+			false,
+			"search the games’ titles, authors, and descriptions",
+			"If you tell me *search _words_*, I’ll list the games that have all of those words in their names, titles, authors, or descriptions, best matches first.",
+		},
+		&commandDescription{
+			"info",
+			r.commandInfo,
+			false,
+			false,
+			"tell you all about a game",
+			"If you tell me *info _game-name_*, I’ll tell you everything I know about the game: its title, author, and description, and (if an admin has imported a catalog from IFDB) its genre, rating, play time, and awards.",
 		},
 		&commandDescription{
 			"play",
@@ -269,6 +469,46 @@ func (r *Room) getCommandDescriptions() []*commandDescription {
 			"with a game name (*play _game-name_\u200d*), starts _game-name_",
 			"[long help for play]",
 		},
+		&commandDescription{
+			"feelies",
+			r.commandFeelies,
+			false,
+			false,
+			"share a game’s maps, manuals, and other extras",
+			"If you tell me *feelies _game-name_*, I’ll share the extra files that came with the game (maps, manuals, hint sheets, and so on) in this channel; *feelies _game-name_ _file-name_* shares just one of them.  While a game is under way, *!feelies* is enough.  Admins can attach files to a game with *upload _url_ for _game-name_* (or by uploading a file with a `@xyzzybot upload for _game-name_` comment), and remove them with *detach*.",
+		},
+		&commandDescription{
+			"saves",
+			r.commandSaves,
+			false,
+			false,
+			"list the saved games in this channel",
+			"If you tell me to *saves*, I’ll list the games that have been saved in this channel, along with which game (and which release of it) each one belongs to.",
+		},
+		&commandDescription{
+			"restore",
+			r.commandRestore,
+			false,
+			true,
+			"with a save name (*%[1]srestore _save-name_*), restores a saved game",
+			"If you tell me to *!restore _save-name_* while a game is under way, I’ll check that the save really belongs to the game (and to the same release of it) before asking the game to restore it.  If the save was made with an earlier version of the game, I’ll switch to that version first.  You can use *saves* to see what saved games there are.",
+		},
+		&commandDescription{
+			"verbs",
+			r.commandVerbs,
+			false,
+			true,
+			"list the verbs the game understands",
+			"If you tell me *!verbs* while a game is under way, I’ll list the verbs the game understands (straight from its dictionary), and *!verbs _letters_* lists just those that start with _letters_.  When the game says it doesn’t know a word, I’ll suggest the closest words it does know, unless you tell me *!hints off*.  (This only works for Z-code games.)",
+		},
+		&commandDescription{
+			"hints",
+			r.commandHints,
+			false,
+			false,
+			"suggest words when the game doesn’t know one",
+			"When the game doesn’t know a word you used, I’ll suggest the closest words it does know.  Tell me *hints off* if you’d rather I didn’t, *hints on* to start again, or just *hints* to see which it is.",
+		},
 		&commandDescription{
 			"kill",
 			r.commandKill,
@@ -293,13 +533,109 @@ func (r *Room) getCommandDescriptions() []*commandDescription {
 		// 	"with a character (*%[1]skey x*), sends a raw key to the game",
 		// 	"[long help for key]",
 		// },
+		&commandDescription{
+			"announce",
+			r.commandAnnounce,
+			false,
+			false,
+			"announce new games in this channel",
+			"If you tell me *announce on*, I’ll post a note in this channel (with the title, author, and a short description) whenever a new game arrives, whether it was uploaded or simply dropped into my game directory.  Tell me *announce off* to stop, or just *announce* to see which it is.",
+		},
+		&commandDescription{
+			"policy",
+			r.commandPolicy,
+			false,
+			false,
+			"show (or change) which games can be played here",
+			"If you tell me *policy*, I’ll tell you which games can be played in this channel.  Admins can change it: *policy allow _game-name_* or *policy allow #_tag_* adds to the allowlist (if there is one, only those games can be played), *policy deny …* adds to the denylist, *policy remove …* takes a game or tag off either list, *policy rating _rating_* excludes games rated above _rating_ (see *rate*), and *policy clear* lets any game be played again.",
+		},
+		&commandDescription{
+			"author",
+			r.commandAuthor,
+			false,
+			false,
+			"replay the game on each new build of it",
+			"If you tell me *author on*, this channel will be in author mode: whenever a new build of the game being played here arrives (uploaded, or dropped into my game directory), I’ll restart the game on it, replay all of the commands sent so far, and tell you the first command the game responded to differently.  Tell me *author off* to stop, or just *author* to see which it is.",
+		},
+		&commandDescription{
+			"disk",
+			r.commandDisk,
+			true,
+			false,
+			"report the disk space used by each room",
+			"If you tell me *disk*, I’ll report how much disk space each room’s saves, transcripts, and snapshots are using, along with the retention policy.  If you tell me *disk sweep*, I’ll apply the retention policy right away (archiving and removing old files) instead of waiting for the next scheduled clean-up.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"backup",
+			r.commandBackup,
+			true,
+			false,
+			"back up all games, saves, and settings",
+			"If you tell me *backup*, I’ll write a single archive containing all of the games, session records, saves, and channel settings, and let you know when it’s done.  Games in progress aren’t interrupted, so their most recent saves are what’s backed up.  To restore it (on this host or a new one), stop me and run `xyzzybot restore _backup-file_`.  Note that this will only work if you’re a xyzzybot admin.",
+		},
 		&commandDescription{
 			"upload",
 			r.commandUpload,
 			true,
 			false,
 			"adds a new game to the system from a url",
-			"If you tell me to *upload _url-to-game_*, I’ll retrieve the game and add it to the list.  Note that this will only work if you’re a xyzzybot admin.  If you’re looking for games, try <http://ifdb.tads.org/|the Interactive Fiction Database>.  You can also add a new game to the system by uploading a file with a `@xyzzybot upload` comment.",
+			"If you tell me to *upload _url-to-game_*, I’ll retrieve the game and add it to the list.  Note that this will only work if you’re a xyzzybot admin.  If you’re looking for games, try <http://ifdb.tads.org/|the Interactive Fiction Database>.  You can also add a new game to the system by uploading a file with a `@xyzzybot upload` comment.  Besides Z-code games, I can play compiled Ink stories (JSON) and Twine stories (Twee source or published HTML).  Zip and tar.gz archives are unpacked: every story file inside is added, and the rest (manuals, maps, and so on) are kept with the game.  To attach a map, manual, or other extra to a game that’s already here, tell me *upload _url_ for _game-name_* (see *feelies*).",
+		},
+		&commandDescription{
+			"versions",
+			r.commandVersions,
+			true,
+			false,
+			"list the versions of a game",
+			"If you tell me *versions _game-name_*, I’ll list every version of the game that I’ve kept (each time a game is replaced, the old one is kept), which one new games use, and which channels are playing which.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"rollback",
+			r.commandRollback,
+			true,
+			false,
+			"go back to an earlier version of a game",
+			"If you tell me to *rollback _game-name_ _version_*, I’ll make that version of the game (see *versions*) the one that new games use.  Games in progress, and saves, stay with the version they started with.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"alias",
+			r.commandAlias,
+			true,
+			false,
+			"give a game another name",
+			"If you tell me *alias _alias_ _game-name_*, you’ll be able to refer to the game by _alias_ as well (as in *play _alias_*).  Tell me just *alias* to see them all, and *unalias _alias_* to remove one.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"unalias",
+			r.commandUnalias,
+			true,
+			false,
+			"remove a game alias",
+			"If you tell me *unalias _alias_*, I’ll remove that alias (see *alias*).  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"rate",
+			r.commandRate,
+			true,
+			false,
+			"give a game a content rating",
+			"If you tell me *rate _game-name_ _rating_*, I’ll give the game that content rating: one of _everyone_, _teen_, _mature_, or _adult_ (or *none* to remove it).  Channels can limit the ratings they allow with *policy rating*.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"categorize",
+			r.commandCategorize,
+			true,
+			false,
+			"put a game in categories",
+			"If you tell me *categorize _game-name_ #_category_ …*, I’ll put the game in those categories (replacing any it was in), or *categorize _game-name_ none* to take it out of them all.  Categories work just like the genres from a game’s metadata: you can *list #_category_*, and allow or deny them with *policy*.  Note that this will only work if you’re a xyzzybot admin.",
+		},
+		&commandDescription{
+			"detach",
+			r.commandDetach,
+			true,
+			false,
+			"remove an extra file from a game",
+			"If you tell me to *detach _game-name_ _file-name_*, I’ll remove that file from the game’s extras (see *feelies*).  Note that this will only work if you’re a xyzzybot admin.",
 		},
 		&commandDescription{
 			"delete",
@@ -313,10 +649,23 @@ func (r *Room) getCommandDescriptions() []*commandDescription {
 }
 
 func (r *Room) handleCommand(msgEvent *slack.MessageEvent, command string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// While the game is being replayed, the only thing to do is stop it.
+	if r.replay != nil {
+		if words := strings.Fields(command); len(words) > 0 && words[0] == metaCommandPrefix+"kill" {
+			r.commandKill(&commandContext{msgEvent}, "kill")
+			return
+		}
+		r.sendMessage(fmt.Sprintf("Hold on, I’m still replaying the game on its new build…  (Tell me *%skill* to stop it.)", metaCommandPrefix))
+		return
+	}
+
 	// If we have an interpreter, it gets the command.  Otherwise (or if there's
 	// a leading metaCommandPrefix), it's a meta-command.
 	if r.gameInProgress() && !strings.HasPrefix(command, metaCommandPrefix) {
-		r.interpreter.Send(command)
+		r.sendToGame(command)
 		return
 	}
 
@@ -447,13 +796,17 @@ func (r *Room) fromAdmin(cmdContext *commandContext) bool {
 func (r *Room) commandStatus(cmdContext *commandContext, command string, args ...string) {
 	admin := r.fromAdmin(cmdContext)
 
-	var inProgress string
-	if r.gameInProgress() {
-		inProgress = "There *is* currently a game in progress."
-	} else {
-		inProgress = "There *is not* currently a game in progress."
+	if len(args) > 0 && args[0] == "all" {
+		if !admin {
+			r.sendMessage("I’m sorry, only xyzzybot admins can see the status of every channel.")
+			return
+		}
+		r.statusAll()
+		return
 	}
 
+	inProgress := r.sessionStatus()
+
 	typeLinks := r.manager.getActiveRoomLinks()
 
 	channelList := formatRoomList(typeLinks[channelRoom], "channel")
@@ -488,26 +841,60 @@ func formatRoomList(list []string, label string) string {
 		return fmt.Sprintf("%ss %s and %s", label, list[0], list[1])
 	}
 
-	most := list[0 : len(list)-2]
+	most := list[0 : len(list)-1]
 	last := list[len(list)-1]
 	return fmt.Sprintf("%ss %s, and %s", label, strings.Join(most, ", "), last)
 }
 
 func (r *Room) commandList(cmdContext *commandContext, command string, args ...string) {
-	games, err := r.config.Games.GetGames()
+	opts, err := parseListOptions(args)
+	if err != nil {
+		r.sendMessage(fmt.Sprintf("I’m not sure what to list: %s.  You can tell me something like *list zcode #horror by newest 2*.", err.Error()))
+		return
+	}
+
+	all, err := r.config.Games.GetGames()
 	if err != nil {
 		r.logger.WithError(err).Error("unable to get games")
 		r.sendMessage(fmt.Sprintf("I’m sorry, I wasn’t able to get to the list of games.  Please let %s know something needs to be tweaked!", r.config.Admins[0]))
 		return
 	}
 
+	all, hidden := permittedGames(r.settings(), all)
+
+	matching, err := opts.filter(all)
+	if err != nil {
+		r.sendMessage(fmt.Sprintf("%s; I can sort by %s.", err.Error(), strings.Join(games.SortOrders, ", ")))
+		return
+	}
+
+	if len(matching) == 0 {
+		r.sendMessage(fmt.Sprintf("There aren’t any %s.%s", opts.describe(), formatHiddenGames(hidden)))
+		return
+	}
+
+	page, pages := paginate(matching, opts.page)
+	if len(page) == 0 {
+		r.sendMessage(fmt.Sprintf("There are only %d pages of %s.", pages, opts.describe()))
+		return
+	}
+
 	warning := ""
 
 	if r.gameInProgress() {
 		warning = fmt.Sprintf("\n\n_Do note that there's currently a game in progress; you’ll need to finish or `%skill` it before you can start a new game._", metaCommandPrefix)
 	}
 
-	msg := fmt.Sprintf("The following games are currently available:\n     *%s*\n\nYou can start a game using *play _game-name_*%s", strings.Join(games, "*\n     *"), warning)
+	header := fmt.Sprintf("The following %s are currently available:", opts.describe())
+	footer := ""
+	if pages > 1 {
+		header = fmt.Sprintf("The following %s are currently available (page %d of %d):", opts.describe(), opts.page, pages)
+		if opts.page < pages {
+			footer = fmt.Sprintf("\n_Tell me *list %s* to see the next page._", strings.Join(append(opts.words(), strconv.Itoa(opts.page+1)), " "))
+		}
+	}
+
+	msg := fmt.Sprintf("%s\n     %s%s%s\n\nYou can start a game using *play _game-name_*%s", header, formatGameEntries(page), footer, formatHiddenGames(hidden), warning)
 	r.sendMessage(msg)
 }
 
@@ -523,7 +910,22 @@ func (r *Room) commandPlay(cmdContext *commandContext, command string, args ...s
 		return
 	}
 
-	err := r.startGame(args[0])
+	game, err := r.config.Games.GetGame(strings.Join(args, " "))
+	if err != nil {
+		if nf, ok := err.(*games.NotFoundError); ok {
+			r.sendMessage(formatNotFound(nf))
+			return
+		}
+		r.sendMessage(fmt.Sprintf("There was a problem starting the game: “%s”", err.Error()))
+		return
+	}
+
+	if ok, reason := r.settings().Permits(game); !ok {
+		r.sendMessage(fmt.Sprintf("I’m sorry, *%s* can’t be played in this channel: %s.", game.Name, reason))
+		return
+	}
+
+	err = r.startGame(game.Name, cmdContext.msgEvent.User)
 	if err != nil {
 		// r.killGame()
 		r.sendMessage(fmt.Sprintf("There was a problem starting the game: “%s”", err.Error()))
@@ -536,7 +938,95 @@ func (r *Room) commandKill(cmdContext *commandContext, command string, args ...s
 		return
 	}
 
-	r.killGame()
+	r.killGame(fmt.Sprintf("killed by <@%s>", cmdContext.msgEvent.User))
+}
+
+func (r *Room) commandSaves(cmdContext *commandContext, command string, args ...string) {
+	slots, err := r.getSaveSlots()
+	if err != nil && !os.IsNotExist(err) {
+		r.logger.WithError(err).Error("unable to get saves")
+		r.sendMessage("I’m sorry, I wasn’t able to look for saved games in this channel.")
+		return
+	}
+
+	if len(slots) == 0 {
+		r.sendMessage("There aren’t any saved games in this channel yet.")
+		return
+	}
+
+	lines := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		lines = append(lines, r.formatSaveSlot(slot))
+	}
+
+	r.sendMessage(fmt.Sprintf("The following saved games are available in this channel:\n     %s", strings.Join(lines, "\n     ")))
+}
+
+func (r *Room) commandRestore(cmdContext *commandContext, command string, args ...string) {
+	if !r.gameInProgress() {
+		r.sendMessage("There's _not_ currently a game in progress!  Start the game with *play _game-name_* first, and then restore your save.")
+		return
+	}
+
+	if len(args) != 1 {
+		r.sendMessage(fmt.Sprintf("I expect one—and _only_ one—saved game to restore: *%srestore _save-name_*", metaCommandPrefix))
+		return
+	}
+
+	logger := r.logger.WithField("save", args[0])
+
+	slot, err := r.findSaveSlot(args[0])
+	if err != nil {
+		logger.WithError(err).Warn("finding save")
+		r.sendMessage(fmt.Sprintf("I can’t restore *%s*... %s.", args[0], err.Error()))
+		return
+	}
+
+	// State saves (from Ink stories, say) just need to be from the same
+	// story; the interpreter checks the rest.
+	if slot.state != nil {
+		if !r.isCurrentGame(slot.state.IFID) {
+			logger.Warn("save from another game")
+			msg := fmt.Sprintf("I won’t restore *%s* into _%s_: it was saved from a different game.", slot.name, r.game)
+			if game, ok := r.identifyStateSave(slot.state); ok {
+				msg = fmt.Sprintf("%s  It looks like it belongs to _%s_ instead.", msg, game)
+			}
+			r.sendMessage(msg)
+			return
+		}
+
+		r.sendToGame("restore")
+		r.sendToGame(slot.name)
+		return
+	}
+
+	header, err := zmachine.ReadHeader(r.gameFile)
+	if err != nil {
+		logger.WithError(err).Error("reading game header")
+		r.sendMessage(fmt.Sprintf("I can’t restore *%s*... I wasn’t able to read the game’s header: %s", slot.name, err.Error()))
+		return
+	}
+
+	err = slot.save.CheckCompatible(header)
+	if err != nil {
+		if v := r.findSaveVersion(slot.save); v != nil {
+			logger.WithField("version", v.Number).Info("restoring into matching version")
+			r.restoreIntoVersion(slot, v, cmdContext.msgEvent.User)
+			return
+		}
+
+		logger.WithError(err).Warn("incompatible save")
+		msg := fmt.Sprintf("I won’t restore *%s* into _%s_: %s.", slot.name, r.game, err.(*quetzal.MismatchError).Reason)
+		if game, ifid, ok := r.identifySave(slot.save); ok && !r.isCurrentGame(ifid) {
+			msg = fmt.Sprintf("%s  It looks like it belongs to _%s_ instead.", msg, game)
+		}
+		r.sendMessage(msg)
+		return
+	}
+
+	// The game's own "restore" command prompts for the file name...
+	r.sendToGame("restore")
+	r.sendToGame(slot.name)
 }
 
 // func (r *Room) commandSpace(cmdContext *commandContext, command string, args ...string) {
@@ -567,8 +1057,16 @@ func (r *Room) commandKill(cmdContext *commandContext, command string, args ...s
 var gameLink = regexp.MustCompile("<(.+)(|.+)?>")
 
 func (r *Room) commandUpload(cmdContext *commandContext, command string, args ...string) {
+	// “upload _url_ for _game-name_” attaches the file to a game, rather than
+	// adding a game.
+	forGame := ""
+	if len(args) > 2 && strings.EqualFold(args[1], "for") {
+		forGame = strings.Join(args[2:], " ")
+		args = args[:1]
+	}
+
 	if len(args) != 1 {
-		r.sendMessage("I expect one—and _only_ one—URL from which to retrieve a game file: *upload _url-to-game_*")
+		r.sendMessage("I expect one—and _only_ one—URL from which to retrieve a game file: *upload _url-to-game_* (or *upload _url-to-file_ for _game-name_* to add a map, manual, or other extra to a game)")
 		return
 	}
 
@@ -594,14 +1092,26 @@ func (r *Room) commandUpload(cmdContext *commandContext, command string, args ..
 	}
 
 	filename := path.Base(urlParts.Path)
-	err = r.manager.downloadGame(uri, filename)
+	if forGame != "" {
+		summary, err := r.manager.downloadCompanions(uri, filename, forGame)
+		if err != nil {
+			logger.WithError(err).Error("downloading")
+			r.sendMessage(err.Error())
+			return
+		}
+		r.sendMessage(summary)
+		return
+	}
+
+	result, err := r.manager.downloadGame(uri, filename, cmdContext.msgEvent.User)
 	if err != nil {
 		logger.WithError(err).Error("downloading")
 		r.sendMessage(err.Error())
 		return
 	}
 
-	r.sendMessage(fmt.Sprintf("Upload complete!  The game *%s* is now available!", filename))
+	r.sendMessage(r.manager.uploadSummary(result))
+	r.manager.reloadAuthorGames(result.Games)
 
 }
 
@@ -631,6 +1141,27 @@ func (r *Room) commandUnknown(cmdContext *commandContext, command string, args .
 	r.sendMessage(fmt.Sprintf("I’m sorry, I don’t know how to `%s`.", command))
 }
 
+// isCurrentGame reports whether the in-progress game has the given IFID.
+func (r *Room) isCurrentGame(ifid string) bool {
+	game, err := r.config.Games.GetGame(r.game)
+	return err == nil && game.HasIFID(ifid)
+}
+
+func (r *Room) sendToGame(command string) {
+	err := r.interpreter.Send(command)
+	if err != nil {
+		r.logger.WithError(err).Error("sending to game")
+		return
+	}
+	r.lastGameCommand = command
+	r.recordCommand(command)
+
+	err = r.config.Sessions.RecordTurn(r.ID)
+	if err != nil {
+		r.logger.WithError(err).Error("recording session turn")
+	}
+}
+
 func (r *Room) gameInProgress() bool {
 	// Should we also check to see that the underlying process is really
 	// working?  (This could/should be exposed as a helper on Interpreter
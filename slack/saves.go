@@ -0,0 +1,138 @@
+package slack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/quetzal"
+)
+
+// saveSlot is a save file in a room's working directory: a Quetzal save (for
+// Z-code), or a state save (for the in-process interpreters).  The slot name
+// is the file name without its extension, which is what a player types at the
+// game's "restore" prompt.
+type saveSlot struct {
+	name    string
+	file    string
+	modTime time.Time
+	save    *quetzal.Save
+	state   *fizmo.StateSave
+}
+
+func (r *Room) workingDir() string {
+	return path.Join(r.config.WorkingRoot, r.ID)
+}
+
+// getSaveSlots returns the save files in the room's working directory, most
+// recent first.  Files that aren't saves are skipped.
+func (r *Room) getSaveSlots() ([]*saveSlot, error) {
+	infos, err := ioutil.ReadDir(r.workingDir())
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]*saveSlot, 0)
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		file := path.Join(r.workingDir(), info.Name())
+		slot := &saveSlot{
+			name:    strings.TrimSuffix(info.Name(), path.Ext(info.Name())),
+			file:    file,
+			modTime: info.ModTime(),
+		}
+
+		if path.Ext(info.Name()) == fizmo.StateSaveExtension {
+			slot.state, err = fizmo.ReadStateSave(file)
+		} else {
+			slot.save, err = quetzal.ReadFile(file)
+		}
+		if err != nil {
+			continue
+		}
+
+		slots = append(slots, slot)
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].modTime.After(slots[j].modTime)
+	})
+
+	return slots, nil
+}
+
+func (r *Room) findSaveSlot(name string) (*saveSlot, error) {
+	slots, err := r.getSaveSlots()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, slot := range slots {
+		if strings.EqualFold(slot.name, name) {
+			return slot, nil
+		}
+	}
+
+	return nil, fmt.Errorf("there’s no saved game called “%s” here", name)
+}
+
+// identifySave finds the game that a save belongs to, by comparing the save
+// against the story info of all the available games.  It returns the game's
+// name and IFID.
+func (r *Room) identifySave(save *quetzal.Save) (string, string, bool) {
+	all, err := r.config.Games.GetGames()
+	if err != nil {
+		r.logger.WithError(err).Error("unable to get games")
+		return "", "", false
+	}
+
+	for _, game := range all {
+		info := game.Story
+		if info == nil || info.Format != games.ZCodeFormat && info.Format != games.BlorbFormat {
+			continue
+		}
+
+		if save.Release == info.Release && save.Serial == info.Serial && save.Checksum == info.Checksum {
+			return game.Name, game.ID, true
+		}
+	}
+
+	return "", "", false
+}
+
+// identifyStateSave finds the game that a state save belongs to, by its IFID.
+func (r *Room) identifyStateSave(save *fizmo.StateSave) (string, bool) {
+	game, err := r.config.Games.GetGame(save.IFID)
+	if err != nil || !game.HasIFID(save.IFID) {
+		return "", false
+	}
+	return game.Name, true
+}
+
+func (r *Room) formatSaveSlot(slot *saveSlot) string {
+	var game string
+	var ok bool
+	var description fmt.Stringer = slot.save
+	if slot.state != nil {
+		game, ok = r.identifyStateSave(slot.state)
+		description = slot.state
+	} else {
+		game, _, ok = r.identifySave(slot.save)
+	}
+
+	if !ok {
+		game = "an unknown game"
+	} else {
+		game = fmt.Sprintf("_%s_", game)
+	}
+
+	return fmt.Sprintf("*%s* — %s (%s), saved %s", slot.name, game, description, slot.modTime.Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,119 @@
+package slack
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JaredReisinger/xyzzybot/sessions"
+)
+
+// endStaleSession closes out a session that was still marked as active when
+// the room was (re-)created... which means the bot stopped without ending it.
+func (r *Room) endStaleSession() {
+	session, err := r.config.Sessions.Current(r.ID)
+	if err != nil {
+		r.logger.WithError(err).Error("getting current session")
+		return
+	}
+
+	if session == nil {
+		return
+	}
+
+	r.logger.WithField("game", session.Game).Info("ending stale session")
+	err = r.config.Sessions.End(r.ID, "interrupted when I restarted")
+	if err != nil {
+		r.logger.WithError(err).Error("ending stale session")
+	}
+}
+
+// sessionStatus describes the current (or most recent) game in the room.
+func (r *Room) sessionStatus() string {
+	session, err := r.config.Sessions.Latest(r.ID)
+	if err != nil {
+		r.logger.WithError(err).Error("getting latest session")
+	}
+
+	if r.gameInProgress() {
+		if session == nil || !session.Active() {
+			return "There *is* currently a game in progress."
+		}
+		return fmt.Sprintf("There *is* currently a game in progress: %s", formatSession(session))
+	}
+
+	if session == nil {
+		return "There *is not* currently a game in progress, and there’s never been one here."
+	}
+
+	return fmt.Sprintf("There *is not* currently a game in progress.  The last game here was %s", formatSession(session))
+}
+
+// statusAll describes the most recent game in every room.
+func (r *Room) statusAll() {
+	all, err := r.config.Sessions.All()
+	if err != nil {
+		r.logger.WithError(err).Error("getting all sessions")
+		r.sendMessage("I’m sorry, I wasn’t able to get to the session records.")
+		return
+	}
+
+	if len(all) == 0 {
+		r.sendMessage("Nobody has played any games yet.")
+		return
+	}
+
+	lines := make([]string, 0, len(all)+1)
+	lines = append(lines, "Here’s the most recent game in each channel:")
+	for _, session := range all {
+		state := "ended"
+		if session.Active() {
+			state = "*in progress*"
+		}
+		lines = append(lines, fmt.Sprintf("     <#%s|%s> (%s): %s", session.Room, session.RoomName, state, formatSession(session)))
+	}
+
+	r.sendMessage(strings.Join(lines, "\n"))
+}
+
+func formatSession(session *sessions.Session) string {
+	parts := []string{
+		fmt.Sprintf("_%s_, started by <@%s> %s", session.Game, session.StartedBy, formatAgo(session.StartTime)),
+		fmt.Sprintf("%d turns, last played %s", session.Turns, formatAgo(session.LastActivity)),
+	}
+
+	if !session.Active() {
+		parts = append(parts, fmt.Sprintf("ended %s (%s)", formatAgo(session.EndTime), session.EndReason))
+	}
+
+	msg := strings.Join(parts, "; ")
+
+	if session.LastStatus != "" {
+		msg = fmt.Sprintf("%s.  Last status: %s", msg, session.LastStatus)
+	}
+
+	return msg
+}
+
+// formatAgo returns a rough, human-friendly "how long ago" for t.
+func formatAgo(t time.Time) string {
+	d := time.Since(t)
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return pluralAgo(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return pluralAgo(int(d/time.Hour), "hour")
+	default:
+		return pluralAgo(int(d/(24*time.Hour)), "day")
+	}
+}
+
+func pluralAgo(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s ago", unit)
+	}
+	return fmt.Sprintf("%d %ss ago", n, unit)
+}
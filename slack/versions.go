@@ -0,0 +1,115 @@
+package slack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/quetzal"
+)
+
+func (r *Room) commandVersions(cmdContext *commandContext, command string, args ...string) {
+	if len(args) != 1 {
+		r.sendMessage("I expect one—and _only_ one—game to list the versions of: *versions _game-name_*")
+		return
+	}
+
+	versions, err := r.config.Games.GetVersions(args[0])
+	if err != nil {
+		r.logger.WithField("game", args[0]).WithError(err).Warn("getting versions")
+		r.sendMessage(fmt.Sprintf("I can’t list the versions of *%s*... %s", args[0], err.Error()))
+		return
+	}
+
+	inUse := r.manager.versionsInUse(args[0])
+
+	lines := make([]string, 0, len(versions))
+	for _, v := range versions {
+		line := fmt.Sprintf("*%d* — %s, added %s", v.Number, v, v.AddedAt.Format("2006-01-02 15:04"))
+		if v.AddedBy != "" {
+			line = fmt.Sprintf("%s by <@%s>", line, v.AddedBy)
+		}
+		if v.Current {
+			line = fmt.Sprintf("%s  _(current)_", line)
+		}
+		if rooms := inUse[v.Number]; len(rooms) > 0 {
+			line = fmt.Sprintf("%s  _(being played in %s)_", line, strings.Join(rooms, ", "))
+		}
+		lines = append(lines, line)
+	}
+
+	r.sendMessage(fmt.Sprintf("These are the versions of *%s* I know about:\n     %s\n\nNew games use the current version; games in progress (and saves) stay with the version they started with.  You can go back to an earlier version with *rollback %s _version_*.", args[0], strings.Join(lines, "\n     "), args[0]))
+}
+
+func (r *Room) commandRollback(cmdContext *commandContext, command string, args ...string) {
+	if len(args) != 2 {
+		r.sendMessage("I expect a game and a version to roll back to: *rollback _game-name_ _version_*")
+		return
+	}
+
+	v, err := r.config.Games.RollbackGame(args[0], args[1])
+	if err != nil {
+		r.logger.WithField("game", args[0]).WithField("version", args[1]).WithError(err).Warn("rolling back")
+		r.sendMessage(fmt.Sprintf("I couldn’t roll back *%s*... %s", args[0], err.Error()))
+		return
+	}
+
+	r.sendMessage(fmt.Sprintf("Rollback complete!  New games of *%s* will use version %d (%s).  Games already in progress aren’t affected.", args[0], v.Number, v))
+}
+
+// versionsInUse finds the rooms playing each version of a game.  (The rooms'
+// sessions say what they're playing; see Room.mutex.)
+func (manager *Manager) versionsInUse(name string) map[int][]string {
+	inUse := make(map[int][]string)
+
+	game, err := manager.config.Games.GetGame(name)
+	if err != nil {
+		return inUse
+	}
+
+	for _, r := range manager.allRooms() {
+		session, err := manager.config.Sessions.Current(r.ID)
+		if err == nil && session != nil && session.Game == game.Name {
+			inUse[session.Version] = append(inUse[session.Version], r.link)
+		}
+	}
+
+	return inUse
+}
+
+// findSaveVersion looks for another version of the in-progress game that a
+// save belongs to (a save made before the game was updated, say).
+func (r *Room) findSaveVersion(save *quetzal.Save) *games.GameVersion {
+	versions, err := r.config.Games.GetVersions(r.game)
+	if err != nil {
+		r.logger.WithError(err).Warn("getting versions")
+		return nil
+	}
+
+	for _, v := range versions {
+		if v.Number != r.gameVersion &&
+			save.Release == v.Release && save.Serial == v.Serial && save.Checksum == v.ZChecksum {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// restoreIntoVersion restarts the game on the version that a save belongs to,
+// and then restores it.
+func (r *Room) restoreIntoVersion(slot *saveSlot, v *games.GameVersion, user string) {
+	game := r.game
+	r.sendMessage(fmt.Sprintf("*%s* was saved in version %d of _%s_ (%s), so I’m switching to that version to restore it.", slot.name, v.Number, game, v))
+
+	r.killGame("switching versions to restore a save")
+	err := r.startGameVersion(game, strconv.Itoa(v.Number), user)
+	if err != nil {
+		r.sendMessage(fmt.Sprintf("I’m sorry, I wasn’t able to start version %d of _%s_: %s", v.Number, game, err.Error()))
+		return
+	}
+
+	r.sendToGame("restore")
+	r.sendToGame(slot.name)
+}
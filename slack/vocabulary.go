@@ -0,0 +1,251 @@
+package slack
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/JaredReisinger/xyzzybot/channels"
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+	"github.com/JaredReisinger/xyzzybot/zmachine"
+)
+
+// promptingVerbs are commands after which the game asks for something that
+// won't be in its vocabulary, like a file name.  (Inform marks these as "meta"
+// verbs; Infocom's games don't, so we list the usual ones.)
+var promptingVerbs = map[string]bool{
+	"save": true, "restore": true, "load": true, "script": true,
+	"quit": true, "q": true, "restart": true,
+}
+
+// Quoted text ("say “hello”") isn't parsed as words.
+var quoted = regexp.MustCompile(`"[^"]*"|“[^”]*”`)
+
+// gameVocabulary returns the in-progress game's dictionary, or nil if it
+// doesn't have one (it isn't Z-code, say).
+func (r *Room) gameVocabulary() *zmachine.Dictionary {
+	if !r.vocabularyRead && r.gameFile != "" {
+		r.vocabularyRead = true
+		d, err := zmachine.ReadDictionary(r.gameFile)
+		if err != nil {
+			r.logger.WithError(err).Debug("no dictionary for game")
+		} else {
+			r.logger.WithField("words", len(d.Words)).Debug("read game dictionary")
+		}
+		r.vocabulary = d
+	}
+	return r.vocabulary
+}
+
+// looksLikeGameCommand uses the in-progress game's vocabulary to decide
+// whether a message is meant for the game: it has to start with a verb the game
+// knows (perhaps after "someone,"), or be a single word the game knows (like a
+// direction), and most of its words have to be ones the game knows.  The
+// second result is false when we can't tell: there's no game in progress, we
+// don't know its vocabulary, or the game has just asked for something it
+// wouldn't know the word for, like a file name.
+func (r *Room) looksLikeGameCommand(text string) (bool, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.gameInProgress() {
+		return false, false
+	}
+	vocabulary := r.gameVocabulary()
+	if vocabulary == nil {
+		return false, false
+	}
+
+	if last := vocabulary.Tokenize(r.lastGameCommand); len(last) > 0 {
+		if w := vocabulary.Lookup(last[0]); promptingVerbs[last[0]] || w != nil && w.Meta {
+			return false, false
+		}
+	}
+
+	words := vocabulary.Tokenize(quoted.ReplaceAllString(text, " "))
+
+	known := 0
+	counted := 0
+	for _, word := range words {
+		if strings.Contains(vocabulary.Separators, word) {
+			continue
+		}
+		counted++
+		if _, err := strconv.Atoi(word); err == nil || vocabulary.Lookup(word) != nil {
+			known++
+		}
+	}
+
+	switch {
+	case counted == 0:
+		return false, true
+	case counted == 1:
+		return known == 1, true
+	case known*2 < counted:
+		return false, true
+	}
+
+	if isVerb(vocabulary, words[0]) {
+		return true, true
+	}
+
+	// Orders to other characters look like "robot, go north".
+	for i, word := range words[:len(words)-1] {
+		if word == "," {
+			return isVerb(vocabulary, words[i+1]), true
+		}
+	}
+
+	return false, true
+}
+
+func isVerb(vocabulary *zmachine.Dictionary, word string) bool {
+	w := vocabulary.Lookup(word)
+	return w != nil && (w.Verb || w.Direction)
+}
+
+// The number of suggestions offered for each unknown word...
+const maxWordSuggestions = 3
+
+// Parser responses that mean the player used a word the game doesn't know:
+// Infocom's names the word, Inform's don't.
+var (
+	unknownWordResponse   = regexp.MustCompile(`(?i)I don['’]t know the word ["“'‘]([^"”'’]+)`)
+	unknownVerbResponse   = regexp.MustCompile(`(?i)That['’]s not a verb I recogni[sz]e`)
+	notUnderstoodResponse = regexp.MustCompile(`(?i)I didn['’]t understand that sentence|You can['’]t see any such thing`)
+)
+
+func (r *Room) commandVerbs(cmdContext *commandContext, command string, args ...string) {
+	vocabulary := r.gameVocabulary()
+	if vocabulary == nil {
+		r.sendMessage("I can’t read this game’s vocabulary; it only works for Z-code games.")
+		return
+	}
+
+	verbs := vocabulary.Verbs()
+	if len(args) > 0 {
+		prefix := strings.ToLower(args[0])
+		matching := make([]string, 0)
+		for _, verb := range verbs {
+			if strings.HasPrefix(verb, prefix) {
+				matching = append(matching, verb)
+			}
+		}
+		if len(matching) == 0 {
+			r.sendMessage(fmt.Sprintf("The game doesn’t understand any verbs that start with “%s”.", prefix))
+			return
+		}
+		r.sendMessage(fmt.Sprintf("The verbs *%s* understands that start with “%s”:\n%s", r.game, prefix, strings.Join(matching, ", ")))
+		return
+	}
+
+	if len(verbs) == 0 {
+		r.sendMessage(fmt.Sprintf("*%s* doesn’t mark which of its words are verbs, so I can’t list them.", r.game))
+		return
+	}
+	r.sendMessage(fmt.Sprintf("The %d verbs *%s* understands:\n%s", len(verbs), r.game, strings.Join(verbs, ", ")))
+}
+
+func (r *Room) commandHints(cmdContext *commandContext, command string, args ...string) {
+	if r.manager.config.Channels == nil {
+		r.sendMessage("I’m not able to keep track of channel settings right now.")
+		return
+	}
+
+	if len(args) == 0 {
+		if r.settings().NoHints {
+			r.sendMessage("I don’t suggest words here when the game doesn’t know one.  Tell me *hints on* if you’d like me to.")
+		} else {
+			r.sendMessage("I suggest words here when the game doesn’t know one.  Tell me *hints off* if you’d rather I didn’t.")
+		}
+		return
+	}
+
+	var hints bool
+	switch strings.ToLower(args[0]) {
+	case "on", "yes":
+		hints = true
+	case "off", "no":
+		hints = false
+	default:
+		r.sendMessage("You can tell me *hints on* or *hints off*.")
+		return
+	}
+
+	err := r.manager.config.Channels.Update(r.ID, func(settings *channels.Settings) {
+		settings.NoHints = !hints
+	})
+	if err != nil {
+		r.logger.WithError(err).Error("updating channel settings")
+		r.sendMessage(fmt.Sprintf("I couldn’t change this channel’s settings: %s", err.Error()))
+		return
+	}
+
+	if hints {
+		r.sendMessage("Okay, I’ll suggest words when the game doesn’t know one.")
+	} else {
+		r.sendMessage("Okay, I won’t suggest words any more.")
+	}
+}
+
+// offerVocabularyHint follows the game's "I don't know that word" responses
+// with the closest words it does know.
+func (r *Room) offerVocabularyHint(story []*fizmo.Spans) {
+	vocabulary := r.gameVocabulary()
+	if vocabulary == nil || r.lastGameCommand == "" {
+		return
+	}
+
+	text := fizmo.StoryText(story)
+	var unknown []string
+	verbs := false
+	words := vocabulary.Tokenize(r.lastGameCommand)
+
+	if m := unknownWordResponse.FindStringSubmatch(text); m != nil {
+		unknown = []string{m[1]}
+	} else if unknownVerbResponse.MatchString(text) && len(words) > 0 {
+		unknown = words[:1]
+		verbs = true
+	} else if notUnderstoodResponse.MatchString(text) {
+		for _, word := range words {
+			if _, err := strconv.Atoi(word); err != nil && !strings.Contains(vocabulary.Separators, word) && vocabulary.Lookup(word) == nil {
+				unknown = append(unknown, word)
+			}
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	hints := make([]string, 0, len(unknown))
+	for _, word := range unknown {
+		similar := vocabulary.Similar(word, maxWordSuggestions, verbs)
+		if len(similar) > 0 {
+			hints = append(hints, fmt.Sprintf("Instead of “%s”, did you mean %s?", word, formatWordChoices(similar)))
+		}
+	}
+	if len(hints) == 0 && !verbs {
+		return
+	}
+
+	if r.settings().NoHints {
+		return
+	}
+
+	if verbs {
+		hints = append(hints, fmt.Sprintf("(*%sverbs* lists the verbs the game understands.)", metaCommandPrefix))
+	}
+	r.sendMessage(fmt.Sprintf("_%s_", strings.Join(hints, "  ")))
+}
+
+func formatWordChoices(words []string) string {
+	names := make([]string, len(words))
+	for i, w := range words {
+		names[i] = fmt.Sprintf("*%s*", w)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return fmt.Sprintf("%s or %s", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/games"
+	"github.com/JaredReisinger/xyzzybot/objstore"
+)
+
+const defaultRefreshSeconds = 60
+
+// localGameDirectory is where the game files live on this host: the game
+// directory itself, or the cache of the bucket.
+func localGameDirectory(config *Config) string {
+	if config.Storage.Type == "s3" && config.Storage.S3.CacheDirectory != "" {
+		return config.Storage.S3.CacheDirectory
+	}
+	return config.GameDirectory
+}
+
+// newGameRepository creates the repository chosen by the storage config.
+func newGameRepository(config *Config, logBase log.FieldLogger) (games.Repository, error) {
+	switch config.Storage.Type {
+	case "", "filesystem":
+		return &games.FileSys{
+			Directory: config.GameDirectory,
+			Logger:    logBase,
+		}, nil
+
+	case "s3":
+		return newObjectStore(config, logBase)
+	}
+
+	return nil, fmt.Errorf("unknown storage type %q (expected \"filesystem\" or \"s3\")", config.Storage.Type)
+}
+
+func newObjectStore(config *Config, logBase log.FieldLogger) (*games.ObjectStore, error) {
+	s3 := config.Storage.S3
+	logger := logBase.WithFields(log.Fields{
+		"component": "main",
+		"endpoint":  s3.Endpoint,
+		"bucket":    s3.Bucket,
+	})
+
+	if s3.Endpoint == "" || s3.Bucket == "" {
+		return nil, fmt.Errorf("s3 storage needs an endpoint and a bucket")
+	}
+
+	if s3.SecretKeyFile != "" && s3.SecretKey == "" {
+		key, err := readTokenFile(s3.SecretKeyFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		s3.SecretKey = key
+	}
+
+	if s3.RefreshSeconds <= 0 {
+		s3.RefreshSeconds = defaultRefreshSeconds
+	}
+
+	client := &objstore.Client{
+		Endpoint:    s3.Endpoint,
+		Region:      s3.Region,
+		Bucket:      s3.Bucket,
+		AccessKey:   s3.AccessKey,
+		SecretKey:   s3.SecretKey,
+		VirtualHost: s3.VirtualHost,
+	}
+
+	err := client.EnsureBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	store := &games.ObjectStore{
+		Client:          client,
+		Prefix:          s3.Prefix,
+		CacheDirectory:  localGameDirectory(config),
+		RefreshInterval: time.Duration(s3.RefreshSeconds) * time.Second,
+		Logger:          logBase,
+	}
+
+	logger.WithField("cache", store.CacheDirectory).Info("syncing games with bucket")
+	err = store.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/backup"
+	"github.com/JaredReisinger/xyzzybot/walkthrough"
+)
+
+// Subcommands are run as `xyzzybot <subcommand> [flags] [args]`, and do their
+// work without connecting to Slack or starting the console.
+var subcommands = map[string]func(args []string, logBase *log.Logger){
+	"backup":         runBackupCommand,
+	"restore":        runRestoreCommand,
+	"import-catalog": runImportCatalogCommand,
+	"walkthrough":    runWalkthroughCommand,
+}
+
+// subcommandFlags holds the config-related flags shared by all subcommands.
+type subcommandFlags struct {
+	config      *string
+	gameDir     *string
+	workingRoot *string
+}
+
+func addSubcommandFlags(fs *flag.FlagSet) *subcommandFlags {
+	return &subcommandFlags{
+		config:      fs.String("config", "", "The path to the JSON config file to load"),
+		gameDir:     fs.String("game-dir", "", "directory to use for games"),
+		workingRoot: fs.String("working-root", "", "directory root for dynamic configuration and game saves/state"),
+	}
+}
+
+// loadConfig resolves the config the same way the bot itself does, except
+// that no bot token is needed.
+func (f *subcommandFlags) loadConfig(logBase *log.Logger, logger log.FieldLogger) *Config {
+	configFile := *f.config
+	requireConfig := !fallback("config file", &configFile, defaultConfigFile, logger)
+
+	config, err := ParseConfigFile(configFile, logBase)
+	if err != nil && requireConfig {
+		logger.WithField("file", configFile).WithError(err).Fatal("error parsing config file")
+	}
+
+	overrideParam("game directory", &config.GameDirectory, f.gameDir, logger)
+	overrideParam("working root", &config.WorkingRoot, f.workingRoot, logger)
+	fallback("game directory", &config.GameDirectory, defaultGameDirectory, logger)
+	fallback("working root", &config.WorkingRoot, defaultWorkingRoot, logger)
+	fallback("archive directory", &config.Retention.ArchiveDirectory, path.Join(config.WorkingRoot, "archive"), logger)
+
+	return config
+}
+
+// backupSources returns what gets backed up: all of the games, and everything
+// in the working root except for the backups themselves and the retention
+// archives.
+func backupSources(config *Config) []backup.Source {
+	exclude := []string{"backups"}
+	if path.Dir(path.Clean(config.Retention.ArchiveDirectory)) == path.Clean(config.WorkingRoot) {
+		exclude = append(exclude, path.Base(config.Retention.ArchiveDirectory))
+	}
+
+	return []backup.Source{
+		{Section: "games", Directory: localGameDirectory(config)},
+		{Section: "state", Directory: config.WorkingRoot, Exclude: exclude},
+	}
+}
+
+func backupDirectory(config *Config) string {
+	return path.Join(config.WorkingRoot, "backups")
+}
+
+func runBackupCommand(args []string, logBase *log.Logger) {
+	logger := logBase.WithField("component", "backup")
+
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags := addSubcommandFlags(fs)
+	outParam := fs.String("out", "", "file to write the backup to (defaults to a timestamped file in the working root's backups directory)")
+	fs.Parse(args)
+
+	config := flags.loadConfig(logBase, logger)
+	plan := &backup.Plan{
+		Sources:   backupSources(config),
+		Directory: backupDirectory(config),
+		Logger:    logBase,
+	}
+
+	var file string
+	var manifest *backup.Manifest
+	var err error
+	if *outParam != "" {
+		file = *outParam
+		manifest, err = backup.CreateFile(file, plan.Sources, logBase)
+	} else {
+		file, manifest, err = plan.Run()
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("creating backup")
+	}
+
+	fmt.Printf("backup written to %s\n", file)
+	printManifest(manifest)
+}
+
+func runRestoreCommand(args []string, logBase *log.Logger) {
+	logger := logBase.WithField("component", "restore")
+
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags := addSubcommandFlags(fs)
+	dryRunParam := fs.Bool("dry-run", false, "only verify the backup, don't restore anything")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xyzzybot restore [flags] backup-file\n\nRestores games and state from a backup.  Stop the bot first!\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := fs.Arg(0)
+
+	if *dryRunParam {
+		manifest, err := backup.Verify(file)
+		if err != nil {
+			logger.WithError(err).Fatal("verifying backup")
+		}
+		fmt.Printf("%s is a valid backup\n", file)
+		printManifest(manifest)
+		return
+	}
+
+	config := flags.loadConfig(logBase, logger)
+	manifest, err := backup.Restore(file, map[string]string{
+		"games": localGameDirectory(config),
+		"state": config.WorkingRoot,
+	}, logBase)
+	if err != nil {
+		logger.WithError(err).Fatal("restoring backup")
+	}
+
+	fmt.Printf("restored %s\n", file)
+	printManifest(manifest)
+}
+
+func printManifest(manifest *backup.Manifest) {
+	fmt.Printf("  created %s on %s\n", manifest.Created.Format("2006-01-02 15:04:05"), manifest.Host)
+	for _, s := range manifest.Sections {
+		fmt.Printf("  %-6s %5d files, %10d bytes (from %s)\n", s.Name, s.Files, s.Bytes, s.Directory)
+	}
+}
+
+func runImportCatalogCommand(args []string, logBase *log.Logger) {
+	logger := logBase.WithField("component", "catalog")
+
+	fs := flag.NewFlagSet("import-catalog", flag.ExitOnError)
+	flags := addSubcommandFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xyzzybot import-catalog [flags] catalog-file\n\nImports an iFiction catalog (like an IFDB export) into the game library, matching entries to games by IFID.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	file := fs.Arg(0)
+
+	config := flags.loadConfig(logBase, logger)
+	repo, err := newGameRepository(config, logBase)
+	if err != nil {
+		logger.WithError(err).Fatal("setting up game storage")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		logger.WithError(err).Fatal("opening catalog")
+	}
+	defer f.Close()
+
+	result, err := repo.ImportCatalog(f)
+	if err != nil {
+		logger.WithField("file", file).WithError(err).Fatal("importing catalog")
+	}
+
+	fmt.Printf("imported %d entries from %s (%d in the catalog)\n", result.Entries, file, result.Total)
+	for _, game := range result.Matched {
+		fmt.Printf("  %-20s %s\n", game.Name, game.Title())
+	}
+	if len(result.Matched) == 0 {
+		fmt.Printf("  (none of them match the games in the library)\n")
+	}
+}
+
+func runWalkthroughCommand(args []string, logBase *log.Logger) {
+	logger := logBase.WithField("component", "walkthrough")
+
+	fs := flag.NewFlagSet("walkthrough", flag.ExitOnError)
+	flags := addSubcommandFlags(fs)
+	jsonParam := fs.Bool("json", false, "write the results as JSON")
+	timeoutParam := fs.Duration("timeout", walkthrough.DefaultTimeout, "how long to wait for the game to respond to each command")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xyzzybot walkthrough [flags] game script-file\n\nPlays a game (a story file, or the name of a game in the library) through a walkthrough script, checking each response.  It exits with status 1 if any step fails.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	game := fs.Arg(0)
+
+	// Only warnings and errors get in the way of the results.
+	logBase.Level = log.WarnLevel
+
+	script, err := walkthrough.ReadFile(fs.Arg(1))
+	if err != nil {
+		logger.WithField("file", fs.Arg(1)).WithError(err).Fatal("reading script")
+	}
+
+	gameFile := game
+	if _, err := os.Stat(game); err != nil {
+		config := flags.loadConfig(logBase, logger)
+		repo, err := newGameRepository(config, logBase)
+		if err != nil {
+			logger.WithError(err).Fatal("setting up game storage")
+		}
+		gameFile, _, err = repo.GetGameVersionFile(game, "")
+		if err != nil {
+			logger.WithField("game", game).WithError(err).Fatal("finding game")
+		}
+	}
+
+	if code := playWalkthrough(gameFile, script, *timeoutParam, *jsonParam, logBase, logger); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// playWalkthrough plays the game through the script and prints the results,
+// returning the exit status.  (It returns rather than exiting so that the
+// game's working directory is always cleaned up.)
+func playWalkthrough(gameFile string, script *walkthrough.Script, timeout time.Duration, asJSON bool, logBase *log.Logger, logger log.FieldLogger) int {
+	workingDir, err := ioutil.TempDir("", "xyzzybot-walkthrough")
+	if err != nil {
+		logger.WithError(err).Error("creating working directory")
+		return 1
+	}
+	defer os.RemoveAll(workingDir)
+
+	runner := &walkthrough.Runner{
+		Factory:    newInterpreterFactory(logBase),
+		WorkingDir: workingDir,
+		Timeout:    timeout,
+		Logger:     logBase,
+	}
+
+	start := time.Now()
+	result, err := runner.Run(gameFile, script)
+	if err != nil {
+		logger.WithField("game", gameFile).WithError(err).Error("running walkthrough")
+		return 1
+	}
+
+	if asJSON {
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logger.WithError(err).Error("writing results")
+			return 1
+		}
+		fmt.Println(string(b))
+	} else {
+		printWalkthrough(result, time.Since(start))
+	}
+
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printWalkthrough(result *walkthrough.Result, elapsed time.Duration) {
+	for i, step := range result.Steps {
+		status := "PASS"
+		if !step.Passed {
+			status = "FAIL"
+		}
+		command := "(opening)"
+		if step.Command != "" {
+			command = "> " + step.Command
+		}
+		if step.Line > 0 {
+			command = fmt.Sprintf("%-40s (line %d)", command, step.Line)
+		}
+		fmt.Printf("%s %4d  %s\n", status, i, command)
+
+		for _, f := range step.Failures {
+			fmt.Printf("        expected %s (line %d)\n", f.Expectation, f.Line)
+		}
+		if step.Diff != "" {
+			fmt.Printf("        %s\n", strings.Replace(step.Diff, "\n", "\n        ", -1))
+		}
+	}
+
+	fmt.Printf("%d steps: %d passed, %d failed (%s)\n", len(result.Steps), result.Passed, result.Failed, elapsed.Round(time.Millisecond))
+}
@@ -0,0 +1,825 @@
+package twine
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Both Harlowe and SugarCube have an expression language for macro arguments:
+// Harlowe's is its own, and SugarCube's is JavaScript with some English
+// keywords.  The subset here covers what stories mostly use them for:
+// numbers, strings, booleans, and arrays; variables ("$name", and temporary
+// "_name" ones); arithmetic, comparison, and logic in either style ("is",
+// "is not", "and", "==", "&&", "gte", and so on); "contains" and "is in"; and
+// a few functions, like "either" and "random".
+//
+// Values are float64 (both formats' numbers are JavaScript's), string, bool,
+// []interface{}, or nil (SugarCube's undefined).
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenVariable // $name
+	tokenTemp     // _name
+	tokenWord     // keywords and function names
+	tokenMacro    // Harlowe's "(name:"
+	tokenPunct
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+}
+
+// Punctuation, longest first.
+var puncts = []string{
+	"===", "!==", "==", "!=", "<=", ">=", "&&", "||", "+=", "-=", "*=", "/=",
+	"+", "-", "*", "/", "%", "(", ")", "[", "]", ",", ";", "<", ">", "!", "=", ".",
+}
+
+func isWordRune(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		c := rs[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(string(rs[start:i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("“%s” isn’t a number", string(rs[start:i]))
+			}
+			tokens = append(tokens, token{kind: tokenNumber, number: n})
+
+		case c == '"' || c == '\'' || c == '`':
+			var b strings.Builder
+			i++
+			for i < len(rs) && rs[i] != c {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("a string isn’t closed")
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: b.String()})
+
+		case (c == '$' || c == '_') && i+1 < len(rs) && isWordRune(rs[i+1]):
+			kind := tokenVariable
+			if c == '_' {
+				kind = tokenTemp
+			}
+			start := i + 1
+			for i = start; i < len(rs) && isWordRune(rs[i]); i++ {
+			}
+			tokens = append(tokens, token{kind: kind, text: string(rs[start:i])})
+
+		case isWordRune(c):
+			start := i
+			for i < len(rs) && (isWordRune(rs[i]) || rs[i] == '-' && i+1 < len(rs) && isWordRune(rs[i+1])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: string(rs[start:i])})
+
+		default:
+			// Harlowe macro calls look like "(name:".
+			if c == '(' {
+				if name, end := macroName(rs, i+1); end > 0 {
+					tokens = append(tokens, token{kind: tokenMacro, text: name})
+					i = end
+					continue
+				}
+			}
+
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(string(rs[i:]), p) {
+					tokens = append(tokens, token{kind: tokenPunct, text: p})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("I don’t understand “%c”", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// macroName reads a Harlowe macro name and its colon, starting just after the
+// open parenthesis, returning the normalized name and the position after the
+// colon (or 0 if there isn't a macro name there).  Harlowe ignores case,
+// hyphens, and underscores in macro names.
+func macroName(rs []rune, i int) (string, int) {
+	start := i
+	for i < len(rs) && (isWordRune(rs[i]) || rs[i] == '-') {
+		i++
+	}
+	if i == start || i >= len(rs) || rs[i] != ':' {
+		return "", 0
+	}
+	return normalizeMacro(string(rs[start:i])), i + 1
+}
+
+func normalizeMacro(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
+
+// parser evaluates an expression as it parses it.
+type parser struct {
+	r      *Runner
+	tokens []token
+	pos    int
+
+	// it is Harlowe's "it": the value of the variable being changed.
+	it interface{}
+}
+
+func (r *Runner) newParser(s string) (*parser, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{r: r, tokens: tokens}, nil
+}
+
+// evaluate evaluates a whole expression.
+func (r *Runner) evaluate(s string) (interface{}, error) {
+	p, err := r.newParser(s)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("I don’t understand “%s”", s)
+	}
+	return value, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) done() bool {
+	return p.peek().kind == tokenEOF
+}
+
+// accept consumes the next token if it's one of the given punctuation marks or
+// (case-insensitive) words, returning it.
+func (p *parser) accept(texts ...string) string {
+	t := p.peek()
+	if t.kind != tokenPunct && t.kind != tokenWord {
+		return ""
+	}
+	for _, text := range texts {
+		if t.kind == tokenPunct && t.text == text || t.kind == tokenWord && strings.EqualFold(t.text, text) {
+			p.pos++
+			return text
+		}
+	}
+	return ""
+}
+
+func (p *parser) expect(text string) error {
+	if p.accept(text) == "" {
+		return fmt.Errorf("expected “%s”", text)
+	}
+	return nil
+}
+
+func (p *parser) expression() (interface{}, error) {
+	return p.or()
+}
+
+func (p *parser) or() (interface{}, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or", "||") != "" {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = truthy(left) || truthy(right)
+	}
+	return left, nil
+}
+
+func (p *parser) and() (interface{}, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and", "&&") != "" {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = truthy(left) && truthy(right)
+	}
+	return left, nil
+}
+
+func (p *parser) not() (interface{}, error) {
+	if p.accept("not", "!") != "" {
+		value, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(value), nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (interface{}, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.accept("is", "isnot", "==", "===", "!=", "!==", "eq", "neq",
+			"<", ">", "<=", ">=", "lt", "gt", "lte", "gte", "contains")
+		if op == "" {
+			return left, nil
+		}
+
+		// "is not", "is in", and "is not in"...
+		negate := op == "isnot"
+		if op == "is" {
+			if p.accept("not") != "" {
+				negate = true
+			}
+			if p.accept("in") != "" {
+				op = "in"
+			}
+		}
+
+		right, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := compare(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			result = !result
+		}
+		left = result
+	}
+}
+
+func compare(op string, a interface{}, b interface{}) (bool, error) {
+	switch op {
+	case "is", "isnot", "==", "===", "eq":
+		return equal(a, b), nil
+	case "!=", "!==", "neq":
+		return !equal(a, b), nil
+	case "contains":
+		return contains(a, b), nil
+	case "in":
+		return contains(b, a), nil
+	}
+
+	// Ordering compares strings as strings, and everything else as numbers.
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			switch op {
+			case "<", "lt":
+				return x < y, nil
+			case ">", "gt":
+				return x > y, nil
+			case "<=", "lte":
+				return x <= y, nil
+			default:
+				return x >= y, nil
+			}
+		}
+	}
+
+	x, err := toNumber(a)
+	if err != nil {
+		return false, err
+	}
+	y, err := toNumber(b)
+	if err != nil {
+		return false, err
+	}
+	switch op {
+	case "<", "lt":
+		return x < y, nil
+	case ">", "gt":
+		return x > y, nil
+	case "<=", "lte":
+		return x <= y, nil
+	default:
+		return x >= y, nil
+	}
+}
+
+func (p *parser) additive() (interface{}, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.accept("+", "-")
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left, err = arithmetic(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) multiplicative() (interface{}, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.accept("*", "/", "%")
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left, err = arithmetic(op, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func arithmetic(op string, a interface{}, b interface{}) (interface{}, error) {
+	if op == "+" {
+		_, aString := a.(string)
+		_, bString := b.(string)
+		if aString || bString {
+			return formatValue(a) + formatValue(b), nil
+		}
+		if x, ok := a.([]interface{}); ok {
+			if y, ok := b.([]interface{}); ok {
+				return append(append([]interface{}{}, x...), y...), nil
+			}
+		}
+	}
+
+	x, err := toNumber(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toNumber(b)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return nil, fmt.Errorf("the story divided by zero")
+		}
+		return x / y, nil
+	default:
+		if y == 0 {
+			return nil, fmt.Errorf("the story divided by zero")
+		}
+		return math.Mod(x, y), nil
+	}
+}
+
+func (p *parser) unary() (interface{}, error) {
+	if p.accept("-") != "" {
+		value, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		n, err := toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		return -n, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (interface{}, error) {
+	value, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	// The only property we know is ".length".
+	for p.peek().kind == tokenPunct && p.peek().text == "." {
+		p.next()
+		if p.accept("length") == "" {
+			return nil, fmt.Errorf("the only property I understand is “length”")
+		}
+		switch v := value.(type) {
+		case string:
+			value = float64(len([]rune(v)))
+		case []interface{}:
+			value = float64(len(v))
+		default:
+			return nil, fmt.Errorf("%s doesn’t have a length", describeValue(value))
+		}
+	}
+
+	return value, nil
+}
+
+func (p *parser) primary() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return t.number, nil
+
+	case tokenString:
+		return t.text, nil
+
+	case tokenVariable:
+		return p.r.variable(t.text), nil
+
+	case tokenTemp:
+		return p.r.temps[t.text], nil
+
+	case tokenMacro:
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		return p.r.call(t.text, args)
+
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return value, p.expect(")")
+		case "[":
+			return p.array()
+		}
+
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null", "undefined":
+			return nil, nil
+		case "it":
+			return p.it, nil
+		case "visits", "visit":
+			return float64(p.r.visits[p.r.passage]), nil
+		case "def", "ndef":
+			value, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return (value != nil) == strings.EqualFold(t.text, "def"), nil
+		}
+
+		// SugarCube function calls look like "name(" (or "Math.name(").
+		if t.text == "Math" && p.accept(".") != "" {
+			t = p.next()
+		}
+		if p.accept("(") != "" {
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			return p.r.call(normalizeMacro(t.text), args)
+		}
+		return nil, fmt.Errorf("I don’t understand “%s”", t.text)
+
+	case tokenEOF:
+		return nil, fmt.Errorf("something’s missing at the end")
+	}
+
+	return nil, fmt.Errorf("I didn’t expect “%s”", t.text)
+}
+
+// arguments reads the arguments of a function call up to the closing
+// parenthesis.
+func (p *parser) arguments() ([]interface{}, error) {
+	var args []interface{}
+	if p.accept(")") != "" {
+		return args, nil
+	}
+	for {
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+		if p.accept(")") != "" {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// array reads a SugarCube array literal, after the "[".
+func (p *parser) array() (interface{}, error) {
+	values := []interface{}{}
+	if p.accept("]") != "" {
+		return values, nil
+	}
+	for {
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.accept("]") != "" {
+			return values, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// call runs one of the functions we know: Harlowe's value macros, and
+// SugarCube's story functions (and a little of JavaScript's Math).
+func (r *Runner) call(name string, args []interface{}) (interface{}, error) {
+	numbers := func(want int) ([]float64, error) {
+		if len(args) < want {
+			return nil, fmt.Errorf("“%s” needs %d values", name, want)
+		}
+		ns := make([]float64, len(args))
+		for i, arg := range args {
+			n, err := toNumber(arg)
+			if err != nil {
+				return nil, err
+			}
+			ns[i] = n
+		}
+		return ns, nil
+	}
+
+	switch name {
+	case "either":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("“either” needs something to choose from")
+		}
+		return args[r.rand.Intn(len(args))], nil
+
+	case "random":
+		ns, err := numbers(1)
+		if err != nil {
+			return nil, err
+		}
+		low, high := 0.0, ns[0]
+		if len(ns) > 1 {
+			low, high = ns[0], ns[1]
+		}
+		if high < low {
+			low, high = high, low
+		}
+		return low + float64(r.rand.Intn(int(high-low)+1)), nil
+
+	case "visited":
+		if r.story.Dialect == Harlowe {
+			if len(args) == 0 {
+				return r.visits[r.passage] > 0, nil
+			}
+			return r.visits[formatValue(args[0])] > 0, nil
+		}
+		// SugarCube's counts the visits (to the least-visited, if more than
+		// one passage is given).
+		if len(args) == 0 {
+			return float64(r.visits[r.passage]), nil
+		}
+		count := -1
+		for _, arg := range args {
+			if n := r.visits[formatValue(arg)]; count < 0 || n < count {
+				count = n
+			}
+		}
+		return float64(count), nil
+
+	case "history":
+		// ...not counting the current passage.
+		history := make([]interface{}, 0, len(r.history))
+		for i, name := range r.history {
+			if i < len(r.history)-1 {
+				history = append(history, name)
+			}
+		}
+		return history, nil
+
+	case "turns":
+		return float64(len(r.history)), nil
+
+	case "passage":
+		return r.passage, nil
+
+	case "previous":
+		if len(r.history) < 2 {
+			return "", nil
+		}
+		return r.history[len(r.history)-2], nil
+
+	case "a", "array":
+		return append([]interface{}{}, args...), nil
+
+	case "str", "string", "text":
+		var b strings.Builder
+		for _, arg := range args {
+			b.WriteString(formatValue(arg))
+		}
+		return b.String(), nil
+
+	case "num", "number":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("“%s” needs one value", name)
+		}
+		return toNumber(args[0])
+
+	case "uppercase", "lowercase":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("“%s” needs one value", name)
+		}
+		if name == "uppercase" {
+			return strings.ToUpper(formatValue(args[0])), nil
+		}
+		return strings.ToLower(formatValue(args[0])), nil
+
+	case "round", "floor", "ceil", "abs":
+		ns, err := numbers(1)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "round":
+			return math.Floor(ns[0] + 0.5), nil
+		case "floor":
+			return math.Floor(ns[0]), nil
+		case "ceil":
+			return math.Ceil(ns[0]), nil
+		default:
+			return math.Abs(ns[0]), nil
+		}
+
+	case "min", "max":
+		ns, err := numbers(1)
+		if err != nil {
+			return nil, err
+		}
+		result := ns[0]
+		for _, n := range ns[1:] {
+			if name == "min" && n < result || name == "max" && n > result {
+				result = n
+			}
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("I don’t know “%s”", name)
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case nil:
+		return false
+	}
+	return true
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case nil:
+		return 0, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%s isn’t a number", describeValue(value))
+}
+
+func equal(a interface{}, b interface{}) bool {
+	x, xok := a.(float64)
+	y, yok := b.(float64)
+	if xok && yok {
+		return x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(container interface{}, value interface{}) bool {
+	switch c := container.(type) {
+	case string:
+		return strings.Contains(c, formatValue(value))
+	case []interface{}:
+		for _, item := range c {
+			if equal(item, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatValue(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return ""
+}
+
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("the text “%s”", v)
+	case float64:
+		return fmt.Sprintf("the number %s", formatValue(v))
+	case bool:
+		return formatValue(v)
+	case []interface{}:
+		return "an array"
+	}
+	return "nothing"
+}
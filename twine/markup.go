@@ -0,0 +1,815 @@
+package twine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The markup both formats share: links ("[[text->target]]", "[[text|target]]",
+// and so on), bold ('') and italic (//) text, naked variables ("$name"),
+// comments, and HTML (of which we only keep line breaks).  Harlowe adds
+// "(macro: ...)[hook]" and whitespace-collapsing "{...}", and SugarCube adds
+// "<<macro ...>>" (with "<</macro>>" closing containers).
+
+// specials are the characters that might start markup; everything else is
+// plain text.
+const specials = "\n[]()'/*<$_{}|"
+
+var (
+	tagPattern       = regexp.MustCompile(`^</?([A-Za-z][A-Za-z0-9-]*)[^>]*>`)
+	namedHookPattern = regexp.MustCompile(`^\|[\w-]+>\[`)
+	hookNamePattern  = regexp.MustCompile(`^<[\w-]+\|`)
+)
+
+// render renders passage markup into the output, until the end or a redirect.
+func (r *Runner) render(text string) {
+	if r.out.depth >= maxDepth {
+		r.inlineError(fmt.Errorf("passages include each other too deeply"))
+		return
+	}
+	r.out.depth++
+	defer func() { r.out.depth-- }()
+
+	for i := 0; i < len(text) && r.out.redirect == nil; {
+		if n := r.renderMarkup(text, i); n > 0 {
+			i += n
+			continue
+		}
+
+		j := i + 1
+		for j < len(text) && !strings.ContainsRune(specials, rune(text[j])) {
+			j++
+		}
+		r.write(text[i:j])
+		i = j
+	}
+}
+
+// renderMarkup renders the markup at text[i:], if there is any, returning how
+// much of the text it used (0 if it's plain text).
+func (r *Runner) renderMarkup(text string, i int) int {
+	rest := text[i:]
+	harlowe := r.story.Dialect == Harlowe
+
+	switch {
+	case rest[0] == '\n':
+		r.newline()
+		return 1
+
+	case strings.HasPrefix(rest, "[["):
+		return r.renderLink(rest)
+
+	case strings.HasPrefix(rest, "/*"):
+		return skipPast(rest, "*/")
+
+	case strings.HasPrefix(rest, "/%") && !harlowe:
+		return skipPast(rest, "%/")
+
+	case strings.HasPrefix(rest, "<!--"):
+		return skipPast(rest, "-->")
+
+	case strings.HasPrefix(rest, "''"):
+		r.out.bold = !r.out.bold
+		return 2
+
+	case strings.HasPrefix(rest, "//") && (i == 0 || text[i-1] != ':'):
+		r.out.italic = !r.out.italic
+		return 2
+
+	case strings.HasPrefix(rest, "**") && harlowe:
+		r.out.bold = !r.out.bold
+		return 2
+
+	case rest[0] == '*' && harlowe && len(rest) > 1:
+		// Only a "*" next to a word starts or ends italics.
+		next, _ := utf8.DecodeRuneInString(rest[1:])
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		if !r.out.italic && !unicode.IsSpace(next) || r.out.italic && i > 0 && !unicode.IsSpace(prev) {
+			r.out.italic = !r.out.italic
+			return 1
+		}
+
+	case strings.HasPrefix(rest, "<<") && !harlowe:
+		return r.renderSugarCubeMacro(rest)
+
+	case rest[0] == '<':
+		if m := tagPattern.FindStringSubmatch(rest); m != nil {
+			if strings.EqualFold(m[1], "br") {
+				r.newline()
+			}
+			return len(m[0])
+		}
+
+	case rest[0] == '$' || rest[0] == '_' && (i == 0 || !isWordRune(lastRune(text[:i]))):
+		return r.renderNakedVariable(rest)
+
+	case rest[0] == '(' && harlowe:
+		if name, end := macroName([]rune(rest), 1); end > 0 {
+			return r.renderHarloweMacro(rest, name, len(string([]rune(rest)[:end])))
+		}
+
+	case rest[0] == '[' && harlowe:
+		// A hook without a macro is just shown.
+		hook, n := matchHook(rest)
+		if n > 0 {
+			r.render(hook)
+			return n + hookNameLength(rest[n:])
+		}
+
+	case rest[0] == '|' && harlowe:
+		if m := namedHookPattern.FindString(rest); m != "" {
+			return len(m) - 1
+		}
+
+	case rest[0] == '{' && harlowe:
+		r.out.collapse++
+		return 1
+
+	case rest[0] == '}' && harlowe && r.out.collapse > 0:
+		r.out.collapse--
+		return 1
+	}
+
+	return 0
+}
+
+func lastRune(s string) rune {
+	c, _ := utf8.DecodeLastRuneInString(s)
+	return c
+}
+
+// skipPast returns the length of text through the end marker (or all of it).
+func skipPast(text string, end string) int {
+	n := strings.Index(text, end)
+	if n < 0 {
+		return len(text)
+	}
+	return n + len(end)
+}
+
+func (r *Runner) write(text string) {
+	if r.out.silent > 0 || text == "" {
+		return
+	}
+
+	if r.out.collapse > 0 {
+		// Runs of whitespace become single spaces.
+		words := strings.Join(strings.Fields(text), " ")
+		if unicode.IsSpace(rune(text[0])) && !r.lineEndsWithSpace() {
+			words = " " + words
+		}
+		if unicode.IsSpace(rune(text[len(text)-1])) && words != "" && !strings.HasSuffix(words, " ") {
+			words += " "
+		}
+		if words == "" {
+			return
+		}
+		text = words
+	}
+
+	r.writeSpan(&Span{Text: text, Bold: r.out.bold, Italic: r.out.italic})
+}
+
+func (r *Runner) writeSpan(span *Span) {
+	line := r.out.line
+	if n := len(line); n > 0 && !span.link && !line[n-1].link &&
+		line[n-1].Bold == span.Bold && line[n-1].Italic == span.Italic {
+		line[n-1].Text += span.Text
+		return
+	}
+	r.out.line = append(line, span)
+}
+
+func (r *Runner) lineEndsWithSpace() bool {
+	line := r.out.line
+	return len(line) == 0 || strings.HasSuffix(line[len(line)-1].Text, " ")
+}
+
+func (r *Runner) newline() {
+	if r.out.silent > 0 {
+		return
+	}
+	if r.out.collapse > 0 {
+		if !r.lineEndsWithSpace() {
+			r.write(" ")
+		}
+		return
+	}
+	r.out.lines = append(r.out.lines, r.out.line)
+	r.out.line = nil
+}
+
+// inlineError shows a problem with the story where it happened, as the story
+// formats do.
+func (r *Runner) inlineError(err error) {
+	r.writeSpan(&Span{Text: fmt.Sprintf("(error: %s)", err.Error()), Italic: true})
+}
+
+// finish returns the lines of output, tidied up: lines that are nothing but
+// links are dropped (the links are offered as choices anyway), as are extra
+// blank lines.
+func (o *output) finish() []Line {
+	all := append(o.lines, o.line)
+
+	lines := make([]Line, 0, len(all))
+	blank := true
+	for _, line := range all {
+		if onlyLinks(line) {
+			continue
+		}
+		line = trimLine(line)
+		if len(line) == 0 {
+			if !blank {
+				lines = append(lines, line)
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func onlyLinks(line Line) bool {
+	links := false
+	for _, span := range line {
+		if span.link {
+			links = true
+		} else if strings.Trim(span.Text, " \t|·•-–—") != "" {
+			return false
+		}
+	}
+	return links
+}
+
+// trimLine removes trailing whitespace (and whitespace-only spans).
+func trimLine(line Line) Line {
+	for len(line) > 0 {
+		last := line[len(line)-1]
+		last.Text = strings.TrimRight(last.Text, " \t")
+		if last.Text != "" {
+			break
+		}
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// renderLink renders "[[...]]", returning its length.
+func (r *Runner) renderLink(text string) int {
+	end := strings.Index(text, "]]")
+	if end < 0 {
+		r.write(text[:2])
+		return 2
+	}
+	inner := text[2:end]
+
+	// SugarCube links can have a setter: "[[text|target][$x to 1]]".
+	setter := ""
+	if n := strings.Index(inner, "]["); n >= 0 {
+		inner, setter = inner[:n], inner[n+2:]
+	}
+
+	label, target := parseLink(inner)
+	r.addLink(label, target, setter, "")
+	return end + 2
+}
+
+// parseLink splits the inside of a link into its text and target.
+func parseLink(link string) (string, string) {
+	if n := strings.LastIndex(link, "|"); n >= 0 {
+		return link[:n], link[n+1:]
+	}
+	if n := strings.LastIndex(link, "->"); n >= 0 {
+		return link[:n], link[n+2:]
+	}
+	if n := strings.Index(link, "<-"); n >= 0 {
+		return link[n+2:], link[:n]
+	}
+	return link, link
+}
+
+// addLink shows a link and offers it as a choice.  Links to passages that
+// don't exist are shown as plain text, as the story formats show them as
+// broken links.
+func (r *Runner) addLink(label string, target string, setter string, hook string) {
+	if r.out.silent > 0 {
+		return
+	}
+
+	label = strings.TrimSpace(label)
+	target = strings.TrimSpace(target)
+	if target != "" && r.story.Passage(target) == nil {
+		r.write(label)
+		return
+	}
+
+	r.writeSpan(&Span{Text: label, Bold: r.out.bold, Italic: r.out.italic, link: true})
+	r.out.choices = append(r.out.choices, &pendingChoice{
+		Choice: Choice{Text: label},
+		target: target,
+		setter: setter,
+		hook:   hook,
+	})
+}
+
+func (r *Runner) renderNakedVariable(text string) int {
+	t, err := tokenize(text[:variableLength(text)])
+	if err != nil || len(t) != 2 {
+		return 0
+	}
+
+	var value interface{}
+	if t[0].kind == tokenVariable {
+		value = r.variable(t[0].text)
+	} else {
+		value = r.temps[t[0].text]
+	}
+	r.write(formatValue(value))
+	return variableLength(text)
+}
+
+func variableLength(text string) int {
+	n := 1
+	for n < len(text) {
+		c, size := utf8.DecodeRuneInString(text[n:])
+		if !isWordRune(c) {
+			break
+		}
+		n += size
+	}
+	if n == 1 {
+		return 0
+	}
+	return n
+}
+
+// matchClose finds the closing bracket matching the opening one at text[0],
+// skipping over strings (in code, but not in prose, where quotes are just
+// apostrophes).  It returns -1 if there isn't one.
+func matchClose(text string, open byte, close byte, code bool) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case code && (c == '"' || c == '\''):
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case c == open:
+			depth++
+		case c == close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// matchHook returns the contents of the hook at text[0] and its length, or a
+// length of 0 if it isn't one.
+func matchHook(text string) (string, int) {
+	if !strings.HasPrefix(text, "[") {
+		return "", 0
+	}
+	end := matchClose(text, '[', ']', false)
+	if end < 0 {
+		return "", 0
+	}
+	return text[1:end], end + 1
+}
+
+// hookNameLength returns the length of the "<name|" that can follow a hook.
+func hookNameLength(text string) int {
+	return len(hookNamePattern.FindString(text))
+}
+
+// renderHarloweMacro renders "(name: args)" and the hook that follows it, if
+// any, returning the length of both.
+func (r *Runner) renderHarloweMacro(text string, name string, argsStart int) int {
+	end := matchClose(text, '(', ')', true)
+	if end < 0 {
+		return 0
+	}
+	args := text[argsStart:end]
+	n := end + 1
+
+	// Changers apply to the hook right after them.
+	afterMacro := strings.TrimLeft(text[n:], " \t")
+	hook, hookLength := matchHook(afterMacro)
+	hasHook := hookLength > 0
+	if hasHook {
+		n = len(text) - len(afterMacro) + hookLength
+		n += hookNameLength(text[n:])
+	}
+
+	showHook := func(show bool) {
+		if show && hasHook {
+			r.render(hook)
+		}
+	}
+
+	switch name {
+	case "set":
+		r.check(r.assign(args))
+
+	case "put", "move":
+		r.check(r.put(args))
+
+	case "if", "unless", "elseif":
+		values, err := r.evaluateList(args)
+		if err == nil && len(values) != 1 {
+			err = fmt.Errorf("(%s:) needs a condition", name)
+		}
+		if !r.check(err) {
+			break
+		}
+		show := truthy(values[0])
+		switch name {
+		case "unless":
+			show = !show
+		case "elseif":
+			show = show && !r.out.chain
+			r.out.chain = r.out.chain || show
+			showHook(show)
+			return n
+		}
+		r.out.chain = show
+		showHook(show)
+
+	case "else":
+		show := !r.out.chain
+		r.out.chain = true
+		showHook(show)
+
+	case "print":
+		values, err := r.evaluateList(args)
+		if r.check(err) {
+			for _, value := range values {
+				r.write(formatValue(value))
+			}
+		}
+
+	case "display":
+		values, err := r.evaluateList(args)
+		if r.check(err) && len(values) == 1 {
+			r.include(formatValue(values[0]))
+		}
+
+	case "goto":
+		values, err := r.evaluateList(args)
+		if r.check(err) && len(values) == 1 {
+			r.redirect(formatValue(values[0]))
+		}
+
+	case "linkgoto", "clickgoto", "link", "linkreveal", "linkrepeat", "linkrevealgoto":
+		values, err := r.evaluateList(args)
+		if !r.check(err) || len(values) == 0 {
+			break
+		}
+		label := formatValue(values[0])
+		target := ""
+		if len(values) > 1 {
+			target = formatValue(values[1])
+		} else if name == "linkgoto" || name == "clickgoto" {
+			target = label
+		}
+		r.addLink(label, target, "", hook)
+
+	case "collapse", "nobr":
+		r.out.collapse++
+		showHook(true)
+		r.out.collapse--
+
+	default:
+		// Value macros (like "(either:)") are printed; other changers (like
+		// "(text-colour:)") just show their hooks.
+		values, err := r.evaluateList(args)
+		if err == nil {
+			var value interface{}
+			value, err = r.call(name, values)
+			if err == nil {
+				r.write(formatValue(value))
+			}
+		}
+		showHook(true)
+	}
+
+	return n
+}
+
+// check shows an error, if there is one, and reports whether there wasn't.
+func (r *Runner) check(err error) bool {
+	if err != nil {
+		r.inlineError(err)
+		return false
+	}
+	return true
+}
+
+func (r *Runner) include(name string) {
+	p := r.story.Passage(name)
+	if p == nil {
+		r.inlineError(fmt.Errorf("there’s no passage called “%s”", name))
+		return
+	}
+	r.render(p.Text)
+}
+
+func (r *Runner) redirect(name string) {
+	if r.story.Passage(name) == nil {
+		r.inlineError(fmt.Errorf("there’s no passage called “%s”", name))
+		return
+	}
+	r.out.redirect = &name
+}
+
+// renderSugarCubeMacro renders "<<name args>>" (and, for containers, its
+// contents and closing tag), returning its length.
+func (r *Runner) renderSugarCubeMacro(text string) int {
+	name, args, n := parseSugarCubeTag(text)
+	if n == 0 {
+		r.write("<<")
+		return 2
+	}
+
+	// Containers...
+	switch name {
+	case "if", "silently", "nobr", "link", "button", "script", "widget", "done", "timed", "repeat":
+		contents, length := containerContents(text[n:], name)
+		n += length
+
+		switch name {
+		case "if":
+			r.renderSugarCubeIf(args, contents)
+		case "silently":
+			r.out.silent++
+			r.render(contents)
+			r.out.silent--
+		case "nobr":
+			r.out.collapse++
+			r.render(contents)
+			r.out.collapse--
+		case "link", "button":
+			r.renderSugarCubeLink(args, contents)
+		case "timed", "repeat":
+			// We can't wait, so show it now.
+			r.render(contents)
+		}
+		return n
+	}
+
+	switch name {
+	case "set":
+		r.check(r.assign(args))
+
+	case "unset":
+		for _, v := range strings.FieldsFunc(args, func(c rune) bool { return c == ',' || unicode.IsSpace(c) }) {
+			if strings.HasPrefix(v, "$") {
+				delete(r.vars, v[1:])
+			} else {
+				delete(r.temps, strings.TrimPrefix(v, "_"))
+			}
+		}
+
+	case "print", "=", "-":
+		value, err := r.evaluate(args)
+		if r.check(err) {
+			r.write(formatValue(value))
+		}
+
+	case "goto":
+		r.redirect(r.passageArgument(args))
+
+	case "include", "display":
+		r.include(r.passageArgument(args))
+
+	case "choice", "actions":
+		for _, arg := range r.macroArguments(args) {
+			label, target := parseLink(strings.TrimSuffix(strings.TrimPrefix(arg, "[["), "]]"))
+			r.addLink(label, target, "", "")
+		}
+
+	case "back", "return":
+		if len(r.history) > 1 {
+			label := "Back"
+			if name == "return" {
+				label = "Return"
+			}
+			if words := r.macroArguments(args); len(words) > 0 {
+				label = words[0]
+			}
+			r.addLink(label, r.history[len(r.history)-2], "", "")
+		}
+	}
+
+	return n
+}
+
+// parseSugarCubeTag parses "<<name args>>", returning the name, the arguments,
+// and its length (0 if it isn't a macro).
+func parseSugarCubeTag(text string) (string, string, int) {
+	end := -1
+	var quote byte
+	for i := 2; i < len(text)-1; i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>' && text[i+1] == '>':
+			end = i
+		}
+		if end >= 0 {
+			break
+		}
+	}
+	if end < 0 {
+		return "", "", 0
+	}
+
+	inner := text[2:end]
+	if strings.HasPrefix(inner, "=") || strings.HasPrefix(inner, "-") {
+		return inner[:1], strings.TrimSpace(inner[1:]), end + 2
+	}
+
+	name := inner
+	args := ""
+	if n := strings.IndexFunc(inner, unicode.IsSpace); n >= 0 {
+		name, args = inner[:n], strings.TrimSpace(inner[n:])
+	}
+	return name, args, end + 2
+}
+
+// containerContents finds the contents of a container macro, up to its
+// closing "<</name>>" (or Twine 1's "<<endname>>"), returning them and the
+// length including the closing tag.
+func containerContents(text string, name string) (string, int) {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		if !strings.HasPrefix(text[i:], "<<") {
+			continue
+		}
+		tag, _, n := parseSugarCubeTag(text[i:])
+		switch tag {
+		case name:
+			depth++
+		case "/" + name, "end" + name:
+			if depth == 0 {
+				return text[:i], i + n
+			}
+			depth--
+		}
+		if n > 0 {
+			i += n - 1
+		}
+	}
+	return text, len(text)
+}
+
+// renderSugarCubeIf renders the first branch of an "<<if>>" whose condition is
+// true.
+func (r *Runner) renderSugarCubeIf(condition string, contents string) {
+	type branch struct {
+		condition string
+		body      string
+	}
+
+	// Branches start at "<<elseif>>", "<<else if>>", and "<<else>>" (but not
+	// those of nested "<<if>>"s).
+	var branches []branch
+	depth := 0
+	start := 0
+	for i := 0; i < len(contents); i++ {
+		if !strings.HasPrefix(contents[i:], "<<") {
+			continue
+		}
+		tag, args, n := parseSugarCubeTag(contents[i:])
+		switch {
+		case tag == "if":
+			depth++
+		case tag == "/if" || tag == "endif":
+			depth--
+		case depth == 0 && (tag == "elseif" || tag == "else"):
+			branches = append(branches, branch{condition, contents[start:i]})
+			condition = branchCondition(tag, args)
+			start = i + n
+		}
+		if n > 0 {
+			i += n - 1
+		}
+	}
+	branches = append(branches, branch{condition, contents[start:]})
+
+	for _, b := range branches {
+		value, err := r.evaluate(b.condition)
+		if !r.check(err) {
+			return
+		}
+		if truthy(value) {
+			r.render(b.body)
+			return
+		}
+	}
+}
+
+// branchCondition returns the condition of an "<<elseif>>" or "<<else if>>", or
+// "true" for a plain "<<else>>".
+func branchCondition(tag string, args string) string {
+	if tag == "elseif" {
+		return args
+	}
+	if strings.HasPrefix(args, "if ") {
+		return strings.TrimPrefix(args, "if ")
+	}
+	return "true"
+}
+
+// renderSugarCubeLink renders "<<link "text" "passage">>...<</link>>" (or
+// "<<link [[text|passage]]>>").
+func (r *Runner) renderSugarCubeLink(args string, contents string) {
+	words := r.macroArguments(args)
+	if len(words) == 0 {
+		return
+	}
+
+	label, target := words[0], ""
+	if strings.HasPrefix(label, "[[") {
+		label, target = parseLink(strings.TrimSuffix(strings.TrimPrefix(label, "[["), "]]"))
+	}
+	if len(words) > 1 {
+		target = words[1]
+	}
+	r.addLink(label, target, "", contents)
+}
+
+// passageArgument returns the passage named by a SugarCube macro's arguments:
+// a string, a link, or a variable.
+func (r *Runner) passageArgument(args string) string {
+	words := r.macroArguments(args)
+	if len(words) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(words[0], "[[") {
+		_, target := parseLink(strings.TrimSuffix(strings.TrimPrefix(words[0], "[["), "]]"))
+		return target
+	}
+	return words[0]
+}
+
+// macroArguments splits a SugarCube macro's arguments: strings (unquoted),
+// links (kept as they are), and variables (evaluated).
+func (r *Runner) macroArguments(args string) []string {
+	var words []string
+	for args = strings.TrimSpace(args); args != ""; args = strings.TrimSpace(args) {
+		n := 0
+		switch c := args[0]; {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(args[1:], c)
+			if end < 0 {
+				end = len(args) - 1
+			}
+			words = append(words, args[1:end+1])
+			n = end + 2
+
+		case strings.HasPrefix(args, "[["):
+			n = skipPast(args, "]]")
+			words = append(words, args[:n])
+
+		default:
+			n = strings.IndexFunc(args, unicode.IsSpace)
+			if n < 0 {
+				n = len(args)
+			}
+			word := args[:n]
+			if strings.HasPrefix(word, "$") || strings.HasPrefix(word, "_") {
+				value, err := r.evaluate(word)
+				if r.check(err) {
+					word = formatValue(value)
+				}
+			}
+			words = append(words, word)
+		}
+		if n > len(args) {
+			n = len(args)
+		}
+		args = args[n:]
+	}
+	return words
+}
@@ -0,0 +1,363 @@
+package twine
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// A story that goes from passage to passage this many times without stopping
+// for the player is probably stuck in a loop.
+const maxRedirects = 100
+
+// ...and one that includes passages this deeply probably includes itself.
+const maxDepth = 20
+
+// Span is a run of text with the same style.
+type Span struct {
+	Text   string
+	Bold   bool
+	Italic bool
+
+	link bool
+}
+
+// Line is a line of text.
+type Line []*Span
+
+// String returns the text of the line, without styles.
+func (l Line) String() string {
+	var b strings.Builder
+	for _, span := range l {
+		b.WriteString(span.Text)
+	}
+	return b.String()
+}
+
+// Choice is one of the links the player can follow.
+type Choice struct {
+	Text string
+}
+
+type pendingChoice struct {
+	Choice
+	target string // the passage to go to
+	setter string // assignments to make first (SugarCube's "[[text|target][$x to 1]]")
+	hook   string // markup to run first (Harlowe's "(link:)[...]", SugarCube's "<<link>>...<</link>>")
+}
+
+// Page is what the story showed, and the links the player can follow.
+type Page struct {
+	Lines   []Line
+	Choices []*Choice
+}
+
+// output collects what's rendered.
+type output struct {
+	lines    []Line
+	line     Line
+	bold     bool
+	italic   bool
+	collapse int // inside Harlowe's "{...}" or SugarCube's <<nobr>>
+	silent   int // inside SugarCube's <<silently>>
+	depth    int // of included passages
+	choices  []*pendingChoice
+	redirect *string
+
+	// chain is whether a branch of the current Harlowe "(if:)...(else:)"
+	// chain has been shown.
+	chain bool
+}
+
+// Runner plays a story.
+type Runner struct {
+	story *Story
+	rand  *rand.Rand
+
+	passage string
+	vars    map[string]interface{}
+	temps   map[string]interface{}
+	visits  map[string]int
+	history []string
+	turn    int
+	choices []*pendingChoice
+
+	out *output
+	err error
+}
+
+// NewRunner starts a new play-through of the story.
+func (s *Story) NewRunner() (*Runner, error) {
+	r := &Runner{
+		story: s,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	return r, r.Reset()
+}
+
+// Reset starts the story again from the beginning.
+func (r *Runner) Reset() error {
+	r.passage = ""
+	r.vars = make(map[string]interface{})
+	r.temps = make(map[string]interface{})
+	r.visits = make(map[string]int)
+	r.history = nil
+	r.turn = 0
+	r.choices = nil
+
+	// Stories set themselves up in SugarCube's "StoryInit" passage, or in
+	// Harlowe's "startup"-tagged ones.
+	r.out = &output{}
+	r.out.silent++
+	for _, p := range r.story.order {
+		if p.Name == "StoryInit" || p.HasTag("startup") {
+			r.render(p.Text)
+		}
+	}
+
+	r.err = r.show(r.story.Start)
+	return r.err
+}
+
+// Ended reports whether the story has ended: that is, there aren't any links
+// to follow.
+func (r *Runner) Ended() bool {
+	return len(r.choices) == 0
+}
+
+// Turn returns the number of links followed so far.
+func (r *Runner) Turn() int {
+	return r.turn
+}
+
+// Choices returns the links the player can follow now.
+func (r *Runner) Choices() []*Choice {
+	choices := make([]*Choice, len(r.choices))
+	for i, c := range r.choices {
+		choices[i] = &c.Choice
+	}
+	return choices
+}
+
+// Continue returns what the story showed since the last choice (or since it
+// started).
+func (r *Runner) Continue() (*Page, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	page := &Page{Choices: r.Choices()}
+	if r.out != nil {
+		page.Lines = r.out.finish()
+		r.out = nil
+	}
+	return page, nil
+}
+
+// Choose follows one of the links returned by Choices (by index); call
+// Continue to see what happens.
+func (r *Runner) Choose(index int) error {
+	if index < 0 || index >= len(r.choices) {
+		return fmt.Errorf("there’s no choice %d", index+1)
+	}
+	c := r.choices[index]
+	r.turn++
+
+	r.out = &output{}
+	if c.setter != "" {
+		if err := r.assign(c.setter); err != nil {
+			r.inlineError(err)
+		}
+	}
+	if c.hook != "" {
+		r.render(c.hook)
+	}
+
+	switch {
+	case r.out.redirect != nil:
+		r.err = r.show(*r.out.redirect)
+	case c.target != "":
+		r.err = r.show(c.target)
+	default:
+		// Links that only reveal something leave the rest of the passage's
+		// links where they were.
+		choices := r.out.choices
+		for _, other := range r.choices {
+			if other != c {
+				choices = append(choices, other)
+			}
+		}
+		r.choices = choices
+	}
+
+	return r.err
+}
+
+// show goes to a passage (and on to others, if it redirects), replacing the
+// output with what it shows.
+func (r *Runner) show(name string) error {
+	for redirects := 0; ; redirects++ {
+		if redirects >= maxRedirects {
+			return fmt.Errorf("the story went from passage to passage without stopping (last at “%s”)", name)
+		}
+
+		p := r.story.Passage(name)
+		if p == nil {
+			return fmt.Errorf("there’s no passage called “%s”", name)
+		}
+
+		r.passage = name
+		r.visits[name]++
+		r.history = append(r.history, name)
+		r.temps = make(map[string]interface{})
+		r.out = &output{}
+
+		r.renderDecorations("header", "PassageHeader")
+		r.render(p.Text)
+		r.renderDecorations("footer", "PassageFooter")
+
+		if r.out.redirect == nil {
+			break
+		}
+		name = *r.out.redirect
+	}
+
+	r.choices = r.out.choices
+	return nil
+}
+
+// renderDecorations renders the passages that go before or after every
+// passage: those with Harlowe's tag, or SugarCube's special passage.
+func (r *Runner) renderDecorations(tag string, special string) {
+	if r.out.redirect != nil {
+		return
+	}
+	for _, p := range r.story.order {
+		if r.story.Dialect == Harlowe && p.HasTag(tag) || r.story.Dialect == SugarCube && p.Name == special {
+			r.render(p.Text)
+		}
+	}
+}
+
+// variable returns the value of a story variable.  Harlowe's variables are 0
+// until they're set; SugarCube's are undefined.
+func (r *Runner) variable(name string) interface{} {
+	value, ok := r.vars[name]
+	if !ok && r.story.Dialect == Harlowe {
+		return 0.0
+	}
+	return value
+}
+
+// assign makes assignments like "$x to 1, $y to it + 2" or "$x = 1; _y += 2".
+func (r *Runner) assign(s string) error {
+	p, err := r.newParser(s)
+	if err != nil {
+		return err
+	}
+
+	for {
+		t := p.next()
+		if t.kind != tokenVariable && t.kind != tokenTemp {
+			return fmt.Errorf("expected a variable to set in “%s”", s)
+		}
+		vars := r.vars
+		if t.kind == tokenTemp {
+			vars = r.temps
+		}
+
+		op := p.accept("to", "=", "+=", "-=", "*=", "/=")
+		if op == "" {
+			return fmt.Errorf("expected “to” in “%s”", s)
+		}
+
+		current := vars[t.text]
+		if t.kind == tokenVariable {
+			current = r.variable(t.text)
+		}
+		p.it = current
+
+		value, err := p.expression()
+		if err != nil {
+			return err
+		}
+		if len(op) == 2 && op[1] == '=' {
+			value, err = arithmetic(op[:1], current, value)
+			if err != nil {
+				return err
+			}
+		}
+		vars[t.text] = value
+
+		if p.accept(",", ";") == "" {
+			break
+		}
+	}
+
+	if !p.done() {
+		return fmt.Errorf("I don’t understand “%s”", s)
+	}
+	return nil
+}
+
+// put makes Harlowe's "(put: 1 into $x)" assignments.
+func (r *Runner) put(s string) error {
+	p, err := r.newParser(s)
+	if err != nil {
+		return err
+	}
+
+	for {
+		value, err := p.expression()
+		if err != nil {
+			return err
+		}
+		if p.accept("into") == "" {
+			return fmt.Errorf("expected “into” in “%s”", s)
+		}
+		t := p.next()
+		switch t.kind {
+		case tokenVariable:
+			r.vars[t.text] = value
+		case tokenTemp:
+			r.temps[t.text] = value
+		default:
+			return fmt.Errorf("expected a variable to set in “%s”", s)
+		}
+
+		if p.accept(",") == "" {
+			break
+		}
+	}
+
+	if !p.done() {
+		return fmt.Errorf("I don’t understand “%s”", s)
+	}
+	return nil
+}
+
+// evaluateList evaluates comma-separated expressions, like macro arguments.
+func (r *Runner) evaluateList(s string) ([]interface{}, error) {
+	p, err := r.newParser(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for !p.done() {
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.accept(",") == "" {
+			break
+		}
+	}
+
+	if !p.done() {
+		return nil, fmt.Errorf("I don’t understand “%s”", s)
+	}
+	return values, nil
+}
@@ -0,0 +1,101 @@
+package twine
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The saved state of a story is where the player is, the variables, and the
+// history, along with the links on offer (which can't be worked out again
+// without showing the passage again, which might change the variables).
+// Passages are saved by name, so a state can be loaded into a later version of
+// the same story, as long as the passages are still there.
+
+type savedChoice struct {
+	Text   string
+	Target string `json:",omitempty"`
+	Setter string `json:",omitempty"`
+	Hook   string `json:",omitempty"`
+}
+
+type savedState struct {
+	Passage   string
+	Variables map[string]interface{} `json:",omitempty"`
+	Temps     map[string]interface{} `json:",omitempty"`
+	Visits    map[string]int         `json:",omitempty"`
+	History   []string
+	Turn      int
+	Choices   []savedChoice `json:",omitempty"`
+}
+
+// SaveState returns the state of the story, to be restored with LoadState.
+func (r *Runner) SaveState() ([]byte, error) {
+	s := &savedState{
+		Passage:   r.passage,
+		Variables: r.vars,
+		Temps:     r.temps,
+		Visits:    r.visits,
+		History:   r.history,
+		Turn:      r.turn,
+		Choices:   make([]savedChoice, len(r.choices)),
+	}
+
+	for i, c := range r.choices {
+		s.Choices[i] = savedChoice{
+			Text:   c.Text,
+			Target: c.target,
+			Setter: c.setter,
+			Hook:   c.hook,
+		}
+	}
+
+	return json.Marshal(s)
+}
+
+// LoadState restores a state returned by SaveState.
+func (r *Runner) LoadState(b []byte) error {
+	s := &savedState{}
+	err := json.Unmarshal(b, s)
+	if err != nil {
+		return fmt.Errorf("the saved state is damaged (%s)", err.Error())
+	}
+
+	if r.story.Passage(s.Passage) == nil {
+		return fmt.Errorf("the saved state doesn’t fit this story (there’s no passage called “%s”)", s.Passage)
+	}
+
+	choices := make([]*pendingChoice, len(s.Choices))
+	for i, c := range s.Choices {
+		if c.Target != "" && r.story.Passage(c.Target) == nil {
+			return fmt.Errorf("the saved state doesn’t fit this story (there’s no passage called “%s”)", c.Target)
+		}
+		choices[i] = &pendingChoice{
+			Choice: Choice{Text: c.Text},
+			target: c.Target,
+			setter: c.Setter,
+			hook:   c.Hook,
+		}
+	}
+
+	r.passage = s.Passage
+	r.vars = s.Variables
+	r.temps = s.Temps
+	r.visits = s.Visits
+	r.history = s.History
+	r.turn = s.Turn
+	r.choices = choices
+	r.out = nil
+	r.err = nil
+
+	if r.vars == nil {
+		r.vars = make(map[string]interface{})
+	}
+	if r.temps == nil {
+		r.temps = make(map[string]interface{})
+	}
+	if r.visits == nil {
+		r.visits = make(map[string]int)
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package twine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Twine (https://twinery.org/) stories are collections of passages linked
+// together.  They come in two forms: Twee source, a plain-text format with one
+// "::"-headed section per passage (see
+// https://github.com/iftechfoundation/twine-specs/blob/master/twee-3-specification.md),
+// and published HTML, where the passages are <tw-passagedata> elements (or, for
+// Twine 1, <div tiddler="..."> elements) alongside the story format's
+// JavaScript.
+//
+// We don't run the story format's JavaScript, of course.  Instead, this
+// package understands links and a useful subset of the macros of the two most
+// popular story formats, Harlowe and SugarCube: variables, conditionals,
+// printing, including other passages, and going to other passages.  Stories in
+// other formats can be played as long as they stick to plain links.
+
+// Dialects of passage markup...
+const (
+	Harlowe   = "harlowe"
+	SugarCube = "sugarcube" // also used for Twine 1 formats, which have the same macro syntax
+)
+
+// Passage is one passage of a story.
+type Passage struct {
+	Name string
+	Tags []string
+	Text string
+}
+
+// HasTag reports whether the passage has the given tag.
+func (p *Passage) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Story is a parsed Twine story.
+type Story struct {
+	Title         string
+	Author        string // only Twine 1 stories record this (as "StoryAuthor")
+	IFID          string
+	Format        string // the story format, like "Harlowe"
+	FormatVersion string
+	Start         string // the name of the first passage
+	Dialect       string
+
+	passages map[string]*Passage
+	order    []*Passage
+}
+
+// Passage returns the named passage, or nil.
+func (s *Story) Passage(name string) *Passage {
+	return s.passages[name]
+}
+
+// Passages returns the story's passages, in the order they were written.
+func (s *Story) Passages() []*Passage {
+	return s.order
+}
+
+func (s *Story) add(p *Passage) {
+	if _, ok := s.passages[p.Name]; !ok {
+		s.order = append(s.order, p)
+	}
+	s.passages[p.Name] = p
+}
+
+// storyPassage reports whether the passage is part of the story itself, rather
+// than special passages like "StoryTitle" and scripts.
+func storyPassage(p *Passage) bool {
+	switch p.Name {
+	case "StoryTitle", "StoryData", "StoryAuthor", "StorySubtitle", "StoryMenu",
+		"StoryIncludes", "StorySettings", "StoryBanner", "StoryCaption", "StoryInit":
+		return false
+	}
+	return !p.HasTag("script") && !p.HasTag("stylesheet") && !p.HasTag("Twine.image") &&
+		!p.HasTag("widget") && !p.HasTag("annotation")
+}
+
+var bom = []byte("\xef\xbb\xbf")
+
+// IsStory reports whether the data looks like a Twine story: Twee source, or
+// published HTML.
+func IsStory(b []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(b, bom), " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("::")) {
+		return true
+	}
+	return bytes.Contains(b, []byte("<tw-storydata")) ||
+		(bytes.Contains(b, []byte(`id="storeArea"`)) && bytes.Contains(b, []byte("tiddler=")))
+}
+
+// Parse reads a Twine story, either Twee source or published HTML.
+func Parse(b []byte) (*Story, error) {
+	b = bytes.TrimPrefix(b, bom)
+	s := &Story{passages: make(map[string]*Passage)}
+
+	var err error
+	switch {
+	case bytes.HasPrefix(bytes.TrimLeft(b, " \t\r\n"), []byte("::")):
+		err = s.parseTwee(string(b))
+	case bytes.Contains(b, []byte("<tw-storydata")):
+		err = s.parseHTML(string(b))
+	default:
+		err = s.parseTwine1HTML(string(b))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p := s.passages["StoryTitle"]; p != nil && s.Title == "" {
+		s.Title = strings.TrimSpace(p.Text)
+	}
+	if p := s.passages["StoryAuthor"]; p != nil {
+		s.Author = strings.TrimSpace(p.Text)
+	}
+
+	s.Dialect = dialect(s.Format)
+
+	if s.Start == "" {
+		s.Start = "Start"
+	}
+	if s.passages[s.Start] == nil {
+		for _, p := range s.order {
+			if storyPassage(p) {
+				s.Start = p.Name
+				break
+			}
+		}
+	}
+	if s.passages[s.Start] == nil {
+		return nil, fmt.Errorf("the story doesn’t have any passages")
+	}
+
+	return s, nil
+}
+
+// dialect decides how to read the story's markup from its story format.
+// Twine 2 defaults to Harlowe; Twine 1 (which doesn't record a format) had
+// only SugarCube-style macros.
+func dialect(format string) string {
+	if strings.HasPrefix(strings.ToLower(format), "harlowe") {
+		return Harlowe
+	}
+	return SugarCube
+}
+
+// storyData is the contents of Twee's StoryData passage.
+type storyData struct {
+	IFID          string `json:"ifid"`
+	Format        string `json:"format"`
+	FormatVersion string `json:"format-version"`
+	Start         string `json:"start"`
+}
+
+var tweeEscapes = strings.NewReplacer(`\\`, `\`, `\[`, `[`, `\]`, `]`, `\{`, `{`, `\}`, `}`)
+
+func (s *Story) parseTwee(source string) error {
+	source = strings.Replace(source, "\r\n", "\n", -1)
+
+	var p *Passage
+	var text []string
+	finish := func() {
+		if p != nil {
+			p.Text = strings.TrimRight(strings.Join(text, "\n"), " \t\n")
+			s.add(p)
+		}
+	}
+
+	for _, line := range strings.Split(source, "\n") {
+		if !strings.HasPrefix(line, "::") {
+			// Passage text can't start with "::", so it's escaped.
+			if strings.HasPrefix(line, `\::`) {
+				line = line[1:]
+			}
+			text = append(text, line)
+			continue
+		}
+
+		finish()
+		p = parseTweeHeader(line[2:])
+		text = nil
+	}
+	finish()
+
+	if p := s.passages["StoryData"]; p != nil {
+		data := &storyData{}
+		err := json.Unmarshal([]byte(p.Text), data)
+		if err != nil {
+			return fmt.Errorf("the StoryData passage is damaged (%s)", err.Error())
+		}
+		s.IFID = data.IFID
+		s.Format = data.Format
+		s.FormatVersion = data.FormatVersion
+		s.Start = data.Start
+	}
+
+	return nil
+}
+
+// parseTweeHeader parses the rest of a passage header line: the name, then
+// optional tags ("[a b]") and metadata ("{...}").
+func parseTweeHeader(header string) *Passage {
+	header = strings.TrimSpace(header)
+
+	// Find the end of the name: the first unescaped "[" or "{".
+	end := len(header)
+	for i := 0; i < len(header); i++ {
+		if header[i] == '\\' {
+			i++
+			continue
+		}
+		if header[i] == '[' || header[i] == '{' {
+			end = i
+			break
+		}
+	}
+
+	p := &Passage{Name: tweeEscapes.Replace(strings.TrimSpace(header[:end]))}
+
+	rest := header[end:]
+	if strings.HasPrefix(rest, "[") {
+		if close := strings.Index(rest, "]"); close >= 0 {
+			p.Tags = strings.Fields(tweeEscapes.Replace(rest[1:close]))
+		}
+	}
+
+	return p
+}
+
+var (
+	storyDataPattern   = regexp.MustCompile(`(?s)<tw-storydata\b([^>]*)>`)
+	passageDataPattern = regexp.MustCompile(`(?s)<tw-passagedata\b([^>]*)>(.*?)</tw-passagedata>`)
+	tiddlerPattern     = regexp.MustCompile(`(?s)<div\b([^>]*\btiddler=[^>]*)>(.*?)</div>`)
+	attributePattern   = regexp.MustCompile(`([\w-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+func attributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attributePattern.FindAllStringSubmatch(s, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3])
+	}
+	return attrs
+}
+
+func (s *Story) parseHTML(source string) error {
+	m := storyDataPattern.FindStringSubmatch(source)
+	if m == nil {
+		return fmt.Errorf("the story data is missing")
+	}
+
+	attrs := attributes(m[1])
+	s.Title = attrs["name"]
+	s.IFID = attrs["ifid"]
+	s.Format = attrs["format"]
+	s.FormatVersion = attrs["format-version"]
+	startNode := attrs["startnode"]
+
+	for _, m := range passageDataPattern.FindAllStringSubmatch(source, -1) {
+		attrs := attributes(m[1])
+		p := &Passage{
+			Name: attrs["name"],
+			Tags: strings.Fields(attrs["tags"]),
+			Text: html.UnescapeString(m[2]),
+		}
+		s.add(p)
+		if attrs["pid"] == startNode {
+			s.Start = p.Name
+		}
+	}
+
+	return nil
+}
+
+// Twine 1 escapes newlines, tabs, and backslashes in passage text.
+var twine1Escapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\s`, `\`)
+
+func (s *Story) parseTwine1HTML(source string) error {
+	matches := tiddlerPattern.FindAllStringSubmatch(source, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("it doesn’t contain any Twine passages")
+	}
+
+	for _, m := range matches {
+		attrs := attributes(m[1])
+		s.add(&Passage{
+			Name: attrs["tiddler"],
+			Tags: strings.Fields(attrs["tags"]),
+			Text: twine1Escapes.Replace(html.UnescapeString(m[2])),
+		})
+	}
+
+	return nil
+}
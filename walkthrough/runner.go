@@ -0,0 +1,185 @@
+package walkthrough
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/JaredReisinger/xyzzybot/diff"
+	"github.com/JaredReisinger/xyzzybot/fizmo"
+)
+
+// Default timings...
+const (
+	// DefaultTimeout is how long to wait for the game to respond at all.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultQuiet is how long the game has to be quiet, once it's started
+	// responding, for its response to be complete.
+	DefaultQuiet = 250 * time.Millisecond
+)
+
+// The unchanged lines shown around each change in a diff.
+const diffContext = 3
+
+// Runner plays games through walkthrough scripts.
+type Runner struct {
+	Factory    fizmo.InterpreterFactory
+	WorkingDir string
+	Timeout    time.Duration
+	Quiet      time.Duration
+	Logger     log.FieldLogger
+}
+
+// Result is the outcome of running a script.
+type Result struct {
+	Game   string
+	Script string
+	Passed int
+	Failed int
+	Steps  []*StepResult
+}
+
+// StepResult is the outcome of one step of a script.
+type StepResult struct {
+	Line     int
+	Command  string `json:",omitempty"`
+	Passed   bool
+	Response string
+	Failures []*Failure `json:",omitempty"`
+
+	// Diff compares the expected text (that isn't negated) with the
+	// response, if any expectation failed.
+	Diff string `json:",omitempty"`
+}
+
+// Failure is an expectation that wasn't met.
+type Failure struct {
+	Line        int
+	Expectation string
+}
+
+// Run plays the game through the script.  An error means that the game
+// couldn't be played at all; failed steps are reported in the result.
+func (r *Runner) Run(gameFile string, script *Script) (*Result, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	quiet := r.Quiet
+	if quiet <= 0 {
+		quiet = DefaultQuiet
+	}
+
+	i, err := r.Factory.NewInterpreter(gameFile, r.WorkingDir, log.Fields{
+		"game":   gameFile,
+		"script": script.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := i.GetOutputChannel()
+	err = i.Start()
+	if err != nil {
+		return nil, err
+	}
+	defer i.Kill()
+
+	result := &Result{Game: gameFile, Script: script.Name}
+	ended := false
+	for _, step := range script.Steps {
+		var response string
+		switch {
+		case ended:
+			// The game isn't listening any more.
+		case step.Command == "":
+			response, ended = collect(out, timeout, quiet)
+		default:
+			err = i.Send(step.Command)
+			if err != nil {
+				r.logger().WithError(err).Warn("sending command")
+				ended = true
+				break
+			}
+			response, ended = collect(out, timeout, quiet)
+		}
+
+		sr := check(step, response)
+		if ended && step.Command != "" && response == "" {
+			sr.Passed = false
+			sr.Failures = append(sr.Failures, &Failure{Line: step.Line, Expectation: "the game to respond (it had ended)"})
+		}
+		if sr.Passed {
+			result.Passed++
+		} else {
+			result.Failed++
+		}
+		result.Steps = append(result.Steps, sr)
+	}
+
+	return result, nil
+}
+
+func (r *Runner) logger() log.FieldLogger {
+	return r.Logger.WithField("component", "walkthrough")
+}
+
+// collect gathers the game's response: whatever it says from when it starts
+// responding until it's been quiet for a while.  It also reports whether the
+// game has ended.
+func collect(out chan *fizmo.Output, timeout time.Duration, quiet time.Duration) (string, bool) {
+	texts := make([]string, 0, 1)
+	wait := time.After(timeout)
+	for {
+		select {
+		case output := <-out:
+			if output == nil {
+				return strings.Join(texts, "\n"), true
+			}
+			texts = append(texts, outputText(output))
+			wait = time.After(quiet)
+		case <-wait:
+			return strings.Join(texts, "\n"), false
+		}
+	}
+}
+
+// outputText returns the plain text of the game's output, including any
+// choices it offers (numbered as they're chosen).
+func outputText(output *fizmo.Output) string {
+	lines := fizmo.StoryLines(output.Story)
+	for _, choice := range output.Choices {
+		lines = append(lines, fmt.Sprintf("[%d] %s", choice.Number, choice.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// check compares a step's response to its expectations.
+func check(step *Step, response string) *StepResult {
+	sr := &StepResult{
+		Line:     step.Line,
+		Command:  step.Command,
+		Passed:   true,
+		Response: response,
+	}
+
+	expected := make([]string, 0, len(step.Expectations))
+	for _, e := range step.Expectations {
+		if !e.Negated {
+			expected = append(expected, e.Text)
+		}
+		if !e.Matches(response) {
+			sr.Passed = false
+			sr.Failures = append(sr.Failures, &Failure{Line: e.Line, Expectation: e.String()})
+		}
+	}
+
+	if !sr.Passed {
+		sr.Diff = diff.Changes(diff.Lines(strings.Join(expected, "\n"), response), diffContext)
+	}
+
+	return sr
+}
@@ -0,0 +1,157 @@
+package walkthrough
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A walkthrough script is a list of commands to send to a game, each followed
+// by what the game's response should contain:
+//
+//     # The opening is checked by anything before the first command.
+//     Curses
+//     /Release \d+/
+//
+//     > inventory
+//     You are carrying
+//     !I don't know the word
+//
+// Lines starting with ">" are commands.  Other lines are expectations: the
+// response has to contain the text, or match the regular expression (between
+// slashes), or not contain the text (after "!").  Blank lines and lines
+// starting with "#" are ignored, and a "\" at the start of a line is dropped,
+// so that an expectation can start with one of the special characters.
+
+// Step is a command and what its response should contain.  The first step of
+// a script, for the game's opening, has no command.
+type Step struct {
+	Line         int // in the script
+	Command      string
+	Expectations []*Expectation
+}
+
+// Expectation is something a response has to contain (or not).
+type Expectation struct {
+	Line    int
+	Text    string // the text, or the regular expression, from the script
+	Regexp  bool
+	Negated bool
+
+	pattern *regexp.Regexp
+}
+
+// Script is a parsed walkthrough script.
+type Script struct {
+	Name  string
+	Steps []*Step
+}
+
+// ReadFile parses a walkthrough script file.
+func ReadFile(file string) (*Script, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	s.Name = file
+	return s, nil
+}
+
+// Parse parses a walkthrough script.
+func Parse(r io.Reader) (*Script, error) {
+	s := &Script{}
+	step := &Step{}
+	s.Steps = append(s.Steps, step)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case text == "" || strings.HasPrefix(text, "#"):
+			continue
+
+		case strings.HasPrefix(text, ">"):
+			step = &Step{Line: line, Command: strings.TrimSpace(text[1:])}
+			s.Steps = append(s.Steps, step)
+			continue
+		}
+
+		e, err := parseExpectation(line, text)
+		if err != nil {
+			return nil, err
+		}
+		step.Expectations = append(step.Expectations, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(s.Steps) == 1 && len(s.Steps[0].Expectations) == 0 {
+		return nil, fmt.Errorf("the script doesn’t have any commands or expectations")
+	}
+
+	return s, nil
+}
+
+func parseExpectation(line int, text string) (*Expectation, error) {
+	e := &Expectation{Line: line}
+
+	if strings.HasPrefix(text, "!") {
+		e.Negated = true
+		text = strings.TrimSpace(text[1:])
+	}
+
+	if len(text) >= 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/") {
+		e.Regexp = true
+		e.Text = text[1 : len(text)-1]
+		pattern, err := regexp.Compile(e.Text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err.Error())
+		}
+		e.pattern = pattern
+		return e, nil
+	}
+
+	e.Text = strings.TrimPrefix(text, `\`)
+	return e, nil
+}
+
+// Matches reports whether a response meets the expectation.
+func (e *Expectation) Matches(response string) bool {
+	var found bool
+	if e.Regexp {
+		found = e.pattern.MatchString(response)
+	} else {
+		found = strings.Contains(normalizeSpace(response), normalizeSpace(e.Text))
+	}
+	return found != e.Negated
+}
+
+func (e *Expectation) String() string {
+	text := e.Text
+	if e.Regexp {
+		text = "/" + text + "/"
+	}
+	if e.Negated {
+		return "not " + text
+	}
+	return text
+}
+
+// normalizeSpace collapses runs of whitespace (games wrap and indent their
+// text as they please) to single spaces.
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
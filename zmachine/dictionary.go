@@ -0,0 +1,385 @@
+package zmachine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The dictionary is the game's vocabulary: every word its parser understands,
+// encoded as Z-characters and truncated to the Z-machine's "resolution" (6
+// Z-characters before version 4, 9 after), along with the word separators (like
+// "," and ".") that are words in their own right.  Each entry is followed by
+// data bytes that the game's parser uses; the first of these says what parts of
+// speech the word can be, though Infocom's and Inform's parsers use different
+// bits.  See sections 3 and 13 of the Z-Machine Standards Document.
+
+// Word is a dictionary word.
+type Word struct {
+	Text        string
+	Verb        bool
+	Noun        bool
+	Preposition bool
+	Direction   bool // only Infocom games mark these; Inform's are nouns
+	Meta        bool // like "save" and "quit"; only Inform games mark these
+}
+
+// Dictionary is a story's vocabulary.
+type Dictionary struct {
+	Separators string
+	Words      []*Word
+
+	resolution int // in Z-characters
+	alphabets  [3]string
+	index      map[string]*Word
+}
+
+// Parts-of-speech flags...
+const (
+	informVerb        = 0x01
+	informMeta        = 0x02
+	informPreposition = 0x08
+	informNoun        = 0x80
+
+	infocomNoun        = 0x80
+	infocomVerb        = 0x40
+	infocomDirection   = 0x10
+	infocomPreposition = 0x08
+)
+
+var defaultAlphabets = [3]string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	" \n0123456789.,!?_#'\"/\\-:()", // the first two are really the escape and newline
+}
+
+// Version 1 has no newline in A2, and has "<" instead.
+const v1Alphabet2 = " 0123456789.,!?_#'\"/\\<-:()"
+
+// ReadDictionary loads a story file (bare or Blorb-wrapped) and parses its
+// dictionary.
+func ReadDictionary(file string) (*Dictionary, error) {
+	story, err := LoadStory(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseDictionary(story)
+}
+
+// ParseDictionary parses the dictionary of the given story data.
+func ParseDictionary(story []byte) (*Dictionary, error) {
+	h, err := ParseHeader(story)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dictionary{
+		resolution: 9,
+		alphabets:  defaultAlphabets,
+		index:      make(map[string]*Word),
+	}
+	textLength := 6
+	if h.Version <= 3 {
+		d.resolution = 6
+		textLength = 4
+	}
+	if h.Version == 1 {
+		d.alphabets[2] = v1Alphabet2
+	}
+
+	// Version 5 and later games can have their own alphabets.
+	if h.Version >= 5 {
+		if table := int(binary.BigEndian.Uint16(story[0x34:])); table != 0 {
+			if table+78 > len(story) {
+				return nil, fmt.Errorf("the alphabet table lies outside the story file")
+			}
+			for a := 0; a < 3; a++ {
+				d.alphabets[a] = string(story[table+26*a : table+26*(a+1)])
+			}
+			d.alphabets[2] = " \n" + d.alphabets[2][2:]
+		}
+	}
+
+	at := h.Dictionary
+	get := func(n int) ([]byte, error) {
+		if at+n > len(story) {
+			return nil, fmt.Errorf("the dictionary runs past the end of the story file")
+		}
+		b := story[at : at+n]
+		at += n
+		return b, nil
+	}
+
+	b, err := get(1)
+	if err != nil {
+		return nil, err
+	}
+	separators, err := get(int(b[0]))
+	if err != nil {
+		return nil, err
+	}
+	d.Separators = string(separators)
+
+	b, err = get(3)
+	if err != nil {
+		return nil, err
+	}
+	entryLength := int(b[0])
+	count := int(int16(binary.BigEndian.Uint16(b[1:])))
+	if count < 0 {
+		// (A negative count means the entries aren't sorted.)
+		count = -count
+	}
+	if entryLength < textLength+1 {
+		return nil, fmt.Errorf("the dictionary entries are too short (%d bytes)", entryLength)
+	}
+
+	// Inform games say so in the header; anything else is assumed to be
+	// Infocom's (or compatible).
+	inform := h.InformVersion != ""
+
+	d.Words = make([]*Word, 0, count)
+	for i := 0; i < count; i++ {
+		entry, err := get(entryLength)
+		if err != nil {
+			return nil, err
+		}
+
+		w := &Word{Text: d.decode(entry[:textLength], h.Version)}
+		flags := entry[textLength]
+		if inform {
+			w.Verb = flags&informVerb != 0
+			w.Meta = flags&informMeta != 0
+			w.Preposition = flags&informPreposition != 0
+			w.Noun = flags&informNoun != 0
+		} else {
+			w.Verb = flags&infocomVerb != 0
+			w.Direction = flags&infocomDirection != 0
+			w.Preposition = flags&infocomPreposition != 0
+			w.Noun = flags&infocomNoun != 0
+		}
+
+		d.Words = append(d.Words, w)
+		if _, ok := d.index[w.Text]; !ok {
+			d.index[w.Text] = w
+		}
+	}
+
+	return d, nil
+}
+
+// decode decodes dictionary text.  (Dictionary words don't use abbreviations,
+// so we don't bother with them.)
+func (d *Dictionary) decode(b []byte, version int) string {
+	zchars := make([]int, 0, len(b)/2*3)
+	for i := 0; i+1 < len(b); i += 2 {
+		w := int(binary.BigEndian.Uint16(b[i:]))
+		zchars = append(zchars, (w>>10)&0x1F, (w>>5)&0x1F, w&0x1F)
+	}
+
+	var text strings.Builder
+	lock := 0 // versions 1 and 2 have shift locks
+	alphabet := 0
+	for i := 0; i < len(zchars); i++ {
+		z := zchars[i]
+		current := alphabet
+		alphabet = lock
+
+		switch {
+		case z == 0:
+			text.WriteByte(' ')
+		case z == 1 && version == 1:
+			text.WriteByte('\n')
+		case z <= 3 && version <= 2 && z >= 2:
+			alphabet = (lock + z - 1) % 3
+		case z <= 5 && version <= 2 && z >= 4:
+			lock = (lock + z - 3) % 3
+			alphabet = lock
+		case z <= 3:
+			// Abbreviations (which dictionary words don't use).
+		case z <= 5:
+			alphabet = z - 3
+		case current == 2 && z == 6:
+			// A 10-bit ZSCII character follows.
+			if i+2 < len(zchars) {
+				text.WriteRune(zsciiRune(zchars[i+1]<<5 | zchars[i+2]))
+			}
+			i += 2
+		default:
+			text.WriteByte(d.alphabets[current][z-6])
+		}
+	}
+
+	// Words shorter than the resolution are padded with 5s, which we've
+	// turned into shifts, but a padded word can also end in spaces.
+	return strings.TrimRight(text.String(), " ")
+}
+
+func zsciiRune(c int) rune {
+	if c >= 32 && c <= 126 {
+		return rune(c)
+	}
+	return '?'
+}
+
+// Lookup returns the dictionary word that the given word matches, or nil.
+// Like the game's parser, it ignores case and what's past the resolution.
+func (d *Dictionary) Lookup(word string) *Word {
+	return d.index[d.truncate(strings.ToLower(word))]
+}
+
+// truncate cuts a word to what would fit in a dictionary entry.
+func (d *Dictionary) truncate(word string) string {
+	used := 0
+	for i, c := range word {
+		cost := 4 // a ZSCII escape
+		switch {
+		case strings.ContainsRune(d.alphabets[0], c):
+			cost = 1
+		case c != ' ' && c != '\n' && strings.ContainsRune(d.alphabets[2], c):
+			cost = 2
+		}
+		if used+cost > d.resolution {
+			return word[:i]
+		}
+		used += cost
+	}
+	return word
+}
+
+// Tokenize splits a command into words the way the game's parser does: at
+// spaces, and around the separators (which are words themselves).
+func (d *Dictionary) Tokenize(command string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, c := range strings.ToLower(command) {
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case strings.ContainsRune(d.Separators, c):
+			flush()
+			words = append(words, string(c))
+		default:
+			word.WriteRune(c)
+		}
+	}
+	flush()
+
+	return words
+}
+
+// Verbs returns the words the game understands as verbs, in alphabetical order.
+// (Inform's debugging verbs, like "#list", aren't included.)
+func (d *Dictionary) Verbs() []string {
+	verbs := make([]string, 0)
+	for _, w := range d.Words {
+		if w.Verb && isWord(w.Text) {
+			verbs = append(verbs, w.Text)
+		}
+	}
+	sort.Strings(verbs)
+	return verbs
+}
+
+// Similar returns up to max dictionary words that are closest to the given
+// (presumably unknown) word, closest first.  If verbs is true, only verbs are
+// considered.
+func (d *Dictionary) Similar(word string, max int, verbs bool) []string {
+	key := d.truncate(strings.ToLower(word))
+	if key == "" {
+		return nil
+	}
+
+	// Allow roughly one typo for every three letters.
+	limit := len([]rune(key)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+
+	type candidate struct {
+		text     string
+		distance int
+	}
+	candidates := make([]*candidate, 0)
+
+	for _, w := range d.Words {
+		if verbs && !w.Verb || !isWord(w.Text) {
+			continue
+		}
+		// (The key is cut to the resolution, just like the dictionary's words.)
+		if distance := editDistance(key, w.Text); distance <= limit {
+			candidates = append(candidates, &candidate{w.Text, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].text < candidates[j].text
+	})
+
+	words := make([]string, 0, max)
+	for _, c := range candidates {
+		if len(words) == max {
+			break
+		}
+		words = append(words, c.text)
+	}
+	return words
+}
+
+// isWord reports whether dictionary text is a word a player would type,
+// rather than punctuation or a debugging verb.
+func isWord(text string) bool {
+	for _, c := range text {
+		return unicode.IsLetter(c)
+	}
+	return false
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,64 @@
+package zmachine
+
+import (
+	"testing"
+)
+
+func TestParseDictionary(t *testing.T) {
+	tests := []struct {
+		game  string
+		words int
+		verbs int
+		known []string
+	}{
+		{"curses.z5", 1348, 282, []string{"xyzzy", "look", "inventory", "take"}},
+		{"dreamhold.z8", 1337, 245, []string{"look", "inventory", "take"}},
+		{"LostPig.zblorb", 1724, 413, []string{"look", "inventory", "take", "frotz"}},
+	}
+
+	for _, test := range tests {
+		d, err := ParseDictionary(loadSample(t, test.game))
+		if err != nil {
+			t.Errorf("%s: %v", test.game, err)
+			continue
+		}
+
+		if len(d.Words) != test.words {
+			t.Errorf("%s: got %d words, want %d", test.game, len(d.Words), test.words)
+		}
+		if verbs := d.Verbs(); len(verbs) != test.verbs {
+			t.Errorf("%s: got %d verbs, want %d", test.game, len(verbs), test.verbs)
+		}
+		if d.Separators != `.,"` {
+			t.Errorf("%s: got separators %q", test.game, d.Separators)
+		}
+
+		for _, word := range test.known {
+			w := d.Lookup(word)
+			if w == nil || !w.Verb {
+				t.Errorf("%s: %q isn’t a verb in the dictionary", test.game, word)
+			}
+		}
+		if w := d.Lookup("zzyzx"); w != nil {
+			t.Errorf("%s: found a word that isn’t there: %+v", test.game, w)
+		}
+	}
+}
+
+func TestParseDictionaryTruncated(t *testing.T) {
+	for _, name := range sampleGames {
+		story := loadSample(t, name)
+		h, err := ParseHeader(story)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Cut the story off partway through its dictionary.
+		for _, n := range []int{h.Dictionary, h.Dictionary + 1, h.Dictionary + 5, h.Dictionary + 100} {
+			_, err := ParseDictionary(story[:n])
+			if err == nil {
+				t.Errorf("%s: parsed a dictionary cut off at %d bytes", name, n)
+			}
+		}
+	}
+}
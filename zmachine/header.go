@@ -0,0 +1,181 @@
+package zmachine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/JaredReisinger/xyzzybot/iff"
+)
+
+// HeaderSize is the size of the Z-machine story file header.
+const HeaderSize = 0x40
+
+// Header contains the interesting parts of a Z-machine story file header.  See
+// section 11 of the Z-Machine Standards Document for the gory details.
+type Header struct {
+	Version        int
+	Release        int
+	Serial         string
+	Checksum       uint16
+	FileLength     int64 // as declared in the header (already scaled)
+	HighMemory     int
+	InitialPC      int
+	Dictionary     int
+	ObjectTable    int
+	Globals        int
+	StaticMemory   int
+	Abbreviations  int
+	InformVersion  string // compiler version, for Inform-compiled games
+	ActualChecksum uint16 // computed from the story data
+	ActualLength   int64  // the number of bytes actually present
+}
+
+// ParseHeader parses the header at the beginning of the given story data.  The
+// full story data is needed in order to compute the actual checksum.
+func ParseHeader(story []byte) (*Header, error) {
+	if len(story) < HeaderSize {
+		return nil, fmt.Errorf("story data is only %d bytes, too short for a Z-machine header", len(story))
+	}
+
+	version := int(story[0])
+	if version < 1 || version > 8 {
+		return nil, fmt.Errorf("unknown Z-machine version %d", version)
+	}
+
+	h := &Header{
+		Version:       version,
+		Release:       int(binary.BigEndian.Uint16(story[0x02:])),
+		HighMemory:    int(binary.BigEndian.Uint16(story[0x04:])),
+		InitialPC:     int(binary.BigEndian.Uint16(story[0x06:])),
+		Dictionary:    int(binary.BigEndian.Uint16(story[0x08:])),
+		ObjectTable:   int(binary.BigEndian.Uint16(story[0x0A:])),
+		Globals:       int(binary.BigEndian.Uint16(story[0x0C:])),
+		StaticMemory:  int(binary.BigEndian.Uint16(story[0x0E:])),
+		Serial:        string(story[0x12:0x18]),
+		Abbreviations: int(binary.BigEndian.Uint16(story[0x18:])),
+		Checksum:      binary.BigEndian.Uint16(story[0x1C:]),
+		ActualLength:  int64(len(story)),
+	}
+
+	// The file length is stored in a version-dependent unit.
+	length := int64(binary.BigEndian.Uint16(story[0x1A:]))
+	switch {
+	case version <= 3:
+		length *= 2
+	case version <= 5:
+		length *= 4
+	default:
+		length *= 8
+	}
+	h.FileLength = length
+	if length != 0 && length < HeaderSize {
+		return nil, fmt.Errorf("the header says the story is %d bytes long, which is shorter than the header itself", length)
+	}
+
+	if isPrintable(story[0x3C:0x40]) {
+		h.InformVersion = string(story[0x3C:0x40])
+	}
+
+	// The checksum covers everything after the header, up to the declared file
+	// length.  (Very early games have no length, and thus no checksum.)
+	end := h.FileLength
+	if end == 0 || end > h.ActualLength {
+		end = h.ActualLength
+	}
+	var sum uint16
+	for _, b := range story[HeaderSize:end] {
+		sum += uint16(b)
+	}
+	h.ActualChecksum = sum
+
+	return h, nil
+}
+
+// Validate performs some sanity checks on the header, returning a descriptive
+// error if it doesn't look like a real story file.
+func (h *Header) Validate() error {
+	if h.FileLength > h.ActualLength {
+		return fmt.Errorf("the header says the story is %d bytes long, but only %d bytes are present (was the file truncated?)", h.FileLength, h.ActualLength)
+	}
+
+	if h.StaticMemory < HeaderSize || int64(h.StaticMemory) > h.ActualLength {
+		return fmt.Errorf("the static memory base (0x%04x) is outside the story file", h.StaticMemory)
+	}
+
+	if h.HighMemory < HeaderSize || int64(h.HighMemory) > h.ActualLength {
+		return fmt.Errorf("the high memory base (0x%04x) is outside the story file", h.HighMemory)
+	}
+
+	if h.Dictionary < HeaderSize || int64(h.Dictionary) >= h.ActualLength ||
+		h.ObjectTable < HeaderSize || int64(h.ObjectTable) >= h.ActualLength {
+		return fmt.Errorf("the dictionary or object table lies outside the story file")
+	}
+
+	return nil
+}
+
+// ChecksumValid reports whether the story data matches the header's checksum.
+// (Very early games have no declared length, and thus no checksum.)
+func (h *Header) ChecksumValid() bool {
+	return h.FileLength == 0 || h.Checksum == h.ActualChecksum
+}
+
+// String returns a human-friendly description like "release 2 / 080406".
+func (h *Header) String() string {
+	return fmt.Sprintf("release %d / %s", h.Release, h.Serial)
+}
+
+// LoadStory reads the Z-code story data from a file, unwrapping it from a
+// Blorb container if necessary.
+func LoadStory(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnwrapStory(b)
+}
+
+// UnwrapStory returns the Z-code story data from either a bare story file or a
+// Blorb container.
+func UnwrapStory(b []byte) ([]byte, error) {
+	if !iff.IsForm(b, "") {
+		return b, nil
+	}
+
+	form, err := iff.ParseForm(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if form.Type != "IFRS" {
+		return nil, fmt.Errorf("IFF file of type %q is not a Blorb", form.Type)
+	}
+
+	exec := form.Chunk("ZCOD")
+	if exec == nil {
+		return nil, fmt.Errorf("Blorb file does not contain Z-code")
+	}
+
+	return exec.Data, nil
+}
+
+// ReadHeader loads a story file (bare or Blorb-wrapped) and parses its header.
+func ReadHeader(file string) (*Header, error) {
+	story, err := LoadStory(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseHeader(story)
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7E {
+			return false
+		}
+	}
+	return true
+}
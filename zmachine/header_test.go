@@ -0,0 +1,135 @@
+package zmachine
+
+import (
+	"fmt"
+	"path"
+	"testing"
+)
+
+var sampleGames = []string{"curses.z5", "dreamhold.z8", "LostPig.zblorb"}
+
+func loadSample(t *testing.T, name string) []byte {
+	t.Helper()
+	story, err := LoadStory(path.Join("..", "sample-games", name))
+	if err != nil {
+		t.Fatalf("loading %s: %v", name, err)
+	}
+	return story
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		game          string
+		version       int
+		release       int
+		serial        string
+		checksum      uint16
+		fileLength    int64
+		actualLength  int64
+		dictionary    int
+		objectTable   int
+		staticMemory  int
+		highMemory    int
+		informVersion string
+	}{
+		{"curses.z5", 5, 16, "951024", 0x4DE6, 259036, 259072, 29615, 432, 26163, 41756, ""},
+		{"dreamhold.z8", 8, 5, "041231", 0x9408, 386120, 386560, 43916, 266, 40753, 55960, "6.21"},
+		{"LostPig.zblorb", 8, 2, "080406", 0xA377, 285080, 285184, 49927, 492, 42554, 65456, "6.30"},
+	}
+
+	for _, test := range tests {
+		h, err := ParseHeader(loadSample(t, test.game))
+		if err != nil {
+			t.Errorf("%s: %v", test.game, err)
+			continue
+		}
+
+		got := []interface{}{h.Version, h.Release, h.Serial, h.Checksum, h.FileLength, h.ActualLength,
+			h.Dictionary, h.ObjectTable, h.StaticMemory, h.HighMemory, h.InformVersion}
+		want := []interface{}{test.version, test.release, test.serial, test.checksum, test.fileLength, test.actualLength,
+			test.dictionary, test.objectTable, test.staticMemory, test.highMemory, test.informVersion}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got %v, want %v", test.game, got, want)
+		}
+
+		if err := h.Validate(); err != nil {
+			t.Errorf("%s: %v", test.game, err)
+		}
+		if !h.ChecksumValid() {
+			t.Errorf("%s: checksum 0x%04X doesn’t match the data (0x%04X)", test.game, h.Checksum, h.ActualChecksum)
+		}
+		if s := h.String(); s != fmt.Sprintf("release %d / %s", test.release, test.serial) {
+			t.Errorf("%s: String() = %q", test.game, s)
+		}
+	}
+}
+
+func TestParseHeaderModified(t *testing.T) {
+	story := append([]byte(nil), loadSample(t, "curses.z5")...)
+	story[len(story)-100]++
+
+	h, err := ParseHeader(story)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ChecksumValid() {
+		t.Error("a modified story still has a valid checksum")
+	}
+}
+
+// mutations are damaged copies of a story: cut short, or with a header field
+// set to something unlikely.
+func mutations(story []byte) map[string][]byte {
+	m := make(map[string][]byte)
+
+	for _, n := range []int{0, 1, HeaderSize - 1, HeaderSize, HeaderSize + 1, len(story) / 2, len(story) - 1} {
+		m[fmt.Sprintf("truncated to %d", n)] = story[:n]
+	}
+
+	for _, length := range []uint16{0x0001, 0x0004, 0x0007, 0x0008, 0x0010, 0xFFFF} {
+		b := append([]byte(nil), story...)
+		b[0x1A], b[0x1B] = byte(length>>8), byte(length)
+		m[fmt.Sprintf("file length 0x%04X", length)] = b
+	}
+
+	// Every header field, zeroed and maxed.
+	for offset := 0; offset < HeaderSize; offset++ {
+		for _, v := range []byte{0x00, 0xFF} {
+			b := append([]byte(nil), story...)
+			b[offset] = v
+			m[fmt.Sprintf("header byte 0x%02X = 0x%02X", offset, v)] = b
+		}
+	}
+
+	return m
+}
+
+func TestParseHeaderDamaged(t *testing.T) {
+	for _, name := range sampleGames {
+		for desc, story := range mutations(loadSample(t, name)) {
+			h, err := ParseHeader(story)
+			if err != nil {
+				continue
+			}
+			h.Validate()
+			h.ChecksumValid()
+
+			// (The dictionary is parsed from the same header.)
+			ParseDictionary(story)
+
+			if len(story) < HeaderSize {
+				t.Errorf("%s %s: parsed a header from only %d bytes", name, desc, len(story))
+			}
+		}
+	}
+}
+
+func TestParseHeaderShortFileLength(t *testing.T) {
+	story := append([]byte(nil), loadSample(t, "curses.z5")...)
+	story[0x1A], story[0x1B] = 0x00, 0x01 // 4 bytes, in version 5
+
+	_, err := ParseHeader(story)
+	if err == nil {
+		t.Error("expected an error for a file length shorter than the header")
+	}
+}